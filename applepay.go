@@ -0,0 +1,38 @@
+package stripeutil
+
+import (
+	"encoding/json"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+var applePayDomainEndpoint = "/v1/apple_pay/domains"
+
+// RegisterApplePayDomain will register the given domain with Stripe so that it
+// can be used for accepting payments via Apple Pay on the web.
+func RegisterApplePayDomain(s *Stripe, domain string) error {
+	var d stripe.ApplePayDomain
+
+	return s.post(applePayDomainEndpoint, Params{"domain_name": domain}, &d)
+}
+
+// ListApplePayDomains returns all of the domains that have been registered
+// with Stripe for Apple Pay.
+func ListApplePayDomains(s *Stripe) ([]*stripe.ApplePayDomain, error) {
+	domains := make([]*stripe.ApplePayDomain, 0)
+
+	err := s.list(applePayDomainEndpoint, func(raw json.RawMessage) error {
+		d := &stripe.ApplePayDomain{}
+
+		if err := s.unmarshal(raw, d); err != nil {
+			return err
+		}
+		domains = append(domains, d)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return domains, nil
+}
@@ -0,0 +1,115 @@
+package stripeutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_ApplePayDomains(t *testing.T) {
+	registered := make([]string, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/apple_pay/domains") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		if r.Method == "POST" {
+			r.ParseForm()
+
+			domain := r.PostForm.Get("domain_name")
+			registered = append(registered, domain)
+
+			w.Write([]byte(`{"id": "apwc_` + domain + `", "domain_name": "` + domain + `"}`))
+			return
+		}
+
+		if r.URL.Query().Get("limit") != "100" {
+			t.Errorf("unexpected limit, expected=%q, got=%q\n", "100", r.URL.Query().Get("limit"))
+		}
+
+		// Serve the registered domains one per page.
+		after := r.URL.Query().Get("starting_after")
+
+		i := 0
+
+		if after != "" {
+			for j, domain := range registered {
+				if "apwc_"+domain == after {
+					i = j + 1
+				}
+			}
+		}
+
+		data := make([]map[string]string, 0)
+
+		if i < len(registered) {
+			data = append(data, map[string]string{"id": "apwc_" + registered[i], "domain_name": registered[i]})
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"has_more": i+1 < len(registered),
+			"data":     data,
+		})
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	expected := []string{"example.com", "shop.example.com"}
+
+	for _, domain := range expected {
+		if err := RegisterApplePayDomain(s, domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	domains, err := ListApplePayDomains(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domains) != len(expected) {
+		t.Fatalf("unexpected domains, expected=%d, got=%d\n", len(expected), len(domains))
+	}
+
+	for i, d := range domains {
+		if d.DomainName != expected[i] {
+			t.Errorf("domains[%d] - unexpected domain, expected=%q, got=%q\n", i, expected[i], d.DomainName)
+		}
+	}
+}
+
+func Test_ListLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limits := r.URL.Query()["limit"]; len(limits) != 1 || limits[0] != "10" {
+			t.Errorf("unexpected limit, expected=%q, got=%q\n", "10", limits)
+		}
+		w.Write([]byte(`{"has_more": false, "data": [{"id": "obj_123456"}]}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	n := 0
+
+	err := s.list("/v1/objects?limit=10", func(_ json.RawMessage) error {
+		n++
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("unexpected objects, expected=%d, got=%d\n", 1, n)
+	}
+}
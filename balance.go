@@ -0,0 +1,44 @@
+package stripeutil
+
+import (
+	"encoding/json"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+var balanceTransactionEndpoint = "/v1/balance_transactions"
+
+// ListBalanceTransactions returns all of the balance transactions from Stripe
+// that match the given Params. The Params are passed through as the query
+// string of the request, so can be used for filtering by date, for example,
+//
+//     txns, err := stripeutil.ListBalanceTransactions(stripe, stripeutil.Params{
+//         "created": stripeutil.Params{
+//             "gte": from.Unix(),
+//             "lt":  to.Unix(),
+//         },
+//     })
+func ListBalanceTransactions(s *Stripe, params Params) ([]*stripe.BalanceTransaction, error) {
+	uri := balanceTransactionEndpoint
+
+	if len(params) > 0 {
+		uri += "?" + params.Encode()
+	}
+
+	txns := make([]*stripe.BalanceTransaction, 0)
+
+	err := s.list(uri, func(raw json.RawMessage) error {
+		txn := &stripe.BalanceTransaction{}
+
+		if err := s.unmarshal(raw, txn); err != nil {
+			return err
+		}
+		txns = append(txns, txn)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return txns, nil
+}
@@ -0,0 +1,72 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ListBalanceTransactions(t *testing.T) {
+	from := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/balance_transactions") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		q := r.URL.Query()
+
+		if gte := q.Get("created[gte]"); gte != strconv.FormatInt(from.Unix(), 10) {
+			t.Errorf("unexpected created[gte], expected=%d, got=%q\n", from.Unix(), gte)
+		}
+
+		if lt := q.Get("created[lt]"); lt != strconv.FormatInt(to.Unix(), 10) {
+			t.Errorf("unexpected created[lt], expected=%d, got=%q\n", to.Unix(), lt)
+		}
+
+		if q.Get("starting_after") == "" {
+			w.Write([]byte(`{"has_more": true, "data": [{"id": "txn_123456", "amount": 1000, "fee": 59, "net": 941}]}`))
+			return
+		}
+		w.Write([]byte(`{"has_more": false, "data": [{"id": "txn_654321", "amount": 2000, "fee": 88, "net": 1912}]}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	txns, err := ListBalanceTransactions(s, Params{
+		"created": Params{
+			"gte": from.Unix(),
+			"lt":  to.Unix(),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		id  string
+		net int64
+	}{
+		{"txn_123456", 941},
+		{"txn_654321", 1912},
+	}
+
+	if len(txns) != len(expected) {
+		t.Fatalf("unexpected balance transactions, expected=%d, got=%d\n", len(expected), len(txns))
+	}
+
+	for i, txn := range txns {
+		if txn.ID != expected[i].id || txn.Net != expected[i].net {
+			t.Errorf("txns[%d] - unexpected balance transaction, expected=%q (%d), got=%q (%d)\n", i, expected[i].id, expected[i].net, txn.ID, txn.Net)
+		}
+	}
+}
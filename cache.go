@@ -0,0 +1,207 @@
+package stripeutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// CachingStore is a Store that caches the Customers, Subscriptions, and
+// default PaymentMethods looked up from the underlying Store for a period of
+// time. Putting or removing a resource will invalidate what was cached for it,
+// so a lookup made after a write will never return what was previously cached.
+// Customers are cached against their email, and Subscriptions and
+// PaymentMethods are cached against the ID of their Customer. Only resources
+// that are found are cached. The cached resources are copied when cached, and
+// when returned, so changes made to a returned resource are not cached.
+//
+// If the underlying Store is an EventStore then the payloads of events are
+// logged to it, so wrapping a store such as PSQL does not stop the events
+// received by a HookHandler from being stored.
+type CachingStore struct {
+	Store
+
+	ttl time.Duration
+	now func() time.Time
+
+	mu             sync.Mutex
+	gen            uint64
+	customers      map[string]cacheEntry
+	subscriptions  map[string]cacheEntry
+	paymentMethods map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+var (
+	_ Store      = (*CachingStore)(nil)
+	_ EventStore = (*CachingStore)(nil)
+)
+
+// NewCachingStore returns a CachingStore that wraps the given Store, and caches
+// lookups for the given duration.
+func NewCachingStore(s Store, ttl time.Duration) *CachingStore {
+	return &CachingStore{
+		Store:          s,
+		ttl:            ttl,
+		now:            time.Now,
+		customers:      make(map[string]cacheEntry),
+		subscriptions:  make(map[string]cacheEntry),
+		paymentMethods: make(map[string]cacheEntry),
+	}
+}
+
+// get returns the cached value for the given key, along with the current
+// generation of the cache. The generation should be passed to set when caching
+// a value that was looked up, this ensures a value looked up before an
+// invalidation is not cached after it.
+func (c *CachingStore) get(m map[string]cacheEntry, key string) (interface{}, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := m[key]
+
+	if !ok {
+		return nil, false, c.gen
+	}
+
+	if !c.now().Before(e.expires) {
+		delete(m, key)
+		return nil, false, c.gen
+	}
+	return e.value, true, c.gen
+}
+
+func (c *CachingStore) set(m map[string]cacheEntry, key string, value interface{}, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	m[key] = cacheEntry{
+		value:   value,
+		expires: c.now().Add(c.ttl),
+	}
+}
+
+// invalidate removes the cached values for the given Resource, and moves the
+// cache on to the next generation.
+func (c *CachingStore) invalidate(r Resource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	switch v := r.(type) {
+	case *Customer:
+		for key, e := range c.customers {
+			if cached := e.value.(*Customer); cached.ID == v.ID || cached.Email == v.Email {
+				delete(c.customers, key)
+			}
+		}
+	case *Subscription:
+		if id := customerID(v.Customer); id != "" {
+			delete(c.subscriptions, id)
+			break
+		}
+		c.subscriptions = make(map[string]cacheEntry)
+	case *PaymentMethod:
+		if id := customerID(v.Customer); id != "" {
+			delete(c.paymentMethods, id)
+			break
+		}
+		c.paymentMethods = make(map[string]cacheEntry)
+	}
+}
+
+// LookupCustomer implements the Store interface.
+func (c *CachingStore) LookupCustomer(email string) (*Customer, bool, error) {
+	v, ok, gen := c.get(c.customers, email)
+
+	if ok {
+		return v.(*Customer).copy(), true, nil
+	}
+
+	cust, ok, err := c.Store.LookupCustomer(email)
+
+	if err != nil || !ok {
+		return cust, ok, err
+	}
+
+	c.set(c.customers, email, cust.copy(), gen)
+	return cust, true, nil
+}
+
+// Subscription implements the Store interface.
+func (c *CachingStore) Subscription(cust *Customer) (*Subscription, bool, error) {
+	v, ok, gen := c.get(c.subscriptions, cust.ID)
+
+	if ok {
+		return v.(*Subscription).copy(), true, nil
+	}
+
+	sub, ok, err := c.Store.Subscription(cust)
+
+	if err != nil || !ok {
+		return sub, ok, err
+	}
+
+	c.set(c.subscriptions, cust.ID, sub.copy(), gen)
+	return sub, true, nil
+}
+
+// DefaultPaymentMethod implements the Store interface.
+func (c *CachingStore) DefaultPaymentMethod(cust *Customer) (*PaymentMethod, bool, error) {
+	v, ok, gen := c.get(c.paymentMethods, cust.ID)
+
+	if ok {
+		return v.(*PaymentMethod).copy(), true, nil
+	}
+
+	pm, ok, err := c.Store.DefaultPaymentMethod(cust)
+
+	if err != nil || !ok {
+		return pm, ok, err
+	}
+
+	c.set(c.paymentMethods, cust.ID, pm.copy(), gen)
+	return pm, true, nil
+}
+
+// Put implements the Store interface. This will invalidate anything cached for
+// the given Resource once it has been put in the underlying Store.
+func (c *CachingStore) Put(r Resource) error {
+	defer c.invalidate(r)
+	return c.Store.Put(r)
+}
+
+// Remove implements the Store interface. This will invalidate anything cached
+// for the given Resource once it has been removed from the underlying Store.
+func (c *CachingStore) Remove(r Resource) error {
+	defer c.invalidate(r)
+	return c.Store.Remove(r)
+}
+
+// LogEventPayload implements the EventStore interface. If the underlying Store
+// is not an EventStore then only the ID of the event is logged.
+func (c *CachingStore) LogEventPayload(id string, payload []byte) error {
+	if es, ok := c.Store.(EventStore); ok {
+		return es.LogEventPayload(id, payload)
+	}
+	return c.Store.LogEvent(id)
+}
+
+// Event implements the EventStore interface. If the underlying Store is not an
+// EventStore then the event will never be found.
+func (c *CachingStore) Event(id string) (stripe.Event, bool, error) {
+	if es, ok := c.Store.(EventStore); ok {
+		return es.Event(id)
+	}
+	return stripe.Event{}, false, nil
+}
@@ -0,0 +1,248 @@
+package stripeutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// lookupStore counts the number of subscription lookups made, and calls the
+// given hook during each lookup.
+type lookupStore struct {
+	*MemoryStore
+
+	lookups int
+	hook    func()
+}
+
+func (s *lookupStore) Subscription(c *Customer) (*Subscription, bool, error) {
+	s.lookups++
+
+	sub, ok, err := s.MemoryStore.Subscription(c)
+
+	if s.hook != nil {
+		s.hook()
+	}
+	return sub, ok, err
+}
+
+func Test_CachingStore(t *testing.T) {
+	underlying := &lookupStore{MemoryStore: NewMemoryStore()}
+
+	store := NewCachingStore(underlying, time.Minute)
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusActive,
+		},
+	}
+
+	if err := store.Put(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := store.Subscription(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if underlying.lookups != 1 {
+		t.Fatalf("expected 1 lookup in underlying store, got %d\n", underlying.lookups)
+	}
+
+	// Putting the subscription should invalidate the cache, so the update is
+	// seen on the next lookup.
+	sub.Status = stripe.SubscriptionStatusPastDue
+
+	if err := store.Put(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, _, err := store.Subscription(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.Status != stripe.SubscriptionStatusPastDue {
+		t.Fatalf("unexpected status, expected=%q, got=%q\n", stripe.SubscriptionStatusPastDue, cached.Status)
+	}
+
+	// Once the ttl has passed the subscription should be looked up again.
+	now = now.Add(time.Minute)
+
+	if _, _, err := store.Subscription(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if underlying.lookups != 3 {
+		t.Fatalf("expected 3 lookups in underlying store, got %d\n", underlying.lookups)
+	}
+}
+
+func Test_CachingStoreStaleRead(t *testing.T) {
+	underlying := &lookupStore{MemoryStore: NewMemoryStore()}
+
+	store := NewCachingStore(underlying, time.Minute)
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusActive,
+		},
+	}
+
+	if err := store.Put(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate the subscription being put whilst a lookup is in flight, the
+	// value from the lookup should not be cached.
+	underlying.hook = func() {
+		underlying.hook = nil
+
+		updated := *sub
+		updated.Subscription = &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusCanceled,
+		}
+
+		if err := store.Put(&updated); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := store.Subscription(c); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, _, err := store.Subscription(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cached.Status != stripe.SubscriptionStatusCanceled {
+		t.Fatalf("unexpected status, expected=%q, got=%q\n", stripe.SubscriptionStatusCanceled, cached.Status)
+	}
+}
+
+// payloadStore is a MemoryStore that also stores the payloads of the events
+// logged to it.
+type payloadStore struct {
+	*MemoryStore
+
+	payloads map[string][]byte
+}
+
+func (s *payloadStore) LogEventPayload(id string, payload []byte) error {
+	if err := s.LogEvent(id); err != nil {
+		return err
+	}
+	s.payloads[id] = payload
+	return nil
+}
+
+func (s *payloadStore) Event(id string) (stripe.Event, bool, error) {
+	var event stripe.Event
+
+	payload, ok := s.payloads[id]
+
+	if !ok {
+		return event, false, nil
+	}
+
+	err := json.Unmarshal(payload, &event)
+	return event, err == nil, err
+}
+
+func Test_CachingStoreEventStore(t *testing.T) {
+	secret := "whsec_123456"
+
+	underlying := &payloadStore{
+		MemoryStore: NewMemoryStore(),
+		payloads:    make(map[string][]byte),
+	}
+
+	store := NewCachingStore(underlying, time.Minute)
+
+	hook := NewHookHandler(secret, store, func(err error) {
+		t.Error(err)
+	})
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	hook.HandlerFunc(httptest.NewRecorder(), newHookRequest(secret, "evt_123456", "invoice.paid"))
+
+	event, ok, err := store.Event("evt_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || event.Type != "invoice.paid" {
+		t.Fatalf("expected event payload to be stored in the underlying store, got=%v\n", event)
+	}
+
+	if _, ok, _ := NewCachingStore(NewMemoryStore(), time.Minute).Event("evt_123456"); ok {
+		t.Fatal("expected event to not be found in a store that is not an event store")
+	}
+}
+
+func Test_CachingStoreCopy(t *testing.T) {
+	store := NewCachingStore(NewMemoryStore(), time.Minute)
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	if err := store.Put(c); err != nil {
+		t.Fatal(err)
+	}
+
+	found, _, err := store.LookupCustomer("me@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found.Email = "changed@example.com"
+
+	cached, ok, err := store.LookupCustomer("me@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || cached.Email != "me@example.com" {
+		t.Fatalf("expected cached customer to be unchanged, got=%v\n", cached)
+	}
+
+	cached.Email = "changed@example.com"
+
+	if cached, _, _ := store.LookupCustomer("me@example.com"); cached.Email != "me@example.com" {
+		t.Fatalf("unexpected cached customer email, expected=%q, got=%q\n", "me@example.com", cached.Email)
+	}
+}
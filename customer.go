@@ -2,6 +2,7 @@ package stripeutil
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 
 	"github.com/stripe/stripe-go/v72"
@@ -13,30 +14,72 @@ type Customer struct {
 	*stripe.Customer
 
 	Jurisdiction string
+
+	raw json.RawMessage
 }
 
 var (
 	_ Resource = (*Customer)(nil)
 
 	customerEndpoint = "/v1/customers"
+
+	// ErrUnknownTaxIDType denotes when a tax ID type is not one that is
+	// accepted by Stripe.
+	ErrUnknownTaxIDType = errors.New("unknown tax id type")
+
+	// ErrCustomerDeleted denotes when a Customer has been deleted in Stripe.
+	// Stripe still returns deleted Customers when they are retrieved, only
+	// with the deleted flag set on them.
+	ErrCustomerDeleted = errors.New("customer deleted")
+
+	// ErrInvalidCreditAmount denotes when the amount of credit given to a
+	// Customer is not positive.
+	ErrInvalidCreditAmount = errors.New("invalid credit amount")
+
+	// ErrEmailTaken denotes when the email of a Customer is being changed to
+	// an email that another Customer in the store already has.
+	ErrEmailTaken = errors.New("email taken")
+
+	taxIDTypes = map[stripe.TaxIDType]struct{}{
+		stripe.TaxIDTypeAETRN:  {},
+		stripe.TaxIDTypeAUABN:  {},
+		stripe.TaxIDTypeBRCNPJ: {},
+		stripe.TaxIDTypeBRCPF:  {},
+		stripe.TaxIDTypeCABN:   {},
+		stripe.TaxIDTypeCAQST:  {},
+		stripe.TaxIDTypeCHVAT:  {},
+		stripe.TaxIDTypeCLTIN:  {},
+		stripe.TaxIDTypeESCIF:  {},
+		stripe.TaxIDTypeEUVAT:  {},
+		stripe.TaxIDTypeHKBR:   {},
+		stripe.TaxIDTypeIDNPWP: {},
+		stripe.TaxIDTypeINGST:  {},
+		stripe.TaxIDTypeJPCN:   {},
+		stripe.TaxIDTypeJPRN:   {},
+		stripe.TaxIDTypeKRBRN:  {},
+		stripe.TaxIDTypeLIUID:  {},
+		stripe.TaxIDTypeMXRFC:  {},
+		stripe.TaxIDTypeMYITN:  {},
+		stripe.TaxIDTypeMYFRP:  {},
+		stripe.TaxIDTypeMYSST:  {},
+		stripe.TaxIDTypeNOVAT:  {},
+		stripe.TaxIDTypeNZGST:  {},
+		stripe.TaxIDTypeRUINN:  {},
+		stripe.TaxIDTypeRUKPP:  {},
+		stripe.TaxIDTypeSAVAT:  {},
+		stripe.TaxIDTypeSGUEN:  {},
+		stripe.TaxIDTypeSGGST:  {},
+		stripe.TaxIDTypeTHVAT:  {},
+		stripe.TaxIDTypeTWVAT:  {},
+		stripe.TaxIDTypeUSEIN:  {},
+		stripe.TaxIDTypeZAVAT:  {},
+	}
 )
 
 func postCustomer(s *Stripe, uri string, params Params) (*Customer, error) {
 	c := &Customer{}
 
-	resp, err := s.Post(uri, params)
-
-	if err != nil {
-		return c, err
-	}
-
-	defer resp.Body.Close()
-
-	if !respCode2xx(resp.StatusCode) {
-		return c, s.Error(resp)
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&c.Customer)
+	err := s.post(uri, params, c)
 	return c, err
 }
 
@@ -46,6 +89,30 @@ func CreateCustomer(s *Stripe, params Params) (*Customer, error) {
 	return postCustomer(s, customerEndpoint, params)
 }
 
+// MarshalJSON encodes the Customer to JSON. The Jurisdiction is not part of a
+// Customer in Stripe, so it is added to the encoded stripe.Customer under the
+// "jurisdiction" key.
+func (c *Customer) MarshalJSON() ([]byte, error) {
+	return marshalFields(c.Customer, map[string]interface{}{
+		"jurisdiction": c.Jurisdiction,
+	})
+}
+
+// UnmarshalJSON decodes the given JSON into the Customer, setting the
+// Jurisdiction from the "jurisdiction" key if present. Otherwise the embedded
+// stripe.Customer would decode the JSON, and the Jurisdiction would be lost.
+func (c *Customer) UnmarshalJSON(b []byte) error {
+	if c.Customer == nil {
+		c.Customer = &stripe.Customer{}
+	}
+	return unmarshalFields(b, c.Customer, map[string]interface{}{
+		"jurisdiction": &c.Jurisdiction,
+	})
+}
+
+// Kind implements the Resource interface.
+func (c *Customer) Kind() string { return "customer" }
+
 // Endpoint implements the Resource interface.
 func (c *Customer) Endpoint(uris ...string) string {
 	endpoint := customerEndpoint
@@ -61,28 +128,114 @@ func (c *Customer) Endpoint(uris ...string) string {
 }
 
 // Load implements the Resource interface.
-func (c *Customer) Load(s *Stripe) error {
-	resp, err := s.Client.Get(c.Endpoint())
+func (c *Customer) Load(s *Stripe) error { return c.LoadExpanded(s) }
 
-	if err != nil {
+// LoadExpanded will load in the Customer from the Stripe API, expanding the
+// objects at the given paths in the response. If the Customer has been deleted
+// in Stripe then ErrCustomerDeleted is returned.
+func (c *Customer) LoadExpanded(s *Stripe, expand ...string) error {
+	if err := s.get(c.Endpoint(), c, expand...); err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
+	if c.Deleted {
+		return ErrCustomerDeleted
+	}
+	return nil
+}
+
+// Raw returns the raw JSON of the Customer. This is the JSON of the response
+// the Customer was last decoded from, or the JSON kept by the store the
+// Customer was retrieved from.
+func (c *Customer) Raw() (json.RawMessage, error) { return rawJSON(c.raw, c.Customer) }
+
+func (c *Customer) setRaw(b json.RawMessage) { c.raw = b }
+
+// AddTaxID will add a tax ID of the given type and value to the current
+// Customer, such as a VAT number. If the given type is not one accepted by
+// Stripe then ErrUnknownTaxIDType is returned.
+func (c *Customer) AddTaxID(s *Stripe, typ, value string) (*stripe.TaxID, error) {
+	if _, ok := taxIDTypes[stripe.TaxIDType(typ)]; !ok {
+		return nil, ErrUnknownTaxIDType
+	}
+
+	id := &stripe.TaxID{}
 
-	if !respCode2xx(resp.StatusCode) {
-		return s.Error(resp)
+	if err := s.post(c.Endpoint("tax_ids"), Params{"type": typ, "value": value}, id); err != nil {
+		return nil, err
 	}
-	return json.NewDecoder(resp.Body).Decode(&c.Customer)
+	return id, nil
 }
 
-// Update will update the current Customer in Stripe with the given Params.
-func (c *Customer) Update(s *Stripe, params Params) error {
-	c1, err := postCustomer(s, c.Endpoint(), params)
+// TaxIDs returns all of the tax IDs for the current Customer.
+func (c *Customer) TaxIDs(s *Stripe) ([]*stripe.TaxID, error) {
+	ids := make([]*stripe.TaxID, 0)
+
+	err := s.list(c.Endpoint("tax_ids"), func(raw json.RawMessage) error {
+		id := &stripe.TaxID{}
+
+		if err := s.unmarshal(raw, id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
 
 	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Credit will add the given amount of credit to the balance of the current
+// Customer, in the given currency. The amount should be in the smallest unit
+// of the currency, and must be positive, otherwise ErrInvalidCreditAmount is
+// returned. Stripe will automatically apply the credit to the next Invoices
+// that are finalized for the Customer. The currency must match the currency of
+// the Customer's existing balance, if any.
+func (c *Customer) Credit(s *Stripe, amount int64, currency string) error {
+	if amount <= 0 {
+		return ErrInvalidCreditAmount
+	}
+
+	params := Params{
+		// A negative amount is a credit to the Customer.
+		"amount":   -amount,
+		"currency": currency,
+	}
+
+	if err := s.post(c.Endpoint("balance_transactions"), params, &stripe.CustomerBalanceTransaction{}); err != nil {
 		return err
 	}
-	(*c) = (*c1)
-	return nil
+	return c.Load(s)
+}
+
+// Balance will return the current balance of the Customer from Stripe. A
+// negative balance is credit that will be applied to the next Invoices of the
+// Customer, and a positive balance is an amount that will be added to the next
+// Invoices.
+func (c *Customer) Balance(s *Stripe) (int64, error) {
+	if err := c.Load(s); err != nil {
+		return 0, err
+	}
+	return c.Customer.Balance, nil
+}
+
+// copy returns a copy of the current Customer, along with a copy of the
+// embedded stripe.Customer.
+func (c *Customer) copy() *Customer {
+	cp := *c
+
+	if c.Customer != nil {
+		cust := *c.Customer
+		cp.Customer = &cust
+	}
+	return &cp
+}
+
+// Update will update the current Customer in Stripe with the given Params. The
+// updated Customer returned from Stripe is decoded into the current Customer,
+// so the Jurisdiction of the Customer is kept.
+func (c *Customer) Update(s *Stripe, params Params) error {
+	return s.post(c.Endpoint(), params, c)
 }
@@ -0,0 +1,335 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_CustomerDeleted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456", "deleted": true}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_654321"):
+			w.Write([]byte(`{"id": "cus_654321", "email": "me@example.com"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such customer"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	tests := []struct {
+		id  string
+		err error
+	}{
+		{"cus_123456", ErrCustomerDeleted},
+		{"cus_654321", nil},
+	}
+
+	for i, test := range tests {
+		c := &Customer{
+			Customer: &stripe.Customer{ID: test.id},
+		}
+
+		if err := c.Load(s); err != test.err {
+			t.Errorf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.err, err)
+		}
+	}
+}
+
+func Test_CustomerCredit(t *testing.T) {
+	balance := int64(0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456/balance_transactions"):
+			r.ParseForm()
+
+			if r.PostForm.Get("amount") != "-500" || r.PostForm.Get("currency") != "gbp" {
+				t.Errorf("unexpected balance transaction, got=%v\n", r.PostForm)
+			}
+
+			balance -= 500
+			w.Write([]byte(`{"id": "cbtxn_123456", "amount": -500, "currency": "gbp"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456", "balance": ` + strconv.FormatInt(balance, 10) + `}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	if err := c.Credit(s, 0, "gbp"); err != ErrInvalidCreditAmount {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrInvalidCreditAmount, err)
+	}
+
+	if err := c.Credit(s, 500, "gbp"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.Balance(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b != -500 {
+		t.Fatalf("unexpected balance, expected=%d, got=%d\n", -500, b)
+	}
+}
+
+func Test_ChangeEmail(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+		w.Write([]byte(`{"id": "cus_123456", "email": "` + r.PostForm.Get("email") + `"}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer:     &stripe.Customer{ID: "cus_123456", Email: "old@example.com"},
+		Jurisdiction: "GB",
+	}
+
+	store.Put(c)
+	store.Put(&Customer{
+		Customer: &stripe.Customer{ID: "cus_654321", Email: "taken@example.com"},
+	})
+
+	if err := s.ChangeEmail(c, "taken@example.com"); err != ErrEmailTaken {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrEmailTaken, err)
+	}
+
+	if err := s.ChangeEmail(c, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Email != "new@example.com" {
+		t.Fatalf("unexpected email, expected=%q, got=%q\n", "new@example.com", c.Email)
+	}
+
+	if _, ok, _ := store.LookupCustomer("old@example.com"); ok {
+		t.Fatal("expected customer to not be found by old email")
+	}
+
+	found, ok, err := store.LookupCustomer("new@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || found.ID != c.ID {
+		t.Fatalf("expected customer %q to be found by new email\n", c.ID)
+	}
+
+	if found.Jurisdiction != "GB" {
+		t.Fatalf("unexpected jurisdiction, expected=%q, got=%q\n", "GB", found.Jurisdiction)
+	}
+}
+
+func Test_CustomerExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"message": "Not found"}}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	store.Put(&Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	})
+
+	deleted := &Customer{
+		Customer: &stripe.Customer{ID: "cus_654321", Email: "deleted@example.com"},
+	}
+
+	store.Put(deleted)
+	store.Remove(deleted)
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	tests := []struct {
+		email    string
+		expected bool
+	}{
+		{"me@example.com", true},
+		{"deleted@example.com", false},
+		{"you@example.com", false},
+	}
+
+	for i, test := range tests {
+		ok, err := s.CustomerExists(test.email)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if ok != test.expected {
+			t.Errorf("tests[%d] - unexpected exists, expected=%v, got=%v\n", i, test.expected, ok)
+		}
+	}
+
+	if _, ok, _ := store.LookupCustomer("you@example.com"); ok {
+		t.Fatal("expected customer to not be created")
+	}
+}
+
+func Test_CustomerWithParams(t *testing.T) {
+	var form url.Values
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		w.Write([]byte(`{"id": "cus_123456", "email": "` + r.PostForm.Get("email") + `", "name": "` + r.PostForm.Get("name") + `"}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	params := Params{"name": "Jane Doe"}
+
+	c, err := s.CustomerWithParams("me@example.com", params)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if form.Get("email") != "me@example.com" || form.Get("name") != "Jane Doe" {
+		t.Fatalf("unexpected params, got=%v\n", form)
+	}
+
+	if c.Email != "me@example.com" || c.Name != "Jane Doe" {
+		t.Fatalf("unexpected customer, expected=%q, got=%q\n", "me@example.com", c.Email)
+	}
+
+	if _, ok := params["email"]; ok {
+		t.Fatal("expected given params to not be modified")
+	}
+
+	if _, ok, _ := store.LookupCustomer("me@example.com"); !ok {
+		t.Fatal("expected customer to be stored")
+	}
+
+	form = nil
+
+	// The stored Customer is returned without a request to Stripe.
+	if _, err := s.CustomerWithParams("me@example.com", params); err != nil {
+		t.Fatal(err)
+	}
+
+	if form != nil {
+		t.Fatal("expected no request to be made for a stored customer")
+	}
+}
+
+func Test_CustomerTaxIDs(t *testing.T) {
+	ids := make([]string, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456/tax_ids") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		if r.Method == "POST" {
+			r.ParseForm()
+
+			value := r.PostForm.Get("value")
+			ids = append(ids, value)
+
+			w.Write([]byte(`{"id": "txi_` + strconv.Itoa(len(ids)) + `", "type": "` + r.PostForm.Get("type") + `", "value": "` + value + `"}`))
+			return
+		}
+
+		data := make([]string, 0, len(ids))
+
+		for i, value := range ids {
+			data = append(data, `{"id": "txi_`+strconv.Itoa(i+1)+`", "type": "eu_vat", "value": "`+value+`"}`)
+		}
+		w.Write([]byte(`{"has_more": false, "data": [` + strings.Join(data, ",") + `]}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	if _, err := c.AddTaxID(s, "xx_vat", "XX123456"); err != ErrUnknownTaxIDType {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrUnknownTaxIDType, err)
+	}
+
+	if len(ids) != 0 {
+		t.Fatalf("expected no request to be made for an unknown tax id type, got=%d\n", len(ids))
+	}
+
+	expected := []string{"DE123456789", "FR12345678901"}
+
+	for _, value := range expected {
+		id, err := c.AddTaxID(s, "eu_vat", value)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id.Type != stripe.TaxIDTypeEUVAT || id.Value != value {
+			t.Fatalf("unexpected tax id, expected=%q, got=%q\n", value, id.Value)
+		}
+	}
+
+	taxIDs, err := c.TaxIDs(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(taxIDs) != len(expected) {
+		t.Fatalf("unexpected tax ids, expected=%d, got=%d\n", len(expected), len(taxIDs))
+	}
+
+	for i, id := range taxIDs {
+		if id.Value != expected[i] {
+			t.Errorf("taxIDs[%d] - unexpected value, expected=%q, got=%q\n", i, expected[i], id.Value)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package stripeutil
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// eventWindow is an in-memory set of the event IDs that have been seen within
+// a window of time. This holds at most size IDs, once full the IDs that were
+// seen first are evicted.
+type eventWindow struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	now   func() time.Time
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+type seenEvent struct {
+	id   string
+	seen time.Time
+}
+
+func newEventWindow(size int, ttl time.Duration) *eventWindow {
+	return &eventWindow{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		order: list.New(),
+		ids:   make(map[string]*list.Element),
+	}
+}
+
+// evict removes the IDs that have expired, and the IDs that were seen first if
+// the window is over capacity. This expects the lock to be held.
+func (w *eventWindow) evict(now time.Time) {
+	for e := w.order.Front(); e != nil; e = w.order.Front() {
+		ev := e.Value.(seenEvent)
+
+		if w.order.Len() <= w.size && now.Sub(ev.seen) < w.ttl {
+			break
+		}
+
+		w.order.Remove(e)
+		delete(w.ids, ev.id)
+	}
+}
+
+// seen returns whether or not the given ID has already been seen within the
+// window. If it has not, then it is added to the window.
+func (w *eventWindow) seen(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+
+	w.evict(now)
+
+	if _, ok := w.ids[id]; ok {
+		return true
+	}
+
+	w.ids[id] = w.order.PushBack(seenEvent{id: id, seen: now})
+	w.evict(now)
+	return false
+}
+
+// forget removes the given ID from the window, so it will no longer be seen.
+func (w *eventWindow) forget(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if e, ok := w.ids[id]; ok {
+		w.order.Remove(e)
+		delete(w.ids, id)
+	}
+}
@@ -85,6 +85,15 @@
 // interface for storing the customer, invoice, and subscription invoices during
 // the Subscribe flow.
 //
+// If you don't want to persist any of the resources, and would rather treat
+// Stripe as the source of truth, then stripeutil.NopStore can be used,
+//
+//     stripe := stripeutil.New(os.Getenv("STRIPE_SECRET"), stripeutil.NopStore{})
+//
+// with this, lookups in the store will never find anything, so a Customer will
+// be created in Stripe each time stripe.Customer is called. Passing a nil
+// Store to New will also use stripeutil.NopStore.
+//
 // stripeutil.Stripe is what is primarily used for interfacing with the Stripe
 // API. This depends on the stripeutil.Store interface, as previously mentioned,
 // for storing the resources retrieved from Stripe.
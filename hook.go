@@ -1,14 +1,27 @@
 package stripeutil
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/webhook"
 )
 
+// DefaultMaxBodySize is the default maximum size in bytes of the body of a
+// request that a HookHandler will read. This is well above the size of the
+// events sent by Stripe.
+const DefaultMaxBodySize = 4 << 20
+
+// ErrLivemodeMismatch is passed to the errh callback of a HookHandler when an
+// event is received that does not match the livemode that is expected, for
+// example a test mode event being sent to the live webhook endpoint.
+var ErrLivemodeMismatch = errors.New("event livemode mismatch")
+
 // HookHandlerFunc is the handler function that is registered agains an event.
 // This is like an http.HandlerFunc, only the first argument it is passed is
 // the decoded event sent from stripe.
@@ -17,26 +30,145 @@ type HookHandlerFunc func(stripe.Event, http.ResponseWriter, *http.Request)
 // HookHandler provides a way of registering handlers against the different
 // events emitted by Stripe.
 type HookHandler struct {
-	mu     sync.RWMutex
-	errh   func(error)
-	secret string
-	store  Store
-	events map[string]HookHandlerFunc
+	mu      sync.RWMutex
+	errh    func(error)
+	secret  string
+	store   Store
+	log     Logger
+	metrics Metrics
+	events  map[string]HookHandlerFunc
+	maxBody int64
+	window  *eventWindow
+
+	// livemode is the livemode events are expected to have, this is nil if
+	// the livemode of events is not checked.
+	livemode *bool
+
+	// queue is the queue of events to handle asynchronously, this is nil if
+	// the HookHandler is synchronous.
+	queue    chan hookJob
+	closed   bool
+	inflight sync.WaitGroup
+	workers  sync.WaitGroup
+}
+
+type hookJob struct {
+	fn    HookHandlerFunc
+	event stripe.Event
+	req   *http.Request
+}
+
+// discardResponseWriter is the http.ResponseWriter passed to handlers that
+// are invoked asynchronously, since the response has already been sent.
+type discardResponseWriter struct {
+	header http.Header
 }
 
 // NewHookHandler returns a HookHandler using the given secret for request
 // verification, and the given callback for handling any errors that occur
 // during request verification.
 func NewHookHandler(secret string, s Store, errh func(error)) *HookHandler {
+	if s == nil {
+		s = NopStore{}
+	}
+
 	return &HookHandler{
-		mu:     sync.RWMutex{},
-		errh:   errh,
-		secret: secret,
-		store:  s,
-		events: make(map[string]HookHandlerFunc),
+		mu:      sync.RWMutex{},
+		errh:    errh,
+		secret:  secret,
+		store:   s,
+		log:     nopLogger{},
+		metrics: nopMetrics{},
+		events:  make(map[string]HookHandlerFunc),
+		maxBody: DefaultMaxBodySize,
 	}
 }
 
+// NewAsyncHookHandler returns a HookHandler that handles events
+// asynchronously with the given number of workers. Each event is verified,
+// and logged in the given Store before being queued, and a 200 response is
+// sent immediately. Since the response will have already been sent, the
+// http.ResponseWriter passed to each HookHandlerFunc will discard anything
+// written to it. Shutdown should be called to wait for the queued events to be
+// handled.
+func NewAsyncHookHandler(secret string, s Store, errh func(error), workers int) *HookHandler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	h := NewHookHandler(secret, s, errh)
+	h.queue = make(chan hookJob, workers)
+	h.workers.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer h.workers.Done()
+
+			for job := range h.queue {
+				job.fn(job.event, &discardResponseWriter{header: make(http.Header)}, job.req)
+			}
+		}()
+	}
+	return h
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(_ int)           {}
+
+// Shutdown stops the HookHandler from accepting any more events, and waits for
+// all of the events that are in-flight, or queued, to be handled. If the given
+// context is done before this happens, then the context's error is returned.
+// Once shutdown, the HookHandler will respond to all requests with a 503.
+func (h *HookHandler) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+
+	h.closed = true
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		h.inflight.Wait()
+
+		if h.queue != nil {
+			close(h.queue)
+			h.workers.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the HookHandler from accepting any more events, and waits for
+// all of the in-flight, and queued events to be handled.
+func (h *HookHandler) Close() { h.Shutdown(context.Background()) }
+
+// begin marks the start of handling a request, this returns false if the
+// HookHandler has been shutdown.
+func (h *HookHandler) begin() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return false
+	}
+
+	h.inflight.Add(1)
+	return true
+}
+
 // Handler registers a new handler for the given event. If a handler was
 // already registered against the given event, then that handler will be
 // overwritten with the new handler.
@@ -46,6 +178,158 @@ func (h *HookHandler) Handle(event string, fn HookHandlerFunc) {
 	h.events[event] = fn
 }
 
+// SetLogger sets the Logger to use for logging the events that are dispatched
+// by the HookHandler.
+func (h *HookHandler) SetLogger(l Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.log = l
+}
+
+// SetMetrics sets the Metrics to use for instrumenting the events that are
+// received by the HookHandler.
+func (h *HookHandler) SetMetrics(m Metrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.metrics = m
+}
+
+// SetMaxBodySize sets the maximum size in bytes of the body of a request that
+// will be read. Requests with a body larger than this will be responded to
+// with a 413, and the error passed to the errh callback. This is set to
+// DefaultMaxBodySize by default.
+func (h *HookHandler) SetMaxBodySize(n int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxBody = n
+}
+
+// SetLivemode sets the livemode that the events received by the HookHandler
+// are expected to have. Events that do not match will be responded to with a
+// 400, and ErrLivemodeMismatch will be passed to the errh callback. This would
+// be set to true for the webhook endpoint of a production environment. By
+// default the livemode of events is not checked.
+func (h *HookHandler) SetLivemode(livemode bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.livemode = &livemode
+}
+
+// SetDedupWindow sets the HookHandler to keep the IDs of the events it has
+// received in memory for the given duration, holding at most the given number
+// of IDs. Events that have already been received within this window will be
+// dropped, and responded to with a 202. This provides deduplication of the
+// events sent by Stripe without relying on the Store, for example when a
+// NopStore is being used. This is used alongside the Store, so an event is
+// only handled if it has not been seen in either. If the given size, or
+// duration is 0 then events are not deduplicated in memory.
+func (h *HookHandler) SetDedupWindow(size int, ttl time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if size <= 0 || ttl <= 0 {
+		h.window = nil
+		return
+	}
+	h.window = newEventWindow(size, ttl)
+}
+
+// Verify will check that the given payload and Stripe-Signature header were
+// signed with the secret of the HookHandler, and that the event matches the
+// livemode set via SetLivemode, if any. This does not handle the event, nor
+// log it in the store. The timestamp of the signature is not checked, so this
+// can be used at startup with a previously captured event, such as one sent
+// via the Stripe CLI, to make sure the secret is correct before any events
+// are received, for example,
+//
+//     if err := hook.Verify(payload, signature); err != nil {
+//         if err == webhook.ErrNoValidSignature {
+//             // The webhook secret is wrong.
+//         }
+//         // Handle error.
+//     }
+//
+// If the signature does not match the secret then webhook.ErrNoValidSignature
+// is returned.
+func (h *HookHandler) Verify(payload []byte, signature string) error {
+	h.mu.RLock()
+	livemode := h.livemode
+	h.mu.RUnlock()
+
+	event, err := webhook.ConstructEventIgnoringTolerance(payload, signature, h.secret)
+
+	if err != nil {
+		return err
+	}
+
+	if livemode != nil && event.Livemode != *livemode {
+		return ErrLivemodeMismatch
+	}
+	return nil
+}
+
+// enqueue queues the given event to be handled asynchronously. This returns
+// false if the HookHandler is synchronous.
+func (h *HookHandler) enqueue(fn HookHandlerFunc, event stripe.Event, r *http.Request) bool {
+	if h.queue == nil {
+		return false
+	}
+
+	h.queue <- hookJob{
+		fn:    fn,
+		event: event,
+		req:   r.Clone(context.Background()),
+	}
+	return true
+}
+
+// logEvent logs the given event in the Store. If the Store is an EventStore
+// then the payload of the event is logged too.
+func (h *HookHandler) logEvent(id string, payload []byte) error {
+	if es, ok := h.store.(EventStore); ok {
+		return es.LogEventPayload(id, payload)
+	}
+	return h.store.LogEvent(id)
+}
+
+// Replay will dispatch the given event to the handler registered against it,
+// bypassing the deduplication of events, and the verification of requests.
+// This would be used for reprocessing past events, for example when a new
+// handler has been registered. Past events can be retrieved from a Store that
+// implements EventStore, for example,
+//
+//     event, ok, err := store.Event("evt_123456")
+//
+//     if err != nil {
+//         // Handle error.
+//     }
+//
+//     if ok {
+//         hook.Replay(event)
+//     }
+//
+// The handler is passed an http.ResponseWriter that discards anything written
+// to it, and a request with an empty body. Replay returns whether or not a
+// handler was registered for the event.
+func (h *HookHandler) Replay(event stripe.Event) bool {
+	h.mu.RLock()
+	fn, ok := h.events[event.Type]
+	log := h.log
+	h.mu.RUnlock()
+
+	if !ok {
+		log.Debugf("no handler for replayed event %s %s", event.ID, event.Type)
+		return false
+	}
+
+	log.Debugf("replaying event %s %s", event.ID, event.Type)
+
+	r, _ := http.NewRequest("POST", "/", http.NoBody)
+
+	fn(event, &discardResponseWriter{header: make(http.Header)}, r)
+	return true
+}
+
 // HandlerFunc should be registered in the route multiplexer being used to
 // register routes in the web server. For example,
 //
@@ -55,10 +339,32 @@ func (h *HookHandler) Handle(event string, fn HookHandlerFunc) {
 // this would cause the HookHandler to handle all of the requests sent to the
 // "/stripe-hook" endpoint.
 func (h *HookHandler) HandlerFunc(w http.ResponseWriter, r *http.Request) {
+	if !h.begin() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	defer h.inflight.Done()
+
+	h.mu.RLock()
+	maxBody := h.maxBody
+	window := h.window
+	livemode := h.livemode
+	h.mu.RUnlock()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+
 	payload, err := ioutil.ReadAll(r.Body)
 
 	if err != nil {
 		h.errh(err)
+
+		// The body is only read up to the maximum size, so if that much was
+		// read then the error is because the body was too large.
+		if int64(len(payload)) >= maxBody {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
@@ -71,9 +377,26 @@ func (h *HookHandler) HandlerFunc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if livemode != nil && event.Livemode != *livemode {
+		h.errh(ErrLivemodeMismatch)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if window != nil && window.seen(event.ID) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	if h.store != nil {
-		if err := h.store.LogEvent(event.ID); err != nil {
+		if err := h.logEvent(event.ID, payload); err != nil {
 			if err != ErrEventExists {
+				// Forget the event so it is not dropped when Stripe retries
+				// it.
+				if window != nil {
+					window.forget(event.ID)
+				}
+
 				h.errh(err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
@@ -83,12 +406,25 @@ func (h *HookHandler) HandlerFunc(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	h.mu.RLock()
+	fn, ok := h.events[event.Type]
+	log := h.log
+	metrics := h.metrics
+	h.mu.RUnlock()
+
+	metrics.ObserveEvent(event.Type)
+
+	if ok {
+		log.Debugf("dispatching event %s %s", event.ID, event.Type)
 
-	if fn, ok := h.events[event.Type]; ok {
+		if h.enqueue(fn, event, r) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		fn(event, w, r)
 		return
 	}
+
+	log.Debugf("no handler for event %s %s", event.ID, event.Type)
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,290 @@
+package stripeutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+func newHookRequest(secret, id, typ string) *http.Request {
+	payload := []byte(`{"id": "` + id + `", "type": "` + typ + `", "object": "event"}`)
+
+	now := time.Now()
+	sig := webhook.ComputeSignature(now, payload, secret)
+
+	r := httptest.NewRequest("POST", "/stripe-hook", bytes.NewReader(payload))
+	r.Header.Set("Stripe-Signature", "t="+strconv.FormatInt(now.Unix(), 10)+",v1="+hex.EncodeToString(sig))
+	return r
+}
+
+func Test_AsyncHookHandler(t *testing.T) {
+	secret := "whsec_123456"
+
+	var handled int32
+
+	hook := NewAsyncHookHandler(secret, nil, func(err error) {
+		t.Error(err)
+	}, 2)
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(time.Millisecond * 10)
+		atomic.AddInt32(&handled, 1)
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+
+		hook.HandlerFunc(w, newHookRequest(secret, "evt_"+strconv.Itoa(i), "invoice.paid"))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status, expected=%d, got=%d\n", http.StatusOK, w.Code)
+		}
+	}
+
+	hook.Close()
+
+	if handled != 5 {
+		t.Errorf("unexpected number of handled events, expected=%d, got=%d\n", 5, handled)
+	}
+
+	w := httptest.NewRecorder()
+
+	hook.HandlerFunc(w, newHookRequest(secret, "evt_closed", "invoice.paid"))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status, expected=%d, got=%d\n", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func Test_HookHandlerShutdown(t *testing.T) {
+	secret := "whsec_123456"
+
+	hook := NewAsyncHookHandler(secret, nil, func(err error) {
+		t.Error(err)
+	}, 1)
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, _ http.ResponseWriter, _ *http.Request) {
+		time.Sleep(time.Millisecond * 100)
+	})
+
+	hook.HandlerFunc(httptest.NewRecorder(), newHookRequest(secret, "evt_123456", "invoice.paid"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if err := hook.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func Test_HookHandlerMaxBodySize(t *testing.T) {
+	secret := "whsec_123456"
+
+	errs := 0
+
+	hook := NewHookHandler(secret, nil, func(err error) {
+		errs++
+	})
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+
+	hook.HandlerFunc(w, newHookRequest(secret, "evt_123456", "invoice.paid"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status, expected=%d, got=%d\n", http.StatusOK, w.Code)
+	}
+
+	hook.SetMaxBodySize(16)
+
+	w = httptest.NewRecorder()
+
+	hook.HandlerFunc(w, newHookRequest(secret, "evt_654321", "invoice.paid"))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status, expected=%d, got=%d\n", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	if errs != 1 {
+		t.Fatalf("unexpected errors, expected=%d, got=%d\n", 1, errs)
+	}
+}
+
+func Test_HookHandlerDedupWindow(t *testing.T) {
+	secret := "whsec_123456"
+
+	var handled int32
+
+	hook := NewHookHandler(secret, nil, func(err error) {
+		t.Error(err)
+	})
+
+	hook.SetDedupWindow(2, time.Minute)
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&handled, 1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		id       string
+		expected int
+	}{
+		{"evt_1", http.StatusOK},
+		{"evt_1", http.StatusAccepted},
+		{"evt_2", http.StatusOK},
+		{"evt_3", http.StatusOK},
+		{"evt_3", http.StatusAccepted},
+		{"evt_1", http.StatusOK}, // evicted once the window was full
+	}
+
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+
+		hook.HandlerFunc(w, newHookRequest(secret, test.id, "invoice.paid"))
+
+		if w.Code != test.expected {
+			t.Errorf("tests[%d] - unexpected status, expected=%d, got=%d\n", i, test.expected, w.Code)
+		}
+	}
+
+	if handled != 4 {
+		t.Errorf("unexpected number of handled events, expected=%d, got=%d\n", 4, handled)
+	}
+}
+
+func Test_EventWindowTTL(t *testing.T) {
+	now := time.Now()
+
+	w := newEventWindow(10, time.Minute)
+	w.now = func() time.Time { return now }
+
+	if w.seen("evt_1") {
+		t.Fatal("expected evt_1 to not have been seen")
+	}
+
+	if !w.seen("evt_1") {
+		t.Fatal("expected evt_1 to have been seen")
+	}
+
+	now = now.Add(time.Minute)
+
+	if w.seen("evt_1") {
+		t.Fatal("expected evt_1 to have expired")
+	}
+}
+
+func Test_HookHandlerLivemode(t *testing.T) {
+	secret := "whsec_123456"
+
+	var errs []error
+
+	hook := NewHookHandler(secret, nil, func(err error) {
+		errs = append(errs, err)
+	})
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		livemode bool
+		expected int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusBadRequest},
+	}
+
+	for i, test := range tests {
+		hook.SetLivemode(test.livemode)
+
+		w := httptest.NewRecorder()
+
+		hook.HandlerFunc(w, newHookRequest(secret, "evt_"+strconv.Itoa(i), "invoice.paid"))
+
+		if w.Code != test.expected {
+			t.Errorf("tests[%d] - unexpected status, expected=%d, got=%d\n", i, test.expected, w.Code)
+		}
+	}
+
+	if len(errs) != 1 || errs[0] != ErrLivemodeMismatch {
+		t.Fatalf("unexpected errors, expected=%v, got=%v\n", []error{ErrLivemodeMismatch}, errs)
+	}
+}
+
+func Test_HookHandlerReplay(t *testing.T) {
+	secret := "whsec_123456"
+
+	var handled int32
+
+	store := NewMemoryStore()
+
+	hook := NewHookHandler(secret, store, func(err error) {
+		t.Error(err)
+	})
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&handled, 1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	hook.HandlerFunc(httptest.NewRecorder(), newHookRequest(secret, "evt_123456", "invoice.paid"))
+
+	event := stripe.Event{ID: "evt_123456", Type: "invoice.paid"}
+
+	if !hook.Replay(event) {
+		t.Fatal("expected event to be replayed")
+	}
+
+	if hook.Replay(stripe.Event{ID: "evt_654321", Type: "customer.created"}) {
+		t.Fatal("expected event without handler to not be replayed")
+	}
+
+	if handled != 2 {
+		t.Fatalf("unexpected number of handled events, expected=%d, got=%d\n", 2, handled)
+	}
+}
+
+func Test_HookHandlerVerify(t *testing.T) {
+	payload := []byte(`{"id": "evt_123456", "type": "invoice.paid", "object": "event"}`)
+
+	now := time.Now().Add(-time.Hour)
+	sig := "t=" + strconv.FormatInt(now.Unix(), 10) + ",v1=" + hex.EncodeToString(webhook.ComputeSignature(now, payload, "whsec_123456"))
+
+	tests := []struct {
+		secret   string
+		livemode bool
+		expected error
+	}{
+		{"whsec_123456", false, nil},
+		{"whsec_654321", false, webhook.ErrNoValidSignature},
+		{"whsec_123456", true, ErrLivemodeMismatch},
+	}
+
+	for i, test := range tests {
+		hook := NewHookHandler(test.secret, nil, func(err error) {
+			t.Error(err)
+		})
+
+		if test.livemode {
+			hook.SetLivemode(true)
+		}
+
+		if err := hook.Verify(payload, sig); err != test.expected {
+			t.Errorf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.expected, err)
+		}
+	}
+}
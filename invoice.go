@@ -2,6 +2,7 @@ package stripeutil
 
 import (
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
@@ -14,14 +15,35 @@ type Invoice struct {
 	*stripe.Invoice
 
 	Updated time.Time // Updated is when the Invoice was last updated.
+
+	raw json.RawMessage
 }
 
 var (
 	_ Resource = (*Invoice)(nil)
 
 	invoiceEndpoint = "/v1/invoices"
+
+	// ErrNoReceipt is returned from Invoice.Receipt when the Invoice does not
+	// have a Charge with a receipt, for example if the Invoice is not paid.
+	ErrNoReceipt = errors.New("invoice has no receipt")
 )
 
+// RetrieveInvoice will get the Invoice of the given ID from Stripe and return
+// it.
+func RetrieveInvoice(s *Stripe, id string) (*Invoice, error) {
+	inv := &Invoice{
+		Invoice: &stripe.Invoice{
+			ID: id,
+		},
+	}
+
+	if err := inv.Load(s); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
 // RetrieveUpcomingInvoice will retrieve the upcoming Invoice for the given
 // Customer.
 func RetrieveUpcomingInvoice(s *Stripe, c *Customer) (*Invoice, error) {
@@ -39,12 +61,100 @@ func RetrieveUpcomingInvoice(s *Stripe, c *Customer) (*Invoice, error) {
 
 	var inv Invoice
 
-	if err := json.NewDecoder(resp.Body).Decode(&inv.Invoice); err != nil {
+	if err := s.decode(resp.Body, &inv); err != nil {
 		return nil, err
 	}
 	return &inv, nil
 }
 
+// Pay will attempt to pay the Invoice using the given PaymentMethod. This would
+// typically be used for retrying the payment of an Invoice that previously
+// failed.
+func (i *Invoice) Pay(s *Stripe, pm *PaymentMethod) error {
+	params := Params{
+		"payment_method": pm.ID,
+	}
+	return s.post(i.Endpoint("pay"), params, i)
+}
+
+// Receipt returns the URL of the hosted receipt for the Invoice. This is the
+// receipt of the Charge that paid for the Invoice, so the Invoice is retrieved
+// from Stripe with its Charge expanded. If the Invoice does not have a Charge
+// with a receipt then ErrNoReceipt is returned.
+func (i *Invoice) Receipt(s *Stripe) (string, error) {
+	inv := &Invoice{
+		Invoice: &stripe.Invoice{
+			ID: i.ID,
+		},
+	}
+
+	if err := inv.LoadExpanded(s, "charge"); err != nil {
+		return "", err
+	}
+
+	if inv.Charge == nil || inv.Charge.ReceiptURL == "" {
+		return "", ErrNoReceipt
+	}
+	return inv.Charge.ReceiptURL, nil
+}
+
+// AllLineItems will return all of the line items for the Invoice. The Lines of
+// the underlying stripe.Invoice only contain the first page of line items, so
+// this will page through all of the line items from the Stripe API.
+func (i *Invoice) AllLineItems(s *Stripe) ([]*stripe.InvoiceLine, error) {
+	items := make([]*stripe.InvoiceLine, 0)
+
+	err := s.list(i.Endpoint("lines"), func(raw json.RawMessage) error {
+		item := &stripe.InvoiceLine{}
+
+		if err := s.unmarshal(raw, item); err != nil {
+			return err
+		}
+
+		items = append(items, item)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// copy returns a copy of the current Invoice, along with a copy of the
+// embedded stripe.Invoice.
+func (i *Invoice) copy() *Invoice {
+	cp := *i
+
+	if i.Invoice != nil {
+		inv := *i.Invoice
+		cp.Invoice = &inv
+	}
+	return &cp
+}
+
+// MarshalJSON encodes the Invoice to JSON, with the time the Invoice was last
+// updated under the "updated" key.
+func (i *Invoice) MarshalJSON() ([]byte, error) {
+	return marshalFields(i.Invoice, map[string]interface{}{
+		"updated": i.Updated,
+	})
+}
+
+// UnmarshalJSON decodes the given JSON into the Invoice. The "updated" key is
+// decoded into Updated, since stripe.Invoice has no field for it.
+func (i *Invoice) UnmarshalJSON(b []byte) error {
+	if i.Invoice == nil {
+		i.Invoice = &stripe.Invoice{}
+	}
+	return unmarshalFields(b, i.Invoice, map[string]interface{}{
+		"updated": &i.Updated,
+	})
+}
+
+// Kind implements the Resource interface.
+func (i *Invoice) Kind() string { return "invoice" }
+
 // Endpoint implements the Resource interface.
 func (i *Invoice) Endpoint(uris ...string) string {
 	endpoint := invoiceEndpoint
@@ -59,18 +169,18 @@ func (i *Invoice) Endpoint(uris ...string) string {
 	return endpoint + strings.Join(uris, "/")
 }
 
-// Load implements the Resource interface.
-func (i *Invoice) Load(s *Stripe) error {
-	resp, err := s.Client.Get(i.Endpoint())
+// Raw returns the raw JSON of the Invoice. This is the JSON of the response the
+// Invoice was last decoded from, or the JSON kept by the store the Invoice was
+// retrieved from.
+func (i *Invoice) Raw() (json.RawMessage, error) { return rawJSON(i.raw, i.Invoice) }
 
-	if err != nil {
-		return err
-	}
+func (i *Invoice) setRaw(b json.RawMessage) { i.raw = b }
 
-	defer resp.Body.Close()
+// Load implements the Resource interface.
+func (i *Invoice) Load(s *Stripe) error { return i.LoadExpanded(s) }
 
-	if !respCode2xx(resp.StatusCode) {
-		return s.Error(resp)
-	}
-	return json.NewDecoder(resp.Body).Decode(&i.Invoice)
+// LoadExpanded will load in the Invoice from the Stripe API, expanding the
+// objects at the given paths in the response.
+func (i *Invoice) LoadExpanded(s *Stripe, expand ...string) error {
+	return s.get(i.Endpoint(), i, expand...)
 }
@@ -0,0 +1,281 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_InvoiceAllLineItems(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/invoices/in_123456/lines") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such invoice"}}`))
+			return
+		}
+
+		if r.URL.Query().Get("starting_after") == "il_2" {
+			w.Write([]byte(`{"has_more": false, "data": [{"id": "il_3"}]}`))
+			return
+		}
+		w.Write([]byte(`{"has_more": true, "data": [{"id": "il_1"}, {"id": "il_2"}]}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", newTestStore())
+	s.endpoint = srv.URL
+
+	inv := &Invoice{
+		Invoice: &stripe.Invoice{ID: "in_123456"},
+	}
+
+	items, err := inv.AllLineItems(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"il_1", "il_2", "il_3"}
+
+	if len(items) != len(expected) {
+		t.Fatalf("unexpected line items, expected=%d, got=%d\n", len(expected), len(items))
+	}
+
+	for i, id := range expected {
+		if items[i].ID != id {
+			t.Errorf("items[%d] - unexpected id, expected=%q, got=%q\n", i, id, items[i].ID)
+		}
+	}
+}
+
+func Test_RetrieveInvoice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/invoices/in_123456") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such invoice"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "in_123456", "number": "ABC-0001", "status": "paid"}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", newTestStore())
+	s.endpoint = srv.URL
+
+	inv, err := RetrieveInvoice(s, "in_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.Number != "ABC-0001" || inv.Status != stripe.InvoiceStatusPaid {
+		t.Fatalf("unexpected invoice, got=%+v\n", inv.Invoice)
+	}
+
+	_, err = RetrieveInvoice(s, "in_404")
+
+	if e, ok := err.(*Error); !ok || !e.IsNotFound() {
+		t.Fatalf("unexpected error, expected not found, got=%v\n", err)
+	}
+}
+
+func Test_RunDunning(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_123456/pay"):
+			w.Write([]byte(`{"id": "in_123456", "customer": "cus_123456", "status": "paid", "created": 1}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_222222"):
+			w.Write([]byte(`{"id": "in_222222", "customer": "cus_123456", "status": "open", "collection_method": "charge_automatically", "attempted": true, "created": 5}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_222222/pay"):
+			w.Write([]byte(`{"id": "in_222222", "customer": "cus_123456", "status": "paid", "created": 5}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_333333"):
+			w.Write([]byte(`{"id": "in_333333", "customer": "cus_123456", "status": "paid", "created": 6}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_654321/pay"):
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{"error": {"type": "card_error", "code": "card_declined", "message": "Your card was declined."}}`))
+		default:
+			t.Errorf("unexpected request %s\n", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	automatic := stripe.InvoiceCollectionMethodChargeAutomatically
+	sendInvoice := stripe.InvoiceCollectionMethodSendInvoice
+
+	store := NewMemoryStore()
+
+	resources := []Resource{
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_123456", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", CollectionMethod: &automatic, Attempted: true, Created: 1}},
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_654321", Customer: &stripe.Customer{ID: "cus_654321"}, Status: "open", CollectionMethod: &automatic, Attempted: true, Created: 2}},
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_000000", Customer: &stripe.Customer{ID: "cus_000000"}, Status: "open", CollectionMethod: &automatic, Attempted: true, Created: 3}},
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_111111", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "paid", Created: 4}},
+
+		// Loaded from Stripe since the collection method is not known, then paid.
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_222222", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", Created: 5}},
+
+		// Loaded from Stripe, and no longer open.
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_333333", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", Created: 6}},
+
+		// Paid by the Customer.
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_444444", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", CollectionMethod: &sendInvoice, Attempted: true, Created: 7}},
+
+		// Not yet attempted by Stripe.
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_555555", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", CollectionMethod: &automatic, Created: 8}},
+
+		// Not yet due.
+		&Invoice{Invoice: &stripe.Invoice{ID: "in_666666", Customer: &stripe.Customer{ID: "cus_123456"}, Status: "open", CollectionMethod: &automatic, Attempted: true, DueDate: time.Now().Add(time.Hour).Unix(), Created: 9}},
+
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Customer: &stripe.Customer{ID: "cus_123456"}}, Default: true},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_654321", Customer: &stripe.Customer{ID: "cus_654321"}}, Default: true},
+	}
+
+	for _, r := range resources {
+		if err := store.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	errs := make([]error, 0)
+
+	retried, err := s.RunDunning(func(err error) {
+		errs = append(errs, err)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if retried != 2 {
+		t.Fatalf("unexpected retried invoices, expected=%d, got=%d\n", 2, retried)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("unexpected errors, expected=%d, got=%d\n", 1, len(errs))
+	}
+
+	if serr, ok := errs[0].(*Error); !ok || !serr.IsDeclined() {
+		t.Fatalf("unexpected error, expected declined error, got=%v\n", errs[0])
+	}
+
+	invs, err := store.InvoicesByStatus("open")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(invs) != 5 {
+		t.Fatalf("unexpected open invoices, expected=%d, got=%d\n", 5, len(invs))
+	}
+
+	// The declined Invoice is retried again, without an error handler.
+	if _, err := s.RunDunning(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_InvoiceReceipt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("expand[]") != "charge" {
+			t.Errorf("expected charge to be expanded\n")
+		}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_123456"):
+			w.Write([]byte(`{"id": "in_123456", "charge": {"id": "ch_123456", "receipt_url": "https://pay.stripe.com/receipts/123456"}}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/invoices/in_654321"):
+			w.Write([]byte(`{"id": "in_654321", "charge": null}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such invoice"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	tests := []struct {
+		id       string
+		expected string
+		err      error
+	}{
+		{"in_123456", "https://pay.stripe.com/receipts/123456", nil},
+		{"in_654321", "", ErrNoReceipt},
+	}
+
+	for i, test := range tests {
+		inv := &Invoice{
+			Invoice: &stripe.Invoice{ID: test.id},
+		}
+
+		url, err := inv.Receipt(s)
+
+		if err != test.err {
+			t.Fatalf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.err, err)
+		}
+
+		if url != test.expected {
+			t.Errorf("tests[%d] - unexpected receipt url, expected=%q, got=%q\n", i, test.expected, url)
+		}
+	}
+}
+
+func Test_SyncInvoices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/invoices") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		if customer := r.URL.Query().Get("customer"); customer != "cus_123456" {
+			t.Errorf("unexpected customer, expected=%q, got=%q\n", "cus_123456", customer)
+		}
+
+		// The second invoice is returned without a customer, so the one
+		// given to SyncInvoices is used.
+		if r.URL.Query().Get("starting_after") == "" {
+			w.Write([]byte(`{"has_more": true, "data": [{"id": "in_123456", "number": "0001", "customer": "cus_123456", "status": "paid"}]}`))
+			return
+		}
+		w.Write([]byte(`{"has_more": false, "data": [{"id": "in_654321", "number": "0002", "status": "open"}]}`))
+	}))
+	defer srv.Close()
+
+	store := newTestStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	if err := s.SyncInvoices(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, number := range []string{"0001", "0002"} {
+		inv, ok, err := store.LookupInvoice(c, number)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ok {
+			t.Fatalf("expected invoice %q to be stored\n", number)
+		}
+
+		if inv.Customer.ID != c.ID {
+			t.Errorf("unexpected customer, expected=%q, got=%q\n", c.ID, inv.Customer.ID)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package stripeutil
+
+// Logger is the interface used for logging what happens within the library,
+// such as the requests made to Stripe, the webhook events dispatched, and the
+// resources put in the underlying store.
+type Logger interface {
+	// Debugf logs a debug message with the given format and values.
+	Debugf(format string, v ...interface{})
+
+	// Errorf logs an error message with the given format and values.
+	Errorf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+var _ Logger = nopLogger{}
+
+func (nopLogger) Debugf(_ string, _ ...interface{}) {}
+func (nopLogger) Errorf(_ string, _ ...interface{}) {}
@@ -0,0 +1,157 @@
+package stripeutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type testLogger struct {
+	debug []string
+	error []string
+}
+
+var _ Logger = (*testLogger)(nil)
+
+func (l *testLogger) Debugf(format string, v ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Errorf(format string, v ...interface{}) {
+	l.error = append(l.error, fmt.Sprintf(format, v...))
+}
+
+type errStore struct {
+	TestStore
+}
+
+var errStorePut = errors.New("put failed")
+
+func (errStore) Put(_ Resource) error { return errStorePut }
+
+func Test_LoggerRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "cus_123456"}`))
+	}))
+	defer srv.Close()
+
+	l := &testLogger{}
+
+	c := NewClient("2020-08-27", "sk_test_123456")
+	c.endpoint = srv.URL
+	c.SetLogger(l)
+
+	resp, err := c.Get("/v1/customers/cus_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := []string{
+		"GET /v1/customers/cus_123456",
+		"GET /v1/customers/cus_123456: 200 OK",
+	}
+
+	if len(l.debug) != len(expected) {
+		t.Fatalf("unexpected debug logs, expected=%d, got=%d\n", len(expected), len(l.debug))
+	}
+
+	for i, msg := range expected {
+		if l.debug[i] != msg {
+			t.Errorf("debug[%d] - unexpected log, expected=%q, got=%q\n", i, msg, l.debug[i])
+		}
+	}
+
+	if len(l.error) != 0 {
+		t.Fatalf("unexpected error logs, expected=%d, got=%d\n", 0, len(l.error))
+	}
+
+	// Point the Client at a closed server so the request itself fails.
+	srv.Close()
+
+	if _, err := c.Get("/v1/customers/cus_123456"); err == nil {
+		t.Fatal("expected request to fail")
+	}
+
+	if len(l.error) != 1 {
+		t.Fatalf("unexpected error logs, expected=%d, got=%d\n", 1, len(l.error))
+	}
+
+	if !strings.HasPrefix(l.error[0], "GET /v1/customers/cus_123456: ") {
+		t.Fatalf("unexpected error log, got=%q\n", l.error[0])
+	}
+}
+
+func Test_LoggerStore(t *testing.T) {
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	l := &testLogger{}
+
+	s := New("sk_test_123456", newTestStore())
+	s.SetLogger(l)
+
+	if err := s.Put(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.debug) != 1 || l.debug[0] != "put /v1/customers/cus_123456" {
+		t.Fatalf("unexpected debug logs, expected=%q, got=%q\n", "put /v1/customers/cus_123456", l.debug)
+	}
+
+	l = &testLogger{}
+
+	s = New("sk_test_123456", errStore{TestStore: newTestStore()})
+	s.SetLogger(l)
+
+	if err := s.Put(c); err != errStorePut {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", errStorePut, err)
+	}
+
+	if len(l.error) != 1 || l.error[0] != "put /v1/customers/cus_123456: put failed" {
+		t.Fatalf("unexpected error logs, expected=%q, got=%q\n", "put /v1/customers/cus_123456: put failed", l.error)
+	}
+}
+
+func Test_HookHandlerLogger(t *testing.T) {
+	l := &testLogger{}
+
+	hook := NewHookHandler("whsec_123456", NewMemoryStore(), func(err error) {
+		t.Error(err)
+	})
+	hook.SetLogger(l)
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	hook.Replay(stripe.Event{ID: "evt_123456", Type: "invoice.paid"})
+	hook.Replay(stripe.Event{ID: "evt_654321", Type: "customer.created"})
+
+	expected := []string{
+		"replaying event evt_123456 invoice.paid",
+		"no handler for replayed event evt_654321 customer.created",
+	}
+
+	if len(l.debug) != len(expected) {
+		t.Fatalf("unexpected debug logs, expected=%d, got=%d\n", len(expected), len(l.debug))
+	}
+
+	for i, msg := range expected {
+		if l.debug[i] != msg {
+			t.Errorf("debug[%d] - unexpected log, expected=%q, got=%q\n", i, msg, l.debug[i])
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package stripeutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// MemoryStore is a Store that keeps the resources in memory. This is safe to
+// use concurrently. This would typically be used for smaller services that do
+// not require a database, since nothing stored will survive a restart. The
+// resources, along with the stripe-go structs embedded in them, are copied when
+// put into the store, and when retrieved, so changes made to a resource will
+// only be reflected once it is put into the store.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	customers      map[string]*Customer
+	events         map[string]struct{}
+	invoices       map[string]*Invoice
+	paymentMethods map[string]*PaymentMethod
+	subscriptions  map[string]*Subscription
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a new MemoryStore with nothing stored in it.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		customers:      make(map[string]*Customer),
+		events:         make(map[string]struct{}),
+		invoices:       make(map[string]*Invoice),
+		paymentMethods: make(map[string]*PaymentMethod),
+		subscriptions:  make(map[string]*Subscription),
+	}
+}
+
+// customerID returns the ID of the given stripe.Customer, if any.
+func customerID(c *stripe.Customer) string {
+	if c == nil {
+		return ""
+	}
+	return c.ID
+}
+
+// LookupCustomer will lookup the Customer by the given email.
+func (m *MemoryStore) LookupCustomer(email string) (*Customer, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, c := range m.customers {
+		if c.Email == email {
+			return c.copy(), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// LookupInvoice will lookup the Invoice for the given Customer by the given
+// number.
+func (m *MemoryStore) LookupInvoice(c *Customer, number string) (*Invoice, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, inv := range m.invoices {
+		if customerID(inv.Customer) == c.ID && inv.Number == number {
+			return inv.copy(), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// LogEvent will log the given event ID. If the event has already been logged
+// then ErrEventExists is returned.
+func (m *MemoryStore) LogEvent(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.events[id]; ok {
+		return ErrEventExists
+	}
+	m.events[id] = struct{}{}
+	return nil
+}
+
+// Subscription returns the latest Subscription for the given Customer.
+func (m *MemoryStore) Subscription(c *Customer) (*Subscription, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var latest *Subscription
+
+	for _, sub := range m.subscriptions {
+		if customerID(sub.Customer) != c.ID {
+			continue
+		}
+
+		if latest == nil || sub.StartDate > latest.StartDate {
+			latest = sub
+		}
+	}
+
+	if latest == nil {
+		return nil, false, nil
+	}
+
+	return latest.copy(), true, nil
+}
+
+// ActiveSubscriptions returns the active Subscriptions, sorted from newest to
+// oldest, and by ID if they started at the same time, using the given limit
+// and offset for pagination. A negative offset is treated as 0, and a limit of
+// 0 or less returns no Subscriptions.
+func (m *MemoryStore) ActiveSubscriptions(limit, offset int) ([]*Subscription, error) {
+	if offset < 0 {
+		offset = 0
+	}
+
+	if limit <= 0 {
+		return []*Subscription{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subs := make([]*Subscription, 0)
+
+	for _, sub := range m.subscriptions {
+		switch sub.Status {
+		case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+			subs = append(subs, sub.copy())
+		}
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].StartDate == subs[j].StartDate {
+			return subs[i].ID < subs[j].ID
+		}
+		return subs[i].StartDate > subs[j].StartDate
+	})
+
+	if offset >= len(subs) {
+		return []*Subscription{}, nil
+	}
+
+	subs = subs[offset:]
+
+	if limit < len(subs) {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
+// DefaultPaymentMethod returns the default PaymentMethod for the given
+// Customer.
+func (m *MemoryStore) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, pm := range m.paymentMethods {
+		if customerID(pm.Customer) == c.ID && pm.Default {
+			return pm.copy(), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Invoices returns all of the Invoices for the given Customer, sorted from
+// newest to oldest.
+func (m *MemoryStore) Invoices(c *Customer) ([]*Invoice, error) {
+	return m.getInvoices(func(inv *Invoice) bool {
+		return customerID(inv.Customer) == c.ID
+	}), nil
+}
+
+// InvoicesByStatus returns all of the Invoices with the given status, sorted
+// from newest to oldest.
+func (m *MemoryStore) InvoicesByStatus(status string) ([]*Invoice, error) {
+	return m.getInvoices(func(inv *Invoice) bool {
+		return string(inv.Status) == status
+	}), nil
+}
+
+func (m *MemoryStore) getInvoices(fn func(*Invoice) bool) []*Invoice {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	invs := make([]*Invoice, 0)
+
+	for _, inv := range m.invoices {
+		if fn(inv) {
+			invs = append(invs, inv.copy())
+		}
+	}
+
+	sort.Slice(invs, func(i, j int) bool {
+		return invs[i].Created > invs[j].Created
+	})
+	return invs
+}
+
+// PaymentMethods returns all of the PaymentMethods for the given Customer,
+// sorted from oldest to newest.
+func (m *MemoryStore) PaymentMethods(c *Customer) ([]*PaymentMethod, error) {
+	return m.getPaymentMethods(func(pm *PaymentMethod) bool {
+		return customerID(pm.Customer) == c.ID
+	}), nil
+}
+
+// ExpiringCards returns all of the card PaymentMethods that will have expired
+// by the given time, sorted from oldest to newest.
+func (m *MemoryStore) ExpiringCards(before time.Time) ([]*PaymentMethod, error) {
+	return m.getPaymentMethods(func(pm *PaymentMethod) bool {
+		if pm.Type != "card" || pm.Card == nil {
+			return false
+		}
+
+		expires := time.Date(int(pm.Card.ExpYear), time.Month(pm.Card.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+
+		return !expires.After(before)
+	}), nil
+}
+
+func (m *MemoryStore) getPaymentMethods(fn func(*PaymentMethod) bool) []*PaymentMethod {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pms := make([]*PaymentMethod, 0)
+
+	for _, pm := range m.paymentMethods {
+		if fn(pm) {
+			pms = append(pms, pm.copy())
+		}
+	}
+
+	sort.Slice(pms, func(i, j int) bool {
+		return pms[i].Created < pms[j].Created
+	})
+	return pms
+}
+
+// Put will put the given Resource into the store. Only the Customer, Invoice,
+// PaymentMethod, and Subscription resources are stored. If the given
+// PaymentMethod is the default, then any other PaymentMethod for the Customer
+// will no longer be the default.
+func (m *MemoryStore) Put(r Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch v := r.(type) {
+	case *Customer:
+		m.customers[v.ID] = v.copy()
+	case *Invoice:
+		m.invoices[v.ID] = v.copy()
+	case *PaymentMethod:
+		cp := v.copy()
+
+		if cp.Default {
+			for _, pm := range m.paymentMethods {
+				if customerID(pm.Customer) == customerID(cp.Customer) {
+					pm.Default = false
+				}
+			}
+		}
+
+		// Keep the PaymentMethod as the default if it already was.
+		if pm, ok := m.paymentMethods[v.ID]; ok && pm.Default {
+			cp.Default = true
+		}
+		m.paymentMethods[v.ID] = cp
+	case *Subscription:
+		m.subscriptions[v.ID] = v.copy()
+	}
+	return nil
+}
+
+// Remove will remove the given Resource from the store.
+func (m *MemoryStore) Remove(r Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch v := r.(type) {
+	case *Customer:
+		delete(m.customers, v.ID)
+	case *Invoice:
+		delete(m.invoices, v.ID)
+	case *PaymentMethod:
+		delete(m.paymentMethods, v.ID)
+	case *Subscription:
+		delete(m.subscriptions, v.ID)
+	}
+	return nil
+}
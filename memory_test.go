@@ -0,0 +1,236 @@
+package stripeutil
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_MemoryStoreDefaultPaymentMethod(t *testing.T) {
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	pms := []*PaymentMethod{
+		{PaymentMethod: &stripe.PaymentMethod{ID: "pm_1", Customer: c.Customer, Created: 1}, Default: true},
+		{PaymentMethod: &stripe.PaymentMethod{ID: "pm_2", Customer: c.Customer, Created: 2}, Default: true},
+		{PaymentMethod: &stripe.PaymentMethod{ID: "pm_3", Customer: c.Customer, Created: 3}},
+	}
+
+	for _, pm := range pms {
+		if err := store.Put(pm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pm, ok, err := store.DefaultPaymentMethod(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || pm.ID != "pm_2" {
+		t.Fatalf("unexpected default payment method, expected=%q, got=%v\n", "pm_2", pm)
+	}
+
+	all, err := store.PaymentMethods(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults := 0
+
+	for _, pm := range all {
+		if pm.Default {
+			defaults++
+		}
+	}
+
+	if defaults != 1 {
+		t.Fatalf("expected 1 default payment method, got %d\n", defaults)
+	}
+
+	// The original PaymentMethod given should not have been modified.
+	if !pms[0].Default {
+		t.Fatalf("expected original payment method to be unmodified\n")
+	}
+}
+
+func Test_MemoryStoreLogEvent(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.LogEvent("evt_123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.LogEvent("evt_123456"); err != ErrEventExists {
+		t.Fatalf("unexpected error, expected=%q, got=%q\n", ErrEventExists, err)
+	}
+}
+
+func Test_MemoryStoreConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			id := "cus_" + strconv.Itoa(i)
+
+			store.Put(&Customer{
+				Customer: &stripe.Customer{ID: id, Email: id + "@example.com"},
+			})
+			store.Put(&Subscription{
+				Subscription: &stripe.Subscription{
+					ID:        "sub_" + strconv.Itoa(i),
+					Customer:  &stripe.Customer{ID: id},
+					Status:    stripe.SubscriptionStatusActive,
+					StartDate: int64(i),
+				},
+			})
+			store.LookupCustomer(id + "@example.com")
+			store.ActiveSubscriptions(10, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	subs, err := store.ActiveSubscriptions(10, 45)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subs) != 5 {
+		t.Fatalf("expected 5 subscriptions, got %d\n", len(subs))
+	}
+
+	if subs[0].ID != "sub_4" {
+		t.Fatalf("unexpected subscription, expected=%q, got=%q\n", "sub_4", subs[0].ID)
+	}
+}
+
+func Test_MemoryStoreCopy(t *testing.T) {
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "old@example.com"},
+	}
+
+	if err := store.Put(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the Customer that was put should not change the stored
+	// Customer.
+	c.Email = "put@example.com"
+
+	found, ok, err := store.LookupCustomer("old@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected customer to be found by old email")
+	}
+
+	// Changing the Customer that was returned should not change the stored
+	// Customer either.
+	found.Email = "new@example.com"
+
+	if _, ok, _ := store.LookupCustomer("old@example.com"); !ok {
+		t.Fatal("expected customer to still be found by old email")
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusActive,
+		},
+	})
+
+	sub, _, err := store.Subscription(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub.Status = stripe.SubscriptionStatusCanceled
+
+	if sub, _, _ := store.Subscription(c); sub.Status != stripe.SubscriptionStatusActive {
+		t.Fatalf("unexpected stored subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusActive, sub.Status)
+	}
+}
+
+func Test_MemoryStoreActiveSubscriptions(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Every Subscription starts at the same time, so they are paged by ID.
+	for i := 0; i < 10; i++ {
+		id := strconv.Itoa(i)
+
+		store.Put(&Subscription{
+			Subscription: &stripe.Subscription{
+				ID:        "sub_" + id,
+				Customer:  &stripe.Customer{ID: "cus_" + id},
+				Status:    stripe.SubscriptionStatusActive,
+				StartDate: 1,
+			},
+		})
+	}
+
+	seen := make(map[string]struct{})
+
+	for offset := 0; offset < 10; offset += 3 {
+		subs, err := store.ActiveSubscriptions(3, offset)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sub := range subs {
+			if _, ok := seen[sub.ID]; ok {
+				t.Fatalf("unexpected subscription %q on more than one page\n", sub.ID)
+			}
+			seen[sub.ID] = struct{}{}
+		}
+	}
+
+	if len(seen) != 10 {
+		t.Fatalf("unexpected subscriptions, expected=%d, got=%d\n", 10, len(seen))
+	}
+
+	tests := []struct {
+		limit    int
+		offset   int
+		expected int
+	}{
+		{10, -1, 10},
+		{0, 0, 0},
+		{-1, 0, 0},
+		{5, 8, 2},
+		{5, 10, 0},
+	}
+
+	for i, test := range tests {
+		subs, err := store.ActiveSubscriptions(test.limit, test.offset)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(subs) != test.expected {
+			t.Errorf("tests[%d] - unexpected subscriptions, expected=%d, got=%d\n", i, test.expected, len(subs))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package stripeutil
+
+import (
+	"strings"
+	"time"
+)
+
+// Metrics is the interface used for instrumenting the requests made to the
+// Stripe API, and the webhook events received from Stripe.
+type Metrics interface {
+	// ObserveRequest observes a request made to the Stripe API with the
+	// given method and URI. The URI will have its query string removed, and
+	// the IDs of any objects in it replaced with {id}, so it can be used as a
+	// label without an unbounded number of values. The status will be the
+	// status code of the response, or 0 if no response was received. The
+	// duration is how long the request took.
+	ObserveRequest(method, uri string, status int, dur time.Duration)
+
+	// ObserveEvent observes a webhook event of the given type that has been
+	// received from Stripe.
+	ObserveEvent(eventType string)
+}
+
+type nopMetrics struct{}
+
+var _ Metrics = nopMetrics{}
+
+func (nopMetrics) ObserveRequest(_, _ string, _ int, _ time.Duration) {}
+func (nopMetrics) ObserveEvent(_ string)                              {}
+
+// metricsURI returns the given request URI without its query string, and with
+// the IDs of the objects in it replaced with {id}. An ID is taken to be any
+// segment of the path with an underscore, and a digit or uppercase letter,
+// such as cus_123456, which separates them from the names of endpoints such
+// as payment_methods.
+func metricsURI(uri string) string {
+	if i := strings.Index(uri, "?"); i >= 0 {
+		uri = uri[:i]
+	}
+
+	parts := strings.Split(uri, "/")
+
+	for i, part := range parts {
+		if strings.Contains(part, "_") && strings.ContainsAny(part, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
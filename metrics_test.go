@@ -0,0 +1,124 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+type testRequest struct {
+	method string
+	uri    string
+	status int
+}
+
+type testMetrics struct {
+	requests []testRequest
+	events   []string
+}
+
+var _ Metrics = (*testMetrics)(nil)
+
+func (m *testMetrics) ObserveRequest(method, uri string, status int, _ time.Duration) {
+	m.requests = append(m.requests, testRequest{method: method, uri: uri, status: status})
+}
+
+func (m *testMetrics) ObserveEvent(eventType string) {
+	m.events = append(m.events, eventType)
+}
+
+func Test_MetricsURI(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"/v1/customers", "/v1/customers"},
+		{"/v1/customers/cus_123456", "/v1/customers/{id}"},
+		{"/v1/customers/cus_JkLmNoPq/tax_ids", "/v1/customers/{id}/tax_ids"},
+		{"/v1/customers/cus_123456/tax_ids/txi_123456", "/v1/customers/{id}/tax_ids/{id}"},
+		{"/v1/payment_methods/pm_123456/attach", "/v1/payment_methods/{id}/attach"},
+		{"/v1/test_helpers/test_clocks/clock_123456/advance", "/v1/test_helpers/test_clocks/{id}/advance"},
+		{"/v1/invoices?customer=cus_123456&limit=100", "/v1/invoices"},
+	}
+
+	for i, test := range tests {
+		if uri := metricsURI(test.uri); uri != test.expected {
+			t.Errorf("tests[%d] - unexpected uri, expected=%q, got=%q\n", i, test.expected, uri)
+		}
+	}
+}
+
+func Test_MetricsRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"message": "Not found"}}`))
+	}))
+	defer srv.Close()
+
+	m := &testMetrics{}
+
+	c := NewClient("2020-08-27", "sk_test_123456")
+	c.endpoint = srv.URL
+	c.SetMetrics(m)
+
+	resp, err := c.Get("/v1/customers/cus_123456?expand[]=sources")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	srv.Close()
+
+	if _, err := c.Get("/v1/customers/cus_654321"); err == nil {
+		t.Fatal("expected request to fail")
+	}
+
+	expected := []testRequest{
+		{"GET", "/v1/customers/{id}", http.StatusNotFound},
+		{"GET", "/v1/customers/{id}", 0},
+	}
+
+	if len(m.requests) != len(expected) {
+		t.Fatalf("unexpected requests, expected=%d, got=%d\n", len(expected), len(m.requests))
+	}
+
+	for i, req := range expected {
+		if m.requests[i] != req {
+			t.Errorf("requests[%d] - unexpected request, expected=%v, got=%v\n", i, req, m.requests[i])
+		}
+	}
+}
+
+func Test_HookHandlerMetrics(t *testing.T) {
+	secret := "whsec_123456"
+
+	m := &testMetrics{}
+
+	hook := NewHookHandler(secret, NewMemoryStore(), func(err error) {
+		t.Error(err)
+	})
+	hook.SetMetrics(m)
+
+	hook.Handle("invoice.paid", func(_ stripe.Event, w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	hook.HandlerFunc(httptest.NewRecorder(), newHookRequest(secret, "evt_123456", "invoice.paid"))
+	hook.HandlerFunc(httptest.NewRecorder(), newHookRequest(secret, "evt_654321", "customer.created"))
+
+	expected := []string{"invoice.paid", "customer.created"}
+
+	if len(m.events) != len(expected) {
+		t.Fatalf("unexpected events, expected=%d, got=%d\n", len(expected), len(m.events))
+	}
+
+	for i, typ := range expected {
+		if m.events[i] != typ {
+			t.Errorf("events[%d] - unexpected event, expected=%q, got=%q\n", i, typ, m.events[i])
+		}
+	}
+}
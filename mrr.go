@@ -0,0 +1,178 @@
+package stripeutil
+
+import (
+	"math"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// mrrPageSize is the number of Subscriptions retrieved from the store at a
+// time when calculating the monthly recurring revenue.
+const mrrPageSize = 100
+
+// monthly returns the given amount, billed at the given recurring interval,
+// normalized to a single month. Amounts that are not recurring have no
+// monthly amount.
+func monthly(amount float64, r *stripe.PriceRecurring) float64 {
+	if r == nil {
+		return 0
+	}
+
+	count := r.IntervalCount
+
+	if count < 1 {
+		count = 1
+	}
+
+	amount /= float64(count)
+
+	switch r.Interval {
+	case stripe.PriceRecurringIntervalDay:
+		return amount * 365 / 12
+	case stripe.PriceRecurringIntervalWeek:
+		return amount * 52 / 12
+	case stripe.PriceRecurringIntervalMonth:
+		return amount
+	case stripe.PriceRecurringIntervalYear:
+		return amount / 12
+	default:
+		return 0
+	}
+}
+
+// discounted returns whether or not the given Discount still reduces the
+// amount of each invoice for a Subscription. A coupon that only applies once
+// does not recur, and a repeating coupon no longer applies once it has ended.
+func discounted(d *stripe.Discount) bool {
+	if d == nil || d.Coupon == nil {
+		return false
+	}
+
+	switch d.Coupon.Duration {
+	case stripe.CouponDurationOnce:
+		return false
+	case stripe.CouponDurationRepeating:
+		return d.End == 0 || time.Unix(d.End, 0).After(time.Now())
+	}
+	return true
+}
+
+// subscriptionMRR returns the monthly recurring revenue of the given
+// Subscription. The given Prices are used for resolving the price of each
+// item in the Subscription, falling back to the price on the item itself if
+// the price was not loaded. ErrTieredPrice is returned if any of the prices
+// are tiered.
+func subscriptionMRR(sub *stripe.Subscription, prices *Prices) (float64, error) {
+	if sub.Items == nil {
+		return 0, nil
+	}
+
+	var (
+		mrr       float64
+		recurring *stripe.PriceRecurring
+	)
+
+	for _, it := range sub.Items.Data {
+		pr := it.Price
+
+		if pr == nil {
+			continue
+		}
+
+		if prices != nil {
+			if loaded, err := prices.Get(pr.ID); err == nil {
+				pr = loaded.Price
+			}
+		}
+
+		if pr.BillingScheme == stripe.PriceBillingSchemeTiered {
+			return 0, ErrTieredPrice
+		}
+
+		if recurring == nil {
+			recurring = pr.Recurring
+		}
+		mrr += monthly(float64(pr.UnitAmount*it.Quantity), pr.Recurring)
+	}
+
+	if discounted(sub.Discount) {
+		c := sub.Discount.Coupon
+
+		if c.PercentOff > 0 {
+			mrr -= mrr * c.PercentOff / 100
+		}
+
+		// The amount off is taken from each invoice, so is normalized to a
+		// month the same as the prices the invoice is for.
+		mrr -= monthly(float64(c.AmountOff), recurring)
+	}
+	return math.Max(mrr, 0), nil
+}
+
+// MRR returns the monthly recurring revenue of all of the active
+// Subscriptions in the underlying store, in the smallest unit of each
+// currency. Subscriptions that are trialing are not included. Prices with an
+// interval other than monthly are normalized to a month, for example a yearly
+// price is divided by 12. The quantity of each Subscription item, and any
+// Discount on the Subscription is taken into account, a Discount that only
+// applies once, or that has ended is ignored. The given Prices are used for
+// resolving the price of each Subscription item. ErrTieredPrice is returned if
+// any Subscription has a tiered price, since its amount depends on usage. The
+// annual recurring revenue would be the returned amounts multiplied by 12.
+//
+// The items of each Subscription are taken from the store, or from the raw
+// JSON of the Subscription if the store keeps it. A Subscription stored
+// without either, such as by PSQL without KeepRaw, is loaded from Stripe.
+func (s *Stripe) MRR(prices *Prices) (map[stripe.Currency]int64, error) {
+	mrr := make(map[stripe.Currency]float64)
+
+	offset := 0
+
+	for {
+		subs, err := s.ActiveSubscriptions(mrrPageSize, offset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sub := range subs {
+			if sub.Status != stripe.SubscriptionStatusActive {
+				continue
+			}
+
+			if err := s.subscriptionItems(sub); err != nil {
+				return nil, err
+			}
+
+			// The Subscription may have been loaded from Stripe, and
+			// no longer be active.
+			if sub.Status != stripe.SubscriptionStatusActive {
+				continue
+			}
+
+			if sub.Items == nil || len(sub.Items.Data) == 0 || sub.Items.Data[0].Price == nil {
+				continue
+			}
+
+			amount, err := subscriptionMRR(sub.Subscription, prices)
+
+			if err != nil {
+				return nil, err
+			}
+			mrr[sub.Items.Data[0].Price.Currency] += amount
+		}
+
+		if len(subs) < mrrPageSize {
+			break
+		}
+		offset += len(subs)
+	}
+
+	amounts := make(map[stripe.Currency]int64)
+
+	for currency, amount := range mrr {
+		amounts[currency] = int64(math.Round(amount))
+	}
+	return amounts, nil
+}
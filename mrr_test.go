@@ -0,0 +1,252 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_subscriptionMRR(t *testing.T) {
+	monthly := &stripe.Price{
+		ID:         "price_monthly",
+		UnitAmount: 1000,
+		Recurring: &stripe.PriceRecurring{
+			Interval:      stripe.PriceRecurringIntervalMonth,
+			IntervalCount: 1,
+		},
+	}
+
+	yearly := &stripe.Price{
+		ID:         "price_yearly",
+		UnitAmount: 12000,
+		Recurring: &stripe.PriceRecurring{
+			Interval:      stripe.PriceRecurringIntervalYear,
+			IntervalCount: 1,
+		},
+	}
+
+	quarterly := &stripe.Price{
+		ID:         "price_quarterly",
+		UnitAmount: 3000,
+		Recurring: &stripe.PriceRecurring{
+			Interval:      stripe.PriceRecurringIntervalMonth,
+			IntervalCount: 3,
+		},
+	}
+
+	items := func(its ...*stripe.SubscriptionItem) *stripe.SubscriptionItemList {
+		return &stripe.SubscriptionItemList{Data: its}
+	}
+
+	tests := []struct {
+		sub      *stripe.Subscription
+		expected float64
+	}{
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+			},
+			1000,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: yearly, Quantity: 2}),
+			},
+			2000,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(
+					&stripe.SubscriptionItem{Price: monthly, Quantity: 1},
+					&stripe.SubscriptionItem{Price: quarterly, Quantity: 1},
+				),
+			},
+			2000,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{PercentOff: 25, Duration: stripe.CouponDurationForever},
+				},
+			},
+			750,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{AmountOff: 2000, Duration: stripe.CouponDurationForever},
+				},
+			},
+			0,
+		},
+		{
+			// The amount off is taken from each yearly invoice.
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: yearly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{AmountOff: 1200, Duration: stripe.CouponDurationForever},
+				},
+			},
+			900,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{PercentOff: 50, Duration: stripe.CouponDurationOnce},
+				},
+			},
+			1000,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{PercentOff: 50, Duration: stripe.CouponDurationRepeating},
+					End:    time.Now().Add(time.Hour * 24 * 30).Unix(),
+				},
+			},
+			500,
+		},
+		{
+			&stripe.Subscription{
+				Items: items(&stripe.SubscriptionItem{Price: monthly, Quantity: 1}),
+				Discount: &stripe.Discount{
+					Coupon: &stripe.Coupon{PercentOff: 50, Duration: stripe.CouponDurationRepeating},
+					End:    time.Now().Add(-time.Hour).Unix(),
+				},
+			},
+			1000,
+		},
+	}
+
+	for i, test := range tests {
+		mrr, err := subscriptionMRR(test.sub, nil)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if mrr != test.expected {
+			t.Errorf("tests[%d] - unexpected mrr, expected=%v, got=%v\n", i, test.expected, mrr)
+		}
+	}
+}
+
+func Test_subscriptionMRRTiered(t *testing.T) {
+	sub := &stripe.Subscription{
+		Items: &stripe.SubscriptionItemList{
+			Data: []*stripe.SubscriptionItem{
+				{
+					Price: &stripe.Price{
+						ID:            "price_tiered",
+						BillingScheme: stripe.PriceBillingSchemeTiered,
+						Recurring:     &stripe.PriceRecurring{Interval: stripe.PriceRecurringIntervalMonth},
+					},
+					Quantity: 1,
+				},
+			},
+		},
+	}
+
+	if _, err := subscriptionMRR(sub, nil); err != ErrTieredPrice {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrTieredPrice, err)
+	}
+}
+
+func Test_MRR(t *testing.T) {
+	requests := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Write([]byte(`{
+			"id": "sub_000000",
+			"status": "active",
+			"items": {
+				"data": [{"id": "si_000000", "quantity": 1, "price": {"id": "price_123456", "currency": "gbp", "unit_amount": 500, "recurring": {"interval": "month", "interval_count": 1}}}]
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	price := &stripe.Price{
+		ID:         "price_123456",
+		Currency:   stripe.CurrencyGBP,
+		UnitAmount: 1000,
+		Recurring: &stripe.PriceRecurring{
+			Interval:      stripe.PriceRecurringIntervalMonth,
+			IntervalCount: 1,
+		},
+	}
+
+	items := &stripe.SubscriptionItemList{
+		Data: []*stripe.SubscriptionItem{{Price: price, Quantity: 1}},
+	}
+
+	store := NewMemoryStore()
+
+	subs := []*Subscription{
+		{
+			Subscription: &stripe.Subscription{
+				ID:       "sub_123456",
+				Customer: &stripe.Customer{ID: "cus_123456"},
+				Status:   stripe.SubscriptionStatusActive,
+				Items:    items,
+			},
+		},
+		{
+			Subscription: &stripe.Subscription{
+				ID:       "sub_654321",
+				Customer: &stripe.Customer{ID: "cus_654321"},
+				Status:   stripe.SubscriptionStatusTrialing,
+				Items:    items,
+			},
+		},
+		{
+			// Stored without its items, but with its raw JSON.
+			Subscription: &stripe.Subscription{
+				ID:       "sub_abcdef",
+				Customer: &stripe.Customer{ID: "cus_abcdef"},
+				Status:   stripe.SubscriptionStatusActive,
+			},
+			raw: []byte(`{"id": "sub_abcdef", "items": {"data": [{"id": "si_abcdef", "quantity": 2, "price": {"id": "price_123456", "currency": "gbp", "unit_amount": 1000, "recurring": {"interval": "month", "interval_count": 1}}}]}}`),
+		},
+		{
+			// Stored without its items, so is loaded from Stripe.
+			Subscription: &stripe.Subscription{
+				ID:       "sub_000000",
+				Customer: &stripe.Customer{ID: "cus_000000"},
+				Status:   stripe.SubscriptionStatusActive,
+			},
+		},
+	}
+
+	for _, sub := range subs {
+		if err := store.Put(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	mrr, err := s.MRR(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mrr[stripe.CurrencyGBP] != 3500 {
+		t.Fatalf("unexpected mrr, expected=%d, got=%d\n", 3500, mrr[stripe.CurrencyGBP])
+	}
+
+	if requests != 1 {
+		t.Fatalf("unexpected number of requests, expected=%d, got=%d\n", 1, requests)
+	}
+}
@@ -0,0 +1,32 @@
+package stripeutil
+
+import "time"
+
+// NopStore is a Store that does not store anything. Lookups will never find
+// anything, and putting or removing resources does nothing. This can be used
+// if you want to use Stripe without persisting any of the resources, for
+// example in prototypes, or if Stripe is treated as the source of truth.
+//
+// Since events are not logged, a HookHandler using a NopStore will not be able
+// to detect events that Stripe has delivered more than once, so the handlers
+// for the events should be idempotent, or HookHandler.SetDedupWindow should be
+// used.
+type NopStore struct{}
+
+var _ Store = NopStore{}
+
+func (NopStore) LookupCustomer(_ string) (*Customer, bool, error)            { return nil, false, nil }
+func (NopStore) LookupInvoice(_ *Customer, _ string) (*Invoice, bool, error) { return nil, false, nil }
+func (NopStore) LogEvent(_ string) error                                     { return nil }
+func (NopStore) Subscription(_ *Customer) (*Subscription, bool, error)       { return nil, false, nil }
+func (NopStore) ActiveSubscriptions(_, _ int) ([]*Subscription, error)       { return nil, nil }
+func (NopStore) Invoices(_ *Customer) ([]*Invoice, error)                    { return nil, nil }
+func (NopStore) InvoicesByStatus(_ string) ([]*Invoice, error)               { return nil, nil }
+func (NopStore) PaymentMethods(_ *Customer) ([]*PaymentMethod, error)        { return nil, nil }
+func (NopStore) ExpiringCards(_ time.Time) ([]*PaymentMethod, error)         { return nil, nil }
+func (NopStore) Put(_ Resource) error                                        { return nil }
+func (NopStore) Remove(_ Resource) error                                     { return nil }
+
+func (NopStore) DefaultPaymentMethod(_ *Customer) (*PaymentMethod, bool, error) {
+	return nil, false, nil
+}
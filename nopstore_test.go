@@ -0,0 +1,50 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_NopStore(t *testing.T) {
+	var created int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&created, 1)
+		w.Write([]byte(`{"id": "cus_123456", "email": "me@example.com"}`))
+	}))
+	defer srv.Close()
+
+	stores := []Store{nil, NopStore{}}
+
+	for i, store := range stores {
+		atomic.StoreInt32(&created, 0)
+
+		s := New("sk_test_123456", store)
+		s.endpoint = srv.URL
+
+		for j := 0; j < 2; j++ {
+			c, err := s.Customer("me@example.com")
+
+			if err != nil {
+				t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+			}
+
+			if c.ID != "cus_123456" {
+				t.Fatalf("tests[%d] - unexpected customer id, expected=%q, got=%q\n", i, "cus_123456", c.ID)
+			}
+		}
+
+		// Nothing is stored, so the customer should be created each time.
+		if n := atomic.LoadInt32(&created); n != 2 {
+			t.Errorf("tests[%d] - expected customer to be created 2 times, got %d\n", i, n)
+		}
+
+		sub, ok, err := s.Subscription(&Customer{})
+
+		if err != nil || ok || sub != nil {
+			t.Errorf("tests[%d] - expected no subscription, got=%v, ok=%v, err=%v\n", i, sub, ok, err)
+		}
+	}
+}
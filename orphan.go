@@ -0,0 +1,131 @@
+package stripeutil
+
+import "github.com/stripe/stripe-go/v72"
+
+// orphanPageSize is the number of Subscriptions retrieved from the store at a
+// time when finding orphaned records.
+const orphanPageSize = 100
+
+// FindOrphans will find the records in the underlying data store for resources
+// that no longer exist in Stripe. This returns the IDs of the orphaned
+// Customers, Subscriptions, and PaymentMethods. A resource is orphaned if it
+// cannot be found in Stripe, or if it was deleted in Stripe. A PaymentMethod
+// is also orphaned if it has been detached from its Customer.
+//
+// The records are sampled from the active Subscriptions in the store, since the
+// store does not provide a way of listing every Customer. The Customer of each
+// Subscription is checked, along with the PaymentMethods of that Customer. Any
+// errors that occur when checking a resource against Stripe are passed to the
+// given errh callback, and that resource is not reported as orphaned. An error
+// is only returned if the records could not be retrieved from the store.
+//
+// This is a diagnostic for keeping the store clean, and makes a request to
+// Stripe for every record checked, so should not be called frequently.
+func (s *Stripe) FindOrphans(errh func(error)) ([]string, []string, []string, error) {
+	customers := make([]string, 0)
+	subscriptions := make([]string, 0)
+	paymentMethods := make([]string, 0)
+
+	seen := make(map[string]struct{})
+
+	offset := 0
+
+	for {
+		subs, err := s.ActiveSubscriptions(orphanPageSize, offset)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		for _, sub := range subs {
+			r := &Subscription{
+				Subscription: &stripe.Subscription{ID: sub.ID},
+			}
+
+			orphan, err := s.orphaned(r)
+
+			if err != nil {
+				errh(err)
+			}
+
+			if orphan {
+				subscriptions = append(subscriptions, sub.ID)
+			}
+
+			id := customerID(sub.Customer)
+
+			if id == "" {
+				continue
+			}
+
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+
+			c := &Customer{
+				Customer: &stripe.Customer{ID: id},
+			}
+
+			orphan, err = s.orphaned(c)
+
+			if err != nil {
+				errh(err)
+				continue
+			}
+
+			if orphan {
+				customers = append(customers, id)
+				continue
+			}
+
+			pms, err := s.PaymentMethods(c)
+
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			for _, pm := range pms {
+				r := &PaymentMethod{
+					PaymentMethod: &stripe.PaymentMethod{ID: pm.ID},
+				}
+
+				orphan, err := s.orphaned(r)
+
+				if err != nil {
+					errh(err)
+					continue
+				}
+
+				if orphan || customerID(r.Customer) != id {
+					paymentMethods = append(paymentMethods, pm.ID)
+				}
+			}
+		}
+
+		if len(subs) < orphanPageSize {
+			break
+		}
+		offset += len(subs)
+	}
+	return customers, subscriptions, paymentMethods, nil
+}
+
+// orphaned loads the given Resource from Stripe, and returns whether or not it
+// no longer exists.
+func (s *Stripe) orphaned(r Resource) (bool, error) {
+	err := r.Load(s)
+
+	if err == nil {
+		return false, nil
+	}
+
+	if err == ErrCustomerDeleted {
+		return true, nil
+	}
+
+	if serr, ok := err.(*Error); ok && serr.IsNotFound() {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,100 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_FindOrphans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/subscriptions/sub_123456"):
+			w.Write([]byte(`{"id": "sub_123456", "customer": "cus_123456", "status": "active"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_654321"):
+			w.Write([]byte(`{"id": "cus_654321", "deleted": true}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456"):
+			w.Write([]byte(`{"id": "pm_123456", "customer": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_000000"):
+			w.Write([]byte(`{"id": "pm_000000", "customer": null}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_111111"):
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": {"message": "Internal error"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	cus1 := &stripe.Customer{ID: "cus_123456"}
+	cus2 := &stripe.Customer{ID: "cus_654321"}
+
+	resources := []Resource{
+		&Subscription{Subscription: &stripe.Subscription{ID: "sub_123456", Customer: cus1, Status: "active"}},
+		&Subscription{Subscription: &stripe.Subscription{ID: "sub_654321", Customer: cus2, Status: "active"}},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Customer: cus1}},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_654321", Customer: cus1}},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_000000", Customer: cus1}},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{ID: "pm_111111", Customer: cus1}},
+	}
+
+	for _, r := range resources {
+		if err := store.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	errs := make([]error, 0)
+
+	customers, subscriptions, paymentMethods, err := s.FindOrphans(func(err error) {
+		errs = append(errs, err)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("unexpected errors, expected=%d, got=%d\n", 1, len(errs))
+	}
+
+	tests := []struct {
+		name     string
+		expected []string
+		ids      []string
+	}{
+		{"customers", []string{"cus_654321"}, customers},
+		{"subscriptions", []string{"sub_654321"}, subscriptions},
+		{"payment methods", []string{"pm_000000", "pm_654321"}, paymentMethods},
+	}
+
+	for i, test := range tests {
+		ids := make(map[string]struct{})
+
+		for _, id := range test.ids {
+			ids[id] = struct{}{}
+		}
+
+		if len(ids) != len(test.expected) {
+			t.Errorf("tests[%d] - unexpected orphaned %s, expected=%v, got=%v\n", i, test.name, test.expected, test.ids)
+			continue
+		}
+
+		for _, id := range test.expected {
+			if _, ok := ids[id]; !ok {
+				t.Errorf("tests[%d] - expected %q in orphaned %s\n", i, id, test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package stripeutil
+
+import (
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// PaymentIntent is the PaymentIntent resource from Stripe. Embedded in this
+// struct is the stripe.PaymentIntent struct from Stripe.
+type PaymentIntent struct {
+	*stripe.PaymentIntent
+}
+
+var (
+	_ Resource = (*PaymentIntent)(nil)
+
+	paymentIntentEndpoint = "/v1/payment_intents"
+)
+
+// CreatePaymentIntent will create a new PaymentIntent in Stripe with the given
+// request Params.
+func CreatePaymentIntent(s *Stripe, params Params) (*PaymentIntent, error) {
+	pi := &PaymentIntent{}
+
+	err := s.post(paymentIntentEndpoint, params, &pi.PaymentIntent)
+	return pi, err
+}
+
+// RetrievePaymentIntent will get the PaymentIntent of the given ID from Stripe
+// and return it.
+func RetrievePaymentIntent(s *Stripe, id string) (*PaymentIntent, error) {
+	pi := &PaymentIntent{
+		PaymentIntent: &stripe.PaymentIntent{
+			ID: id,
+		},
+	}
+
+	err := pi.Load(s)
+	return pi, err
+}
+
+// Kind implements the Resource interface.
+func (pi *PaymentIntent) Kind() string { return "payment_intent" }
+
+// Endpoint implements the Resource interface.
+func (pi *PaymentIntent) Endpoint(uris ...string) string {
+	endpoint := paymentIntentEndpoint
+
+	if pi.ID != "" {
+		endpoint += "/" + pi.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Load implements the Resource interface.
+func (pi *PaymentIntent) Load(s *Stripe) error { return pi.LoadExpanded(s) }
+
+// LoadExpanded will load in the PaymentIntent from the Stripe API, expanding
+// the objects at the given paths in the response.
+func (pi *PaymentIntent) LoadExpanded(s *Stripe, expand ...string) error {
+	return s.get(pi.Endpoint(), &pi.PaymentIntent, expand...)
+}
+
+// SendReceipt will set the receipt email of the PaymentIntent to the given
+// email. Stripe will send a receipt to this email once the PaymentIntent has
+// succeeded, or immediately if it already has. This would be used for sending
+// the receipt of a one-off payment to an email other than the Customer's, or
+// for re-sending a receipt.
+func (pi *PaymentIntent) SendReceipt(s *Stripe, email string) error {
+	return s.post(pi.Endpoint(), Params{"receipt_email": email}, &pi.PaymentIntent)
+}
+
+// ChargeCustomer will create a one-off payment of the given amount, in the
+// given currency, for the given Customer using the given PaymentMethod. The
+// amount should be in the smallest unit of the currency, for example pence
+// for "gbp". The given Params will be passed through directly to the request
+// that creates the PaymentIntent in Stripe, this can be used for setting a
+// description, or metadata for the payment. The PaymentIntent is created and
+// confirmed off-session, so the Customer does not need to be present.
+//
+// If the payment does not succeed then this will be returned via
+// ErrPaymentIntent, the same as with Subscribe. If the payment requires further
+// action, such as 3D Secure authentication, then the client secret of the
+// PaymentIntent is set on the ErrPaymentIntent, this should be passed to
+// Stripe.js to complete the payment with the Customer present. Stripe responds
+// to an off-session payment that fails with a 402, in which case the
+// PaymentIntent is taken from the error in the response. A payment that is
+// processing is not treated as an error.
+//
+// If the Customer has an email then the receipt for the payment is sent to it,
+// unless the receipt_email is set in the given Params.
+func (s *Stripe) ChargeCustomer(c *Customer, pm *PaymentMethod, amount int64, currency string, params Params) (*PaymentIntent, error) {
+	p := Params{}
+
+	for k, v := range params {
+		p[k] = v
+	}
+
+	if _, ok := p["receipt_email"]; !ok && c.Email != "" {
+		p["receipt_email"] = c.Email
+	}
+
+	p["amount"] = amount
+	p["currency"] = currency
+	p["customer"] = c.ID
+	p["payment_method"] = pm.ID
+	p["off_session"] = true
+	p["confirm"] = true
+
+	pi, err := CreatePaymentIntent(s, p)
+
+	if err != nil {
+		serr, ok := err.(*Error)
+
+		if !ok || serr.Err.PaymentIntent == nil {
+			return pi, err
+		}
+		pi.PaymentIntent = serr.Err.PaymentIntent
+	}
+
+	switch pi.Status {
+	case stripe.PaymentIntentStatusProcessing, stripe.PaymentIntentStatusSucceeded:
+		return pi, nil
+	}
+	return pi, ErrPaymentIntent{
+		ID:           pi.ID,
+		Status:       pi.Status,
+		ClientSecret: pi.ClientSecret,
+	}
+}
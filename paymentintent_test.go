@@ -0,0 +1,110 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_ChargeCustomer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/payment_intents") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+
+		if r.PostForm.Get("off_session") != "true" || r.PostForm.Get("confirm") != "true" {
+			t.Errorf("expected payment intent to be confirmed off session\n")
+		}
+
+		if r.PostForm.Get("amount") != "2000" {
+			t.Errorf("unexpected amount, expected=%q, got=%q\n", "2000", r.PostForm.Get("amount"))
+		}
+
+		if r.PostForm.Get("receipt_email") != "me@example.com" {
+			t.Errorf("unexpected receipt email, expected=%q, got=%q\n", "me@example.com", r.PostForm.Get("receipt_email"))
+		}
+
+		switch r.PostForm.Get("payment_method") {
+		case "pm_123456":
+			w.Write([]byte(`{"id": "pi_123456", "status": "succeeded"}`))
+		case "pm_654321":
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{
+				"error": {
+					"code": "authentication_required",
+					"message": "This payment requires authentication.",
+					"type": "card_error",
+					"payment_intent": {"id": "pi_654321", "status": "requires_action", "client_secret": "pi_654321_secret"}
+				}
+			}`))
+		case "pm_000000":
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{"error": {"code": "card_declined", "message": "Your card was declined.", "type": "card_error"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456"},
+	}
+
+	pi, err := s.ChargeCustomer(c, pm, 2000, "gbp", nil)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if pi.ID != "pi_123456" {
+		t.Fatalf("unexpected payment intent, expected=%q, got=%q\n", "pi_123456", pi.ID)
+	}
+
+	pm.ID = "pm_654321"
+
+	params := Params{"description": "One-off purchase"}
+
+	_, err = s.ChargeCustomer(c, pm, 2000, "gbp", params)
+
+	if len(params) != 1 {
+		t.Fatalf("unexpected params, expected caller's params to be left as is, got=%v\n", params)
+	}
+
+	pierr, ok := err.(ErrPaymentIntent)
+
+	if !ok {
+		t.Fatalf("unexpected error, expected=ErrPaymentIntent, got=%T\n", err)
+	}
+
+	if !pierr.RequiresAction() {
+		t.Fatalf("expected payment intent to require action, got status=%q\n", pierr.Status)
+	}
+
+	if pierr.ClientSecret != "pi_654321_secret" {
+		t.Fatalf("unexpected client secret, expected=%q, got=%q\n", "pi_654321_secret", pierr.ClientSecret)
+	}
+
+	if pierr.ID != "pi_654321" {
+		t.Fatalf("unexpected payment intent, expected=%q, got=%q\n", "pi_654321", pierr.ID)
+	}
+
+	pm.ID = "pm_000000"
+
+	_, err = s.ChargeCustomer(c, pm, 2000, "gbp", nil)
+
+	if serr, ok := err.(*Error); !ok || !serr.IsDeclined() {
+		t.Fatalf("unexpected error, expected declined *Error, got=%T\n", err)
+	}
+}
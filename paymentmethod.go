@@ -2,43 +2,80 @@ package stripeutil
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/stripe/stripe-go/v72"
 )
 
+// PaymentMethodTypeUSBankAccount is the type of a PaymentMethod for ACH Direct
+// Debit. This is not yet defined by stripe-go.
+const PaymentMethodTypeUSBankAccount stripe.PaymentMethodType = "us_bank_account"
+
 // PaymentMethod is the PaymentMethod resource from Stripe. Embedded in this
 // struct is the stripe.PaymentMethod struct from Stripe.
 type PaymentMethod struct {
 	*stripe.PaymentMethod
 
 	Default bool // Default is whether or not this is a default PaymentMethod for the Customer.
+
+	// USBankAccount is the details of a PaymentMethod for ACH Direct Debit.
+	// This is not yet supported by stripe-go, so is decoded by UnmarshalJSON.
+	// It is set when the PaymentMethod is retrieved, loaded, attached, or
+	// updated via Stripe, or retrieved from a store.
+	USBankAccount *USBankAccount
+
+	raw json.RawMessage
+}
+
+// USBankAccount is the details of a PaymentMethod of the type
+// "us_bank_account".
+type USBankAccount struct {
+	AccountType   string `json:"account_type"`
+	BankName      string `json:"bank_name"`
+	Last4         string `json:"last4"`
+	RoutingNumber string `json:"routing_number"`
 }
 
 var (
 	_ Resource = (*PaymentMethod)(nil)
 
 	paymentMethodEndpoint = "/v1/payment_methods"
-)
 
-func postPaymentMethod(s *Stripe, uri string, params map[string]interface{}) (*PaymentMethod, error) {
-	pm := &PaymentMethod{
-		PaymentMethod: &stripe.PaymentMethod{},
-	}
+	// ErrUnknownIntent is returned from PaymentMethodFromIntent when the given
+	// ID is not for either a SetupIntent or a PaymentIntent.
+	ErrUnknownIntent = errors.New("unknown intent")
 
-	resp, err := s.Post(uri, params)
+	// ErrNoPaymentMethod is returned from PaymentMethodFromIntent when the
+	// intent does not have a PaymentMethod.
+	ErrNoPaymentMethod = errors.New("intent has no payment method")
 
-	if err != nil {
-		return pm, err
+	delayedPaymentMethodTypes = map[stripe.PaymentMethodType]struct{}{
+		stripe.PaymentMethodTypeAUBECSDebit: {},
+		stripe.PaymentMethodTypeBACSDebit:   {},
+		stripe.PaymentMethodTypeSepaDebit:   {},
+		stripe.PaymentMethodTypeSofort:      {},
+		PaymentMethodTypeUSBankAccount:      {},
 	}
 
-	defer resp.Body.Close()
+	cardBrandNames = map[stripe.PaymentMethodCardBrand]string{
+		stripe.PaymentMethodCardBrandAmex:       "American Express",
+		stripe.PaymentMethodCardBrandDiners:     "Diners Club",
+		stripe.PaymentMethodCardBrandDiscover:   "Discover",
+		stripe.PaymentMethodCardBrandJCB:        "JCB",
+		stripe.PaymentMethodCardBrandMastercard: "Mastercard",
+		stripe.PaymentMethodCardBrandUnionpay:   "UnionPay",
+		stripe.PaymentMethodCardBrandVisa:       "Visa",
+	}
+)
 
-	if !respCode2xx(resp.StatusCode) {
-		return pm, s.Error(resp)
+func postPaymentMethod(s *Stripe, uri string, params map[string]interface{}) (*PaymentMethod, error) {
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{},
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&pm.PaymentMethod)
+	err := s.post(uri, params, pm)
 	return pm, err
 }
 
@@ -63,24 +100,87 @@ func RetrievePaymentMethod(s *Stripe, id string) (*PaymentMethod, error) {
 		return pm, s.Error(resp)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&pm)
+	err = s.decode(resp.Body, pm)
 	return pm, err
 }
 
+// PaymentMethodFromIntent will get the PaymentMethod of the SetupIntent or
+// PaymentIntent of the given ID from Stripe, and return it. Whether the ID is
+// for a SetupIntent or PaymentIntent is determined by its prefix, if it is for
+// neither then ErrUnknownIntent is returned. If the intent does not have a
+// PaymentMethod then ErrNoPaymentMethod is returned. This would typically be
+// used once a SetupIntent or Checkout Session has completed, so the returned
+// PaymentMethod can be put in the store,
+//
+//     pm, err := stripeutil.PaymentMethodFromIntent(stripe, "seti_123456")
+//
+//     if err != nil {
+//         // Handle error.
+//     }
+//
+//     if err := stripe.Put(pm); err != nil {
+//         // Handle error.
+//     }
+func PaymentMethodFromIntent(s *Stripe, intentID string) (*PaymentMethod, error) {
+	var pm *stripe.PaymentMethod
+
+	switch {
+	case strings.HasPrefix(intentID, "seti_"):
+		si, err := RetrieveSetupIntent(s, intentID)
+
+		if err != nil {
+			return nil, err
+		}
+		pm = si.PaymentMethod
+	case strings.HasPrefix(intentID, "pi_"):
+		pi, err := RetrievePaymentIntent(s, intentID)
+
+		if err != nil {
+			return nil, err
+		}
+		pm = pi.PaymentMethod
+	default:
+		return nil, ErrUnknownIntent
+	}
+
+	if pm == nil || pm.ID == "" {
+		return nil, ErrNoPaymentMethod
+	}
+	return RetrievePaymentMethod(s, pm.ID)
+}
+
 // Update will update the current PaymentMethod in Stripe with the given Params.
+// The current PaymentMethod is replaced with the updated PaymentMethod returned
+// from Stripe.
 func (pm *PaymentMethod) Update(s *Stripe, params Params) error {
-	var err error
+	return s.post(pm.Endpoint(), params, pm)
+}
 
-	pm, err = postPaymentMethod(s, pm.Endpoint(), params)
-	return err
+// UpdateBillingDetails will update the billing_details of the current
+// PaymentMethod in Stripe with the given details, and put the updated
+// PaymentMethod in the underlying data store. This would typically be used
+// when the billing address of a Customer changes, for example,
+//
+//     err := pm.UpdateBillingDetails(stripe, stripeutil.Params{
+//         "address": stripeutil.Params{
+//             "line1":       "1 Main Street",
+//             "city":        "London",
+//             "postal_code": "SW1A 1AA",
+//             "country":     "GB",
+//         },
+//     })
+func (pm *PaymentMethod) UpdateBillingDetails(s *Stripe, details Params) error {
+	if err := pm.Update(s, Params{"billing_details": details}); err != nil {
+		return err
+	}
+	return s.Put(pm)
 }
 
-// Attach will attach the current PaymentMethod to the given Customer.
+// Attach will attach the current PaymentMethod to the given Customer. The
+// current PaymentMethod is replaced with the attached PaymentMethod returned
+// from Stripe.
 func (pm *PaymentMethod) Attach(s *Stripe, c *Customer) error {
-	var err error
-
-	pm, err = postPaymentMethod(s, pm.Endpoint("attach"), Params{"customer": c.ID})
-	return err
+	return s.post(pm.Endpoint("attach"), Params{"customer": c.ID}, pm)
 }
 
 // Detach will detach the current PaymentMethod from the Customer it was
@@ -90,6 +190,118 @@ func (pm *PaymentMethod) Detach(s *Stripe) error {
 	return err
 }
 
+// delayedNotification returns whether or not payments made with the
+// current PaymentMethod are confirmed asynchronously, such as with SEPA or
+// BACS Direct Debit. The PaymentIntent for a payment made with such a
+// PaymentMethod will have the status "processing" until the payment either
+// succeeds or fails, which can take several days.
+func (pm *PaymentMethod) delayedNotification() bool {
+	if pm == nil || pm.PaymentMethod == nil {
+		return false
+	}
+
+	_, ok := delayedPaymentMethodTypes[pm.Type]
+	return ok
+}
+
+// Display returns a human readable description of the PaymentMethod, suitable
+// for showing to a Customer. For cards this will be the brand, last 4 digits,
+// and expiry date of the card, for example "Visa •••• 4242 (exp 12/24)". For
+// the other types of PaymentMethod the description is built from the type
+// specific details of the PaymentMethod, such as the last 4 digits of the
+// account for SEPA Direct Debit, or the bank for iDEAL. If there are no
+// details for the PaymentMethod then the type of the PaymentMethod is returned.
+func (pm *PaymentMethod) Display() string {
+	if pm == nil || pm.PaymentMethod == nil {
+		return ""
+	}
+
+	switch pm.Type {
+	case stripe.PaymentMethodTypeAUBECSDebit:
+		if pm.AUBECSDebit != nil {
+			return "BECS Direct Debit •••• " + pm.AUBECSDebit.Last4
+		}
+	case stripe.PaymentMethodTypeBACSDebit:
+		if pm.BACSDebit != nil {
+			return "Bacs Direct Debit •••• " + pm.BACSDebit.Last4
+		}
+	case stripe.PaymentMethodTypeCard:
+		if pm.Card != nil {
+			brand, ok := cardBrandNames[pm.Card.Brand]
+
+			if !ok {
+				brand = "Card"
+			}
+			return fmt.Sprintf("%s •••• %s (exp %02d/%02d)", brand, pm.Card.Last4, pm.Card.ExpMonth, pm.Card.ExpYear%100)
+		}
+	case stripe.PaymentMethodTypeFPX:
+		if pm.FPX != nil {
+			return "FPX (" + string(pm.FPX.Bank) + ")"
+		}
+	case stripe.PaymentMethodTypeIdeal:
+		if pm.Ideal != nil {
+			return "iDEAL (" + pm.Ideal.Bank + ")"
+		}
+	case stripe.PaymentMethodTypeP24:
+		if pm.P24 != nil {
+			return "Przelewy24 (" + pm.P24.Bank + ")"
+		}
+	case stripe.PaymentMethodTypeSepaDebit:
+		if pm.SepaDebit != nil {
+			return "SEPA Direct Debit •••• " + pm.SepaDebit.Last4
+		}
+	case PaymentMethodTypeUSBankAccount:
+		if pm.USBankAccount != nil {
+			return pm.USBankAccount.BankName + " •••• " + pm.USBankAccount.Last4
+		}
+	}
+	return string(pm.Type)
+}
+
+// copy returns a copy of the current PaymentMethod, along with a copy of the
+// embedded stripe.PaymentMethod.
+func (pm *PaymentMethod) copy() *PaymentMethod {
+	cp := *pm
+
+	if pm.PaymentMethod != nil {
+		p := *pm.PaymentMethod
+		cp.PaymentMethod = &p
+	}
+	return &cp
+}
+
+// MarshalJSON encodes the PaymentMethod to JSON, adding whether it is the
+// default PaymentMethod of its Customer under the "default" key. The details of
+// an ACH Direct Debit PaymentMethod are added under the "us_bank_account" key,
+// since stripe-go does not encode them.
+func (pm *PaymentMethod) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{
+		"default": pm.Default,
+	}
+
+	if pm.USBankAccount != nil {
+		fields["us_bank_account"] = pm.USBankAccount
+	}
+	return marshalFields(pm.PaymentMethod, fields)
+}
+
+// UnmarshalJSON decodes the given JSON into the PaymentMethod. Stripe returns
+// the details of an ACH Direct Debit PaymentMethod under the "us_bank_account"
+// key, which stripe-go ignores, so these are decoded into USBankAccount. The
+// "default" key added by MarshalJSON is decoded into Default.
+func (pm *PaymentMethod) UnmarshalJSON(b []byte) error {
+	if pm.PaymentMethod == nil {
+		pm.PaymentMethod = &stripe.PaymentMethod{}
+	}
+	return unmarshalFields(b, pm.PaymentMethod, map[string]interface{}{
+		"default":         &pm.Default,
+		"us_bank_account": &pm.USBankAccount,
+	})
+}
+
+// Kind implements the Resource interface.
+func (pm *PaymentMethod) Kind() string { return "payment_method" }
+
 // Endpoint implements the Resource interface.
 func (pm *PaymentMethod) Endpoint(uris ...string) string {
 	endpoint := paymentMethodEndpoint
@@ -104,18 +316,18 @@ func (pm *PaymentMethod) Endpoint(uris ...string) string {
 	return endpoint + strings.Join(uris, "/")
 }
 
-// Load implements the Resource interface.
-func (pm *PaymentMethod) Load(s *Stripe) error {
-	resp, err := s.Client.Get(pm.Endpoint())
+// Raw returns the raw JSON of the PaymentMethod. This is the JSON of the
+// response the PaymentMethod was last decoded from, or the JSON kept by the
+// store the PaymentMethod was retrieved from.
+func (pm *PaymentMethod) Raw() (json.RawMessage, error) { return rawJSON(pm.raw, pm.PaymentMethod) }
 
-	if err != nil {
-		return err
-	}
+func (pm *PaymentMethod) setRaw(b json.RawMessage) { pm.raw = b }
 
-	defer resp.Body.Close()
+// Load implements the Resource interface.
+func (pm *PaymentMethod) Load(s *Stripe) error { return pm.LoadExpanded(s) }
 
-	if !respCode2xx(resp.StatusCode) {
-		return s.Error(resp)
-	}
-	return json.NewDecoder(resp.Body).Decode(&pm.PaymentMethod)
+// LoadExpanded will load in the PaymentMethod from the Stripe API, expanding
+// the objects at the given paths in the response.
+func (pm *PaymentMethod) LoadExpanded(s *Stripe, expand ...string) error {
+	return s.get(pm.Endpoint(), pm, expand...)
 }
@@ -0,0 +1,251 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_PaymentMethodFromIntent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/setup_intents/seti_123456"):
+			w.Write([]byte(`{"id": "seti_123456", "payment_method": "pm_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_intents/pi_123456"):
+			w.Write([]byte(`{"id": "pi_123456", "payment_method": "pm_654321"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_intents/pi_654321"):
+			w.Write([]byte(`{"id": "pi_654321", "payment_method": null}`))
+		case strings.Contains(r.URL.Path, "/v1/payment_methods/"):
+			id := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			w.Write([]byte(`{"id": "` + id + `", "type": "card"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	tests := []struct {
+		intentID string
+		pmID     string
+		err      error
+	}{
+		{"seti_123456", "pm_123456", nil},
+		{"pi_123456", "pm_654321", nil},
+		{"pi_654321", "", ErrNoPaymentMethod},
+		{"cs_123456", "", ErrUnknownIntent},
+	}
+
+	for i, test := range tests {
+		pm, err := PaymentMethodFromIntent(s, test.intentID)
+
+		if err != test.err {
+			t.Fatalf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.err, err)
+		}
+
+		if test.err != nil {
+			continue
+		}
+
+		if pm.ID != test.pmID {
+			t.Errorf("tests[%d] - unexpected payment method, expected=%q, got=%q\n", i, test.pmID, pm.ID)
+		}
+	}
+}
+
+func Test_PaymentMethodDisplay(t *testing.T) {
+	tests := []struct {
+		pm       *stripe.PaymentMethod
+		expected string
+	}{
+		{
+			&stripe.PaymentMethod{
+				Type: stripe.PaymentMethodTypeCard,
+				Card: &stripe.PaymentMethodCard{
+					Brand:    stripe.PaymentMethodCardBrandVisa,
+					Last4:    "4242",
+					ExpMonth: 12,
+					ExpYear:  2024,
+				},
+			},
+			"Visa •••• 4242 (exp 12/24)",
+		},
+		{
+			&stripe.PaymentMethod{
+				Type: stripe.PaymentMethodTypeCard,
+				Card: &stripe.PaymentMethodCard{
+					Brand:    stripe.PaymentMethodCardBrandUnknown,
+					Last4:    "0005",
+					ExpMonth: 3,
+					ExpYear:  2030,
+				},
+			},
+			"Card •••• 0005 (exp 03/30)",
+		},
+		{
+			&stripe.PaymentMethod{
+				Type:      stripe.PaymentMethodTypeSepaDebit,
+				SepaDebit: &stripe.PaymentMethodSepaDebit{Last4: "3000"},
+			},
+			"SEPA Direct Debit •••• 3000",
+		},
+		{
+			&stripe.PaymentMethod{
+				Type:      stripe.PaymentMethodTypeBACSDebit,
+				BACSDebit: &stripe.PaymentMethodBACSDebit{Last4: "2345"},
+			},
+			"Bacs Direct Debit •••• 2345",
+		},
+		{
+			&stripe.PaymentMethod{
+				Type:  stripe.PaymentMethodTypeIdeal,
+				Ideal: &stripe.PaymentMethodIdeal{Bank: "ing"},
+			},
+			"iDEAL (ing)",
+		},
+		{
+			&stripe.PaymentMethod{Type: stripe.PaymentMethodTypeCard},
+			"card",
+		},
+		{
+			&stripe.PaymentMethod{Type: stripe.PaymentMethodTypeOXXO},
+			"oxxo",
+		},
+	}
+
+	for i, test := range tests {
+		pm := &PaymentMethod{PaymentMethod: test.pm}
+
+		if display := pm.Display(); display != test.expected {
+			t.Errorf("tests[%d] - unexpected display, expected=%q, got=%q\n", i, test.expected, display)
+		}
+	}
+}
+
+func Test_PaymentMethodUpdateBillingDetails(t *testing.T) {
+	var postalCode string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456") {
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+		postalCode = r.PostForm.Get("billing_details[address][postal_code]")
+
+		w.Write([]byte(`{
+			"id": "pm_123456",
+			"type": "card",
+			"customer": "cus_123456",
+			"billing_details": {"address": {"postal_code": "` + postalCode + `"}}
+		}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Type: stripe.PaymentMethodTypeCard},
+		Default:       true,
+	}
+
+	err := pm.UpdateBillingDetails(s, Params{
+		"address": Params{
+			"postal_code": "SW1A 1AA",
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if postalCode != "SW1A 1AA" {
+		t.Fatalf("unexpected postal code, expected=%q, got=%q\n", "SW1A 1AA", postalCode)
+	}
+
+	if pm.BillingDetails == nil || pm.BillingDetails.Address == nil || pm.BillingDetails.Address.PostalCode != "SW1A 1AA" {
+		t.Fatalf("expected payment method to be updated\n")
+	}
+
+	pm, ok, err := store.DefaultPaymentMethod(&Customer{Customer: &stripe.Customer{ID: "cus_123456"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected payment method to be stored")
+	}
+
+	if pm.BillingDetails == nil || pm.BillingDetails.Address == nil || pm.BillingDetails.Address.PostalCode != "SW1A 1AA" {
+		t.Fatalf("expected stored payment method to be updated\n")
+	}
+}
+
+func Test_PaymentMethodUSBankAccount(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "pm_123456",
+			"type": "us_bank_account",
+			"customer": "cus_123456",
+			"us_bank_account": {
+				"account_type": "checking",
+				"bank_name": "STRIPE TEST BANK",
+				"last4": "6789",
+				"routing_number": "110000000"
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	retrieved, err := RetrievePaymentMethod(s, "pm_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456"},
+	}
+
+	if err := loaded.Load(s); err != nil {
+		t.Fatal(err)
+	}
+
+	attached := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456"},
+	}
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	if err := attached.Attach(s, c); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, pm := range []*PaymentMethod{retrieved, loaded, attached} {
+		if pm.USBankAccount == nil || pm.USBankAccount.Last4 != "6789" {
+			t.Fatalf("pms[%d] - expected us_bank_account to be decoded\n", i)
+		}
+
+		if display := pm.Display(); display != "STRIPE TEST BANK •••• 6789" {
+			t.Fatalf("pms[%d] - unexpected display, expected=%q, got=%q\n", i, "STRIPE TEST BANK •••• 6789", display)
+		}
+	}
+}
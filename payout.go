@@ -0,0 +1,88 @@
+package stripeutil
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Payout is the Payout resource from Stripe. Embedded in this struct is the
+// stripe.Payout struct from Stripe.
+type Payout struct {
+	*stripe.Payout
+}
+
+var (
+	_ Resource = (*Payout)(nil)
+
+	payoutEndpoint = "/v1/payouts"
+)
+
+// RetrievePayout will get the Payout of the given ID from Stripe and return
+// it.
+func RetrievePayout(s *Stripe, id string) (*Payout, error) {
+	p := &Payout{
+		Payout: &stripe.Payout{
+			ID: id,
+		},
+	}
+
+	err := p.Load(s)
+	return p, err
+}
+
+// ListPayouts returns all of the payouts from Stripe that match the given
+// Params. The Params are passed through as the query string of the request.
+func ListPayouts(s *Stripe, params Params) ([]*Payout, error) {
+	uri := payoutEndpoint
+
+	if len(params) > 0 {
+		uri += "?" + params.Encode()
+	}
+
+	payouts := make([]*Payout, 0)
+
+	err := s.list(uri, func(raw json.RawMessage) error {
+		p := &Payout{
+			Payout: &stripe.Payout{},
+		}
+
+		if err := s.unmarshal(raw, p.Payout); err != nil {
+			return err
+		}
+		payouts = append(payouts, p)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// BalanceTransactions returns all of the balance transactions that were paid
+// out in the current Payout.
+func (p *Payout) BalanceTransactions(s *Stripe) ([]*stripe.BalanceTransaction, error) {
+	return ListBalanceTransactions(s, Params{"payout": p.ID})
+}
+
+// Kind implements the Resource interface.
+func (p *Payout) Kind() string { return "payout" }
+
+// Endpoint implements the Resource interface.
+func (p *Payout) Endpoint(uris ...string) string {
+	endpoint := payoutEndpoint
+
+	if p.ID != "" {
+		endpoint += "/" + p.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Load implements the Resource interface.
+func (p *Payout) Load(s *Stripe) error { return s.get(p.Endpoint(), &p.Payout) }
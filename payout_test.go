@@ -0,0 +1,86 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_Payout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/payouts/po_123456"):
+			w.Write([]byte(`{"id": "po_123456", "amount": 2853, "status": "paid"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payouts"):
+			if status := q.Get("status"); status != "paid" {
+				t.Errorf("unexpected status, expected=%q, got=%q\n", "paid", status)
+			}
+			w.Write([]byte(`{"has_more": false, "data": [{"id": "po_123456", "amount": 2853, "status": "paid"}, {"id": "po_654321", "amount": 1000, "status": "paid"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/balance_transactions"):
+			if payout := q.Get("payout"); payout != "po_123456" {
+				t.Errorf("unexpected payout, expected=%q, got=%q\n", "po_123456", payout)
+			}
+			w.Write([]byte(`{"has_more": false, "data": [{"id": "txn_123456", "net": 941}, {"id": "txn_654321", "net": 1912}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	p, err := RetrievePayout(s, "po_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Amount != 2853 || p.Status != stripe.PayoutStatusPaid {
+		t.Fatalf("unexpected payout, expected=%d (%q), got=%d (%q)\n", 2853, stripe.PayoutStatusPaid, p.Amount, p.Status)
+	}
+
+	txns, err := p.BalanceTransactions(s)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var net int64
+
+	for _, txn := range txns {
+		net += txn.Net
+	}
+
+	if net != p.Amount {
+		t.Fatalf("unexpected net, expected=%d, got=%d\n", p.Amount, net)
+	}
+
+	payouts, err := ListPayouts(s, Params{"status": "paid"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"po_123456", "po_654321"}
+
+	if len(payouts) != len(expected) {
+		t.Fatalf("unexpected payouts, expected=%d, got=%d\n", len(expected), len(payouts))
+	}
+
+	for i, p := range payouts {
+		if p.ID != expected[i] {
+			t.Errorf("payouts[%d] - unexpected payout, expected=%q, got=%q\n", i, expected[i], p.ID)
+		}
+	}
+
+	if _, err := RetrievePayout(s, "po_abcdef"); err == nil {
+		t.Fatal("expected error retrieving unknown payout")
+	}
+}
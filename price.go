@@ -0,0 +1,216 @@
+package stripeutil
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Prices provides a way of storing the prices configured in Stripe. You would
+// typically use this if you are storing your price IDs in a file on disk, and
+// want them loaded up at start time of your application.
+type Prices struct {
+	mu     sync.RWMutex
+	ids    []string
+	prices map[string]*Price
+}
+
+// Price is the Price resource from Stripe. Embedded in this struct is the
+// stripe.Price struct from Stripe.
+type Price struct {
+	*stripe.Price
+
+	TaxBehavior string // TaxBehavior is whether the Price is inclusive or exclusive of tax.
+}
+
+var (
+	_ Resource = (*Price)(nil)
+
+	priceEndpoint = "/v1/prices"
+
+	// ErrUnknownPrice denotes when a price cannot be found in the set of
+	// prices.
+	ErrUnknownPrice = errors.New("unknown price")
+
+	// ErrMixedTaxBehavior denotes when a set of prices contains prices that
+	// are both inclusive and exclusive of tax.
+	ErrMixedTaxBehavior = errors.New("mixed tax behavior")
+
+	// ErrTieredPrice denotes when a price is tiered, so its amount cannot be
+	// known without the usage it is billed on.
+	ErrTieredPrice = errors.New("tiered price")
+)
+
+// LoadPrices will load in all of the price IDs from the given io.Reader. It is
+// expected for each price ID to be on its own separate line. Comments (lines
+// prefixed with #) are ignored. The given errh function is used for handling
+// any errors that arise when calling out to Stripe. Each price is loaded with
+// its tiers expanded, so the tiers of tiered prices will be available.
+func LoadPrices(r io.Reader, s *Stripe, errh func(error)) (*Prices, error) {
+	p := &Prices{
+		mu:     sync.RWMutex{},
+		ids:    make([]string, 0),
+		prices: make(map[string]*Price),
+	}
+
+	if err := p.Reload(r, s, errh); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload loads in new price IDs from the given io.Reader. This will return an
+// error if there is any issue with reading from the given io.Reader. Any errors
+// that occur when loading in the prices via Stripe will be handled via the
+// given errh callback. This will only load in the new prices that are found.
+// If the loaded prices are a mix of tax inclusive and tax exclusive prices,
+// then ErrMixedTaxBehavior is passed to the errh callback.
+func (p *Prices) Reload(r io.Reader, s *Stripe, errh func(error)) error {
+	rs := make([]Resource, 0)
+
+	err := scanlines(r, func(id string) error {
+		rs = append(rs, &Price{
+			Price: &stripe.Price{
+				ID: id,
+			},
+		})
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	rs = loadResources(s, rs, errh)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, r := range rs {
+		pr := r.(*Price)
+
+		if _, ok := p.prices[pr.ID]; !ok {
+			p.ids = append(p.ids, pr.ID)
+			p.prices[pr.ID] = pr
+		}
+	}
+
+	behaviors := make(map[string]struct{})
+
+	for _, pr := range p.prices {
+		if pr.TaxBehavior == "inclusive" || pr.TaxBehavior == "exclusive" {
+			behaviors[pr.TaxBehavior] = struct{}{}
+		}
+	}
+
+	if len(behaviors) > 1 {
+		errh(ErrMixedTaxBehavior)
+	}
+	return nil
+}
+
+// Get returns the price for the given ID, if it exists in the underlying
+// store.
+func (p *Prices) Get(id string) (*Price, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pr, ok := p.prices[id]
+
+	if !ok {
+		return nil, ErrUnknownPrice
+	}
+	return pr, nil
+}
+
+// Slice returns all of the prices in the order in which they were loaded.
+func (p *Prices) Slice() []*Price {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	prices := make([]*Price, 0, len(p.ids))
+
+	for _, id := range p.ids {
+		prices = append(prices, p.prices[id])
+	}
+	return prices
+}
+
+// Filter returns all of the prices with the given currency and recurring
+// interval, in the order in which they were loaded. An empty currency or
+// interval will match any price. Prices that are not recurring do not have an
+// interval, so will only be returned if the given interval is empty.
+func (p *Prices) Filter(currency, interval string) []*Price {
+	prices := make([]*Price, 0)
+
+	for _, pr := range p.Slice() {
+		if currency != "" && !strings.EqualFold(string(pr.Currency), currency) {
+			continue
+		}
+
+		if interval != "" {
+			if pr.Recurring == nil || string(pr.Recurring.Interval) != interval {
+				continue
+			}
+		}
+		prices = append(prices, pr)
+	}
+	return prices
+}
+
+// UnmarshalJSON decodes the given JSON into the current Price. This is
+// implemented so the tax_behavior of the Price is decoded, since this is not
+// available in the stripe.Price struct.
+func (pr *Price) UnmarshalJSON(b []byte) error {
+	var tax struct {
+		TaxBehavior string `json:"tax_behavior"`
+	}
+
+	if err := json.Unmarshal(b, &tax); err != nil {
+		return err
+	}
+
+	if pr.Price == nil {
+		pr.Price = &stripe.Price{}
+	}
+
+	if err := json.Unmarshal(b, pr.Price); err != nil {
+		return err
+	}
+
+	pr.TaxBehavior = tax.TaxBehavior
+	return nil
+}
+
+// TaxInclusive returns whether or not the Price is inclusive of tax.
+func (pr *Price) TaxInclusive() bool { return pr.TaxBehavior == "inclusive" }
+
+// Kind implements the Resource interface.
+func (pr *Price) Kind() string { return "price" }
+
+// Endpoint implements the Resource interface.
+func (pr *Price) Endpoint(uris ...string) string {
+	endpoint := priceEndpoint
+
+	if pr.ID != "" {
+		endpoint += "/" + pr.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Tiers returns the tiers of the Price if the Price uses tiered pricing. The
+// tiers of a Price are not returned by Stripe by default, so this requires the
+// Price to have been loaded via Load.
+func (pr *Price) Tiers() []*stripe.PriceTier { return pr.Price.Tiers }
+
+// Load implements the Resource interface. This will expand the tiers of the
+// Price.
+func (pr *Price) Load(s *Stripe) error { return s.get(pr.Endpoint(), pr, "tiers") }
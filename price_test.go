@@ -0,0 +1,164 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newPriceServer(prices map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := prices[path.Base(r.URL.Path)]
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such price"}}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func Test_LoadPrices(t *testing.T) {
+	srv := newPriceServer(map[string]string{
+		"price_inclusive": `{"id": "price_inclusive", "currency": "gbp", "tax_behavior": "inclusive"}`,
+		"price_exclusive": `{"id": "price_exclusive", "currency": "gbp", "tax_behavior": "exclusive"}`,
+	})
+	defer srv.Close()
+
+	stripe := New("sk_test_123456", newTestStore())
+	stripe.endpoint = srv.URL
+
+	errs := make([]error, 0)
+
+	prices, err := LoadPrices(strings.NewReader("price_inclusive\nprice_exclusive\nprice_missing\n"), stripe, func(err error) {
+		errs = append(errs, err)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prices.Slice()) != 2 {
+		t.Fatalf("unexpected prices, expected=%d, got=%d\n", 2, len(prices.Slice()))
+	}
+
+	pr, err := prices.Get("price_inclusive")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pr.TaxInclusive() {
+		t.Errorf("expected price %q to be tax inclusive, it was not\n", pr.ID)
+	}
+
+	if len(errs) != 2 {
+		t.Fatalf("unexpected errors, expected=%d, got=%d\n", 2, len(errs))
+	}
+
+	if errs[1] != ErrMixedTaxBehavior {
+		t.Errorf("unexpected error, expected=%q, got=%q\n", ErrMixedTaxBehavior, errs[1])
+	}
+}
+
+func Test_PricesFilter(t *testing.T) {
+	srv := newPriceServer(map[string]string{
+		"price_gbp_month": `{"id": "price_gbp_month", "currency": "gbp", "recurring": {"interval": "month"}}`,
+		"price_gbp_year":  `{"id": "price_gbp_year", "currency": "gbp", "recurring": {"interval": "year"}}`,
+		"price_usd_month": `{"id": "price_usd_month", "currency": "usd", "recurring": {"interval": "month"}}`,
+		"price_gbp_once":  `{"id": "price_gbp_once", "currency": "gbp"}`,
+	})
+	defer srv.Close()
+
+	stripe := New("sk_test_123456", newTestStore())
+	stripe.endpoint = srv.URL
+
+	ids := "price_gbp_month\nprice_gbp_year\nprice_usd_month\nprice_gbp_once\n"
+
+	prices, err := LoadPrices(strings.NewReader(ids), stripe, func(err error) {
+		t.Errorf("failed to load price: %s\n", err)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		currency string
+		interval string
+		expected []string
+	}{
+		{"", "", []string{"price_gbp_month", "price_gbp_year", "price_usd_month", "price_gbp_once"}},
+		{"gbp", "", []string{"price_gbp_month", "price_gbp_year", "price_gbp_once"}},
+		{"", "month", []string{"price_gbp_month", "price_usd_month"}},
+		{"gbp", "year", []string{"price_gbp_year"}},
+		{"eur", "", []string{}},
+	}
+
+	for i, test := range tests {
+		filtered := prices.Filter(test.currency, test.interval)
+
+		if len(filtered) != len(test.expected) {
+			t.Errorf("tests[%d] - unexpected prices, expected=%d, got=%d\n", i, len(test.expected), len(filtered))
+			continue
+		}
+
+		for j, pr := range filtered {
+			if pr.ID != test.expected[j] {
+				t.Errorf("tests[%d] - unexpected price, expected=%q, got=%q\n", i, test.expected[j], pr.ID)
+			}
+		}
+	}
+}
+
+func Test_PriceTiers(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		expand string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		expand = r.URL.Query().Get("expand[]")
+		mu.Unlock()
+
+		switch path.Base(r.URL.Path) {
+		case "price_tiered":
+			w.Write([]byte(`{"id": "price_tiered", "billing_scheme": "tiered", "tiers": [{"up_to": 10, "unit_amount": 500}, {"up_to": 0, "unit_amount": 400}]}`))
+		default:
+			w.Write([]byte(`{"id": "price_flat", "billing_scheme": "per_unit", "tiers": null}`))
+		}
+	}))
+	defer srv.Close()
+
+	stripe := New("sk_test_123456", newTestStore())
+	stripe.endpoint = srv.URL
+
+	prices, err := LoadPrices(strings.NewReader("price_tiered\nprice_flat\n"), stripe, func(err error) {
+		t.Errorf("failed to load price: %s\n", err)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expand != "tiers" {
+		t.Errorf("unexpected expand, expected=%q, got=%q\n", "tiers", expand)
+	}
+
+	tiered, _ := prices.Get("price_tiered")
+
+	if len(tiered.Tiers()) != 2 {
+		t.Errorf("unexpected tiers, expected=%d, got=%d\n", 2, len(tiered.Tiers()))
+	}
+
+	flat, _ := prices.Get("price_flat")
+
+	if len(flat.Tiers()) != 0 {
+		t.Errorf("unexpected tiers, expected=%d, got=%d\n", 0, len(flat.Tiers()))
+	}
+}
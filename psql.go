@@ -3,6 +3,9 @@ package stripeutil
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/andrewpillar/query"
@@ -19,11 +22,14 @@ import (
 //         id           VARCHAR NOT NULL UNIQUE,
 //         email        VARCHAR NOT NULL UNIQUE,
 //         jurisdiction VARCHAR NULL,
-//         created_at   TIMESTAMP NOT NULL
+//         created_at   TIMESTAMP NOT NULL,
+//         address      JSON NULL,
+//         shipping     JSON NULL
 //     );
 //
 //     CREATE TABLE stripe_events (
-//         id VARCHAR NOT NULL UNIQUE
+//         id      VARCHAR NOT NULL UNIQUE,
+//         payload JSONB NULL
 //     );
 //
 //     CREATE TABLE stripe_invoices (
@@ -46,68 +52,385 @@ import (
 //     );
 //
 //     CREATE TABLE stripe_subscriptions (
-//         id          VARCHAR NOT NULL UNIQUE,
-//         customer_id VARCHAR NOT NULL,
-//         status      VARCHAR NOT NULL,
-//         started_at  TIMESTAMP NOT NULL,
-//         ends_at     TIMESTAMP NULL
+//         id                   VARCHAR NOT NULL UNIQUE,
+//         customer_id          VARCHAR NOT NULL,
+//         status               VARCHAR NOT NULL,
+//         started_at           TIMESTAMP NOT NULL,
+//         ends_at              TIMESTAMP NULL,
+//         cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE
 //     );
+//
+// The resources are frequently queried by the Customer they belong to, and
+// by their status, so the following indexes should also be created,
+//
+//     CREATE INDEX IF NOT EXISTS stripe_invoices_customer_id_idx ON stripe_invoices (customer_id);
+//     CREATE INDEX IF NOT EXISTS stripe_invoices_status_idx ON stripe_invoices (status);
+//     CREATE INDEX IF NOT EXISTS stripe_payment_methods_customer_id_idx ON stripe_payment_methods (customer_id);
+//     CREATE INDEX IF NOT EXISTS stripe_subscriptions_customer_id_idx ON stripe_subscriptions (customer_id);
+//     CREATE INDEX IF NOT EXISTS stripe_subscriptions_status_idx ON stripe_subscriptions (status);
+//
+// This schema can be created by calling Migrate, which will track the version
+// of the schema in the stripe_schema_version table, and only apply the changes
+// that are needed to bring an existing schema up to date.
+//
+// If KeepRaw is set to true then the raw JSON of each resource will also be
+// stored. This would require each of the above tables, bar stripe_events, to
+// have the additional column,
+//
+//     raw JSONB NULL
+//
+// If KeepEvents is set to true then the payload of each event logged via
+// LogEventPayload will be stored in the payload column of stripe_events, so
+// the events can be retrieved via Event for replaying.
+//
+// The Prefix field can be set to prepend a prefix to the name of each of the
+// above tables. This would typically be used to qualify each table with a
+// schema, for example setting the Prefix to "tenant1." would result in the
+// tables tenant1.stripe_customers, tenant1.stripe_events, and so on being used.
+// The Tables field can be set to use entirely different names for the tables.
+//
+// For stores that are queried frequently, CacheStatements can be called to
+// have the queries for looking up resources prepared once, and reused. This
+// must be called before the store is given to New.
 type PSQL struct {
 	*sql.DB
+
+	KeepRaw    bool   // KeepRaw is whether or not to store the raw JSON of each resource.
+	KeepEvents bool   // KeepEvents is whether or not to store the payload of each event.
+	Prefix     string // Prefix is prepended to the name of each table, such as a schema.
+
+	// Tables maps the default name of a table, such as stripe_customers, to
+	// the name that should be used instead. Tables that are not in the map
+	// use their default name with the Prefix prepended.
+	Tables map[string]string
+
+	stmts *stmtCache
 }
 
-var (
-	_ Store = (*PSQL)(nil)
+type rawResource interface {
+	Raw() (json.RawMessage, error)
+}
 
+// The default names of the tables used by the PSQL store.
+const (
 	customerTable      = "stripe_customers"
 	eventTable         = "stripe_events"
 	invoiceTable       = "stripe_invoices"
 	paymentMethodTable = "stripe_payment_methods"
 	subscriptionTable  = "stripe_subscriptions"
+	schemaVersionTable = "stripe_schema_version"
+)
+
+var (
+	_ Store      = (*PSQL)(nil)
+	_ EventStore = (*PSQL)(nil)
+
+	// ErrUnsupportedPaymentMethod is returned when a PaymentMethod is put in
+	// the PSQL store that is of a type whose details cannot be found. This
+	// happens for types unknown to stripe-go when the PaymentMethod does not
+	// have its raw JSON. See SupportedPaymentMethodTypes.
+	ErrUnsupportedPaymentMethod = errors.New("unsupported payment method type")
+
+	// paymentMethodTypes are the types of PaymentMethod whose details are
+	// stored in a known format in the info column of the
+	// stripe_payment_methods table.
+	paymentMethodTypes = []stripe.PaymentMethodType{
+		stripe.PaymentMethodTypeAUBECSDebit,
+		stripe.PaymentMethodTypeBACSDebit,
+		stripe.PaymentMethodTypeCard,
+		stripe.PaymentMethodTypeFPX,
+		stripe.PaymentMethodTypeIdeal,
+		stripe.PaymentMethodTypeP24,
+		stripe.PaymentMethodTypeSepaDebit,
+		PaymentMethodTypeUSBankAccount,
+	}
+
+	// kindTables maps the kind of each Resource to the table it is stored in.
+	kindTables = map[string]string{
+		"customer":       customerTable,
+		"invoice":        invoiceTable,
+		"payment_method": paymentMethodTable,
+		"subscription":   subscriptionTable,
+	}
 )
 
-func getPaymentMethodInfo(pm *PaymentMethod) map[string]interface{} {
+// table returns the name to use for the table with the given default name. If
+// the table has not been given a different name in Tables, then the default
+// name is returned with the Prefix prepended to it.
+func (p PSQL) table(name string) string {
+	if table, ok := p.Tables[name]; ok {
+		return table
+	}
+	return p.Prefix + name
+}
+
+// columns returns the given columns to select. If the raw JSON of resources is
+// being kept, then the raw column is appended.
+func (p PSQL) columns(cols ...string) []string {
+	if p.KeepRaw {
+		cols = append(cols, "raw")
+	}
+	return cols
+}
+
+// scanDest returns the given destinations for scanning a row into. If the raw
+// JSON of resources is being kept, then the given raw destination is appended.
+func (p PSQL) scanDest(raw *[]byte, dest ...interface{}) []interface{} {
+	if p.KeepRaw {
+		dest = append(dest, raw)
+	}
+	return dest
+}
+
+// insertRaw appends the raw column and the raw JSON of the given resource to
+// the given columns and values, if the raw JSON of resources is being kept.
+func (p PSQL) insertRaw(r rawResource, cols []string, vals []interface{}) ([]string, []interface{}, error) {
+	if !p.KeepRaw {
+		return cols, vals, nil
+	}
+
+	raw, err := r.Raw()
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(cols, "raw"), append(vals, []byte(raw)), nil
+}
+
+// updateRaw appends a query.Set option for the raw JSON of the given resource
+// to the given options, if the raw JSON of resources is being kept.
+func (p PSQL) updateRaw(r rawResource, opts []query.Option) ([]query.Option, error) {
+	if !p.KeepRaw {
+		return opts, nil
+	}
+
+	raw, err := r.Raw()
+
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, query.Set("raw", query.Arg([]byte(raw)))), nil
+}
+
+// SupportedPaymentMethodTypes returns the types of PaymentMethod whose details
+// are stored in a known format by the PSQL store. The details of any other
+// type of PaymentMethod are stored as they are in the JSON of the
+// PaymentMethod. If there are no details for the type in the JSON, which can
+// happen for types unknown to stripe-go, then ErrUnsupportedPaymentMethod is
+// returned.
+func SupportedPaymentMethodTypes() []string {
+	types := make([]string, 0, len(paymentMethodTypes))
+
+	for _, typ := range paymentMethodTypes {
+		types = append(types, string(typ))
+	}
+	return types
+}
+
+// insertColumns returns the table, columns, and values for inserting the given
+// Resource. If the Resource cannot be stored then ErrUnknownResource is
+// returned.
+func (p PSQL) insertColumns(r Resource) (string, []string, []interface{}, error) {
+	var (
+		table string
+		cols  []string
+		vals  []interface{}
+	)
+
+	switch v := r.(type) {
+	case *Customer:
+		address, shipping, err := customerAddress(v)
+
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		table = customerTable
+		cols = []string{"id", "email", "jurisdiction", "created_at", "address", "shipping"}
+		vals = []interface{}{v.ID, v.Email, v.Jurisdiction, time.Unix(v.Created, 0), address, shipping}
+	case *Invoice:
+		created := time.Unix(v.Created, 0)
+
+		table = invoiceTable
+		cols = []string{"id", "customer_id", "number", "amount", "status", "created_at", "updated_at"}
+		vals = []interface{}{v.ID, v.Customer.ID, v.Number, v.AmountDue, v.Status, created, created}
+	case *PaymentMethod:
+		info, err := paymentMethodInfoColumn(v)
+
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		table = paymentMethodTable
+		cols = []string{"id", "customer_id", "type", "info", "is_default", "created_at"}
+		vals = []interface{}{v.ID, v.Customer.ID, v.Type, info, v.Default, time.Unix(v.Created, 0)}
+	case *Subscription:
+		table = subscriptionTable
+		cols = []string{"id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end"}
+		vals = []interface{}{v.ID, v.Customer.ID, v.Status, time.Unix(v.StartDate, 0), v.EndsAt, v.CancelAtPeriodEnd}
+	default:
+		return "", nil, nil, ErrUnknownResource
+	}
+
+	cols, vals, err := p.insertRaw(r.(rawResource), cols, vals)
+
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return p.table(table), cols, vals, nil
+}
+
+// paymentMethodInfoColumn returns the JSON encoded details of the given
+// PaymentMethod to store in the info column.
+func paymentMethodInfoColumn(pm *PaymentMethod) ([]byte, error) {
+	info, err := getPaymentMethodInfo(pm)
+
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(info)
+}
+
+// getPaymentMethodInfo returns the details of the given PaymentMethod to store
+// in the info column. The details of the types of PaymentMethod returned from
+// SupportedPaymentMethodTypes are stored in a known format, the details of any
+// other type are taken from the JSON of the PaymentMethod.
+func getPaymentMethodInfo(pm *PaymentMethod) (map[string]interface{}, error) {
 	switch pm.Type {
 	case "au_becs_debit":
+		if pm.AUBECSDebit == nil {
+			break
+		}
 		return map[string]interface{}{
 			"bsb_number": pm.AUBECSDebit.BSBNumber,
 			"last4":      pm.AUBECSDebit.Last4,
-		}
+		}, nil
 	case "bacs_debit":
+		if pm.BACSDebit == nil {
+			break
+		}
 		return map[string]interface{}{
 			"last4":     pm.BACSDebit.Last4,
 			"sort_code": pm.BACSDebit.SortCode,
-		}
+		}, nil
 	case "card":
+		if pm.Card == nil {
+			break
+		}
 		return map[string]interface{}{
 			"brand":     string(pm.Card.Brand),
 			"exp_month": pm.Card.ExpMonth,
 			"exp_year":  pm.Card.ExpYear,
 			"last4":     pm.Card.Last4,
-		}
+		}, nil
 	case "fpx":
+		if pm.FPX == nil {
+			break
+		}
 		return map[string]interface{}{
 			"bank": pm.FPX.Bank,
-		}
+		}, nil
 	case "ideal":
+		if pm.Ideal == nil {
+			break
+		}
 		return map[string]interface{}{
 			"bank": pm.Ideal.Bank,
 			"bic":  pm.Ideal.Bic,
-		}
+		}, nil
 	case "p24":
+		if pm.P24 == nil {
+			break
+		}
 		return map[string]interface{}{
 			"bank": pm.P24.Bank,
-		}
+		}, nil
 	case "sepa_debit":
+		if pm.SepaDebit == nil {
+			break
+		}
 		return map[string]interface{}{
 			"bank_code":   pm.SepaDebit.BankCode,
 			"branch_code": pm.SepaDebit.BranchCode,
 			"country":     pm.SepaDebit.Country,
 			"last4":       pm.SepaDebit.Last4,
+		}, nil
+	case "us_bank_account":
+		if pm.USBankAccount == nil {
+			break
 		}
-	default:
-		return nil
+		return map[string]interface{}{
+			"account_type":   pm.USBankAccount.AccountType,
+			"bank_name":      pm.USBankAccount.BankName,
+			"last4":          pm.USBankAccount.Last4,
+			"routing_number": pm.USBankAccount.RoutingNumber,
+		}, nil
+	}
+	return paymentMethodTypeInfo(pm)
+}
+
+// customerAddress returns the JSON encoded address and shipping details of the
+// given Customer to store, these will be nil if the Customer has neither.
+func customerAddress(c *Customer) (interface{}, interface{}, error) {
+	var address interface{}
+
+	if c.Address != (stripe.Address{}) {
+		b, err := json.Marshal(c.Address)
+
+		if err != nil {
+			return nil, nil, err
+		}
+		address = b
+	}
+
+	var shipping interface{}
+
+	if c.Shipping != nil {
+		b, err := json.Marshal(c.Shipping)
+
+		if err != nil {
+			return nil, nil, err
+		}
+		shipping = b
 	}
+	return address, shipping, nil
+}
+
+// paymentMethodTypeInfo returns the type specific details of the given
+// PaymentMethod from its JSON, this is the object in the JSON under the key of
+// the PaymentMethod's type. The raw JSON of the PaymentMethod is used, which is
+// kept when the PaymentMethod is decoded from a response from Stripe, so the
+// details of types unknown to stripe-go can be stored. If there are no details
+// for the type then ErrUnsupportedPaymentMethod is returned.
+func paymentMethodTypeInfo(pm *PaymentMethod) (map[string]interface{}, error) {
+	raw, err := pm.Raw()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	b, ok := fields[string(pm.Type)]
+
+	if !ok {
+		return nil, ErrUnsupportedPaymentMethod
+	}
+
+	var info map[string]interface{}
+
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		info = make(map[string]interface{})
+	}
+	return info, nil
 }
 
 func unmarshalPaymentMethodInfo(info []byte, pm *PaymentMethod) error {
@@ -128,16 +451,38 @@ func unmarshalPaymentMethodInfo(info []byte, pm *PaymentMethod) error {
 		err = json.Unmarshal(info, &pm.P24)
 	case "sepa_debit":
 		err = json.Unmarshal(info, &pm.SepaDebit)
+	case "us_bank_account":
+		err = json.Unmarshal(info, &pm.USBankAccount)
+	default:
+		if len(info) == 0 {
+			return nil
+		}
+
+		var b []byte
+
+		b, err = json.Marshal(map[string]json.RawMessage{
+			string(pm.Type): info,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// Decode into the PaymentMethod without stripe-go's UnmarshalJSON,
+		// since that would overwrite the fields that have already been set.
+		type paymentMethod stripe.PaymentMethod
+
+		err = json.Unmarshal(b, (*paymentMethod)(pm.PaymentMethod))
 	}
 	return err
 }
 
 func (p PSQL) getPaymentMethods(opts ...query.Option) ([]*PaymentMethod, error) {
 	opts = append([]query.Option{
-		query.From(paymentMethodTable),
+		query.From(p.table(paymentMethodTable)),
 	}, opts...)
 
-	q := query.Select(query.Columns("*"), opts...)
+	q := query.Select(query.Columns(p.columns("id", "customer_id", "type", "info", "is_default", "created_at")...), opts...)
 
 	rows, err := p.Query(q.Build(), q.Args()...)
 
@@ -155,15 +500,18 @@ func (p PSQL) getPaymentMethods(opts ...query.Option) ([]*PaymentMethod, error)
 
 		var (
 			info    []byte
+			raw     []byte
 			created time.Time
 		)
 
-		if err := rows.Scan(&pm.ID, &pm.Customer.ID, &pm.Type, &info, &pm.Default, &created); err != nil {
+		if err := rows.Scan(p.scanDest(&raw, &pm.ID, &pm.Customer.ID, &pm.Type, &info, &pm.Default, &created)...); err != nil {
 			if err != sql.ErrNoRows {
 				return nil, err
 			}
 		}
 
+		pm.raw = raw
+
 		if err := unmarshalPaymentMethodInfo(info, pm); err != nil {
 			return nil, err
 		}
@@ -177,8 +525,8 @@ func (p PSQL) getPaymentMethods(opts ...query.Option) ([]*PaymentMethod, error)
 // Customer could be found.
 func (p PSQL) LookupCustomer(email string) (*Customer, bool, error) {
 	q := query.Select(
-		query.Columns("*"),
-		query.From(customerTable),
+		query.Columns(p.columns("id", "email", "jurisdiction", "created_at", "address", "shipping")...),
+		query.From(p.table(customerTable)),
 		query.Where("email", "=", query.Arg(email)),
 	)
 
@@ -188,25 +536,43 @@ func (p PSQL) LookupCustomer(email string) (*Customer, bool, error) {
 
 	var (
 		jurisdiction sql.NullString
+		raw          []byte
 		created      time.Time
+		address      []byte
+		shipping     []byte
 	)
 
-	if err := p.QueryRow(q.Build(), q.Args()...).Scan(&c.ID, &c.Email, &jurisdiction, &created); err != nil {
+	dest := p.scanDest(&raw, &c.ID, &c.Email, &jurisdiction, &created, &address, &shipping)
+
+	if err := p.queryRow(q).Scan(dest...); err != nil {
 		if err != sql.ErrNoRows {
 			return nil, false, err
 		}
 		return nil, false, nil
 	}
 
+	if address != nil {
+		if err := json.Unmarshal(address, &c.Address); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if shipping != nil {
+		if err := json.Unmarshal(shipping, &c.Shipping); err != nil {
+			return nil, false, err
+		}
+	}
+
 	c.Jurisdiction = jurisdiction.String
 	c.Created = created.Unix()
+	c.raw = raw
 	return c, true, nil
 }
 
 func (p PSQL) LookupInvoice(c *Customer, number string) (*Invoice, bool, error) {
 	q := query.Select(
-		query.Columns("*"),
-		query.From(invoiceTable),
+		query.Columns(p.columns("id", "customer_id", "number", "amount", "status", "created_at", "updated_at")...),
+		query.From(p.table(invoiceTable)),
 		query.Where("customer_id", "=", query.Arg(c.ID)),
 		query.Where("number", "=", query.Arg(number)),
 	)
@@ -216,11 +582,14 @@ func (p PSQL) LookupInvoice(c *Customer, number string) (*Invoice, bool, error)
 	}
 	i.Customer = &stripe.Customer{}
 
-	var created time.Time
+	var (
+		raw     []byte
+		created time.Time
+	)
 
-	row := p.QueryRow(q.Build(), q.Args()...)
+	row := p.queryRow(q)
 
-	err := row.Scan(&i.ID, &i.Customer.ID, &i.Number, &i.AmountDue, &i.Status, &created, &i.Updated)
+	err := row.Scan(p.scanDest(&raw, &i.ID, &i.Customer.ID, &i.Number, &i.AmountDue, &i.Status, &created, &i.Updated)...)
 
 	if err != nil {
 		if err != sql.ErrNoRows {
@@ -230,13 +599,23 @@ func (p PSQL) LookupInvoice(c *Customer, number string) (*Invoice, bool, error)
 	}
 
 	i.Created = created.Unix()
+	i.raw = raw
 	return i, true, nil
 }
 
-func (p PSQL) LogEvent(id string) error {
+func (p PSQL) LogEvent(id string) error { return p.logEvent(id, nil) }
+
+// LogEventPayload will store the given event ID, along with the given payload
+// of the event if KeepEvents is true. If the given event ID already exists in
+// the stripe_events table then ErrEventExists is returned.
+func (p PSQL) LogEventPayload(id string, payload []byte) error {
+	return p.logEvent(id, payload)
+}
+
+func (p PSQL) logEvent(id string, payload []byte) error {
 	q := query.Select(
 		query.Count("id"),
-		query.From(eventTable),
+		query.From(p.table(eventTable)),
 		query.Where("id", "=", query.Arg(id)),
 	)
 
@@ -250,19 +629,55 @@ func (p PSQL) LogEvent(id string) error {
 		return ErrEventExists
 	}
 
-	q = query.Insert(eventTable, query.Columns("id"), query.Values(id))
+	q = query.Insert(p.table(eventTable), query.Columns("id"), query.Values(id))
+
+	if p.KeepEvents && payload != nil {
+		q = query.Insert(p.table(eventTable), query.Columns("id", "payload"), query.Values(id, string(payload)))
+	}
 
 	_, err := p.Exec(q.Build(), q.Args()...)
 	return err
 }
 
+// Event will get the event of the given ID from the stripe_events table. The
+// event is decoded from its stored payload, so an event will only be found if
+// it was logged with its payload while KeepEvents was true.
+func (p PSQL) Event(id string) (stripe.Event, bool, error) {
+	q := query.Select(
+		query.Columns("payload"),
+		query.From(p.table(eventTable)),
+		query.Where("id", "=", query.Arg(id)),
+	)
+
+	var (
+		event   stripe.Event
+		payload []byte
+	)
+
+	if err := p.QueryRow(q.Build(), q.Args()...).Scan(&payload); err != nil {
+		if err == sql.ErrNoRows {
+			return event, false, nil
+		}
+		return event, false, err
+	}
+
+	if payload == nil {
+		return event, false, nil
+	}
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, false, err
+	}
+	return event, true, nil
+}
+
 // Subscription will get the Subscription for the given Customer from the
 // stripe_subscriptions table and return it along with whether or not the
 // Subscription could be found.
 func (p PSQL) Subscription(c *Customer) (*Subscription, bool, error) {
 	q := query.Select(
-		query.Columns("*"),
-		query.From(subscriptionTable),
+		query.Columns(p.columns("id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end")...),
+		query.From(p.table(subscriptionTable)),
 		query.Where("customer_id", "=", query.Arg(c.ID)),
 		query.OrderDesc("started_at"),
 	)
@@ -273,11 +688,16 @@ func (p PSQL) Subscription(c *Customer) (*Subscription, bool, error) {
 		},
 	}
 
-	var startedAt time.Time
+	var (
+		raw       []byte
+		startedAt time.Time
+	)
+
+	row := p.queryRow(q)
 
-	row := p.QueryRow(q.Build(), q.Args()...)
+	dest := p.scanDest(&raw, &sub.ID, &sub.Customer.ID, &sub.Status, &startedAt, &sub.EndsAt, &sub.CancelAtPeriodEnd)
 
-	if err := row.Scan(&sub.ID, &sub.Customer.ID, &sub.Status, &startedAt, &sub.EndsAt); err != nil {
+	if err := row.Scan(dest...); err != nil {
 		if err != sql.ErrNoRows {
 			return nil, false, err
 		}
@@ -285,16 +705,75 @@ func (p PSQL) Subscription(c *Customer) (*Subscription, bool, error) {
 	}
 
 	sub.StartDate = startedAt.Unix()
+	sub.raw = raw
 	return sub, true, nil
 }
 
+// ActiveSubscriptions will get the active Subscriptions from the
+// stripe_subscriptions table, using the given limit and offset for pagination.
+// The Subscriptions are sorted from newest to oldest, and by ID if they started
+// at the same time, so the order is stable between pages.
+func (p PSQL) ActiveSubscriptions(limit, offset int) ([]*Subscription, error) {
+	q := query.Select(
+		query.Columns(p.columns("id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end")...),
+		query.From(p.table(subscriptionTable)),
+		query.Where("status", "IN", query.List(
+			string(stripe.SubscriptionStatusActive),
+			string(stripe.SubscriptionStatusTrialing),
+		)),
+		// The builder takes one direction for all columns, so started_at has
+		// its own.
+		query.OrderAsc("started_at DESC", "id"),
+		query.Limit(int64(limit)),
+		query.Offset(int64(offset)),
+	)
+
+	rows, err := p.Query(q.Build(), q.Args()...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	subs := make([]*Subscription, 0)
+
+	for rows.Next() {
+		sub := &Subscription{
+			Subscription: &stripe.Subscription{
+				Customer: &stripe.Customer{},
+			},
+		}
+
+		var (
+			raw       []byte
+			startedAt time.Time
+		)
+
+		dest := p.scanDest(&raw, &sub.ID, &sub.Customer.ID, &sub.Status, &startedAt, &sub.EndsAt, &sub.CancelAtPeriodEnd)
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		sub.StartDate = startedAt.Unix()
+		sub.raw = raw
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
 // DefaultPaymentMethod will get the default PaymentMethod for the given
 // Customer from the stripe_payment_methods table along with whether or not the
 // PaymentMethod could be found.
 func (p PSQL) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
 	q := query.Select(
-		query.Columns("*"),
-		query.From(paymentMethodTable),
+		query.Columns(p.columns("id", "customer_id", "type", "info", "is_default", "created_at")...),
+		query.From(p.table(paymentMethodTable)),
 		query.Where("customer_id", "=", query.Arg(c.ID)),
 		query.Where("is_default", "=", query.Arg(true)),
 	)
@@ -307,12 +786,13 @@ func (p PSQL) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
 
 	var (
 		info    []byte
+		raw     []byte
 		created time.Time
 	)
 
-	row := p.QueryRow(q.Build(), q.Args()...)
+	row := p.queryRow(q)
 
-	if err := row.Scan(&pm.ID, &pm.Customer.ID, &pm.Type, &info, &pm.Default, &created); err != nil {
+	if err := row.Scan(p.scanDest(&raw, &pm.ID, &pm.Customer.ID, &pm.Type, &info, &pm.Default, &created)...); err != nil {
 		if err != sql.ErrNoRows {
 			return nil, false, err
 		}
@@ -320,6 +800,7 @@ func (p PSQL) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
 	}
 
 	pm.Created = created.Unix()
+	pm.raw = raw
 
 	if err := unmarshalPaymentMethodInfo(info, pm); err != nil {
 		return nil, false, err
@@ -328,12 +809,27 @@ func (p PSQL) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
 }
 
 func (p PSQL) Invoices(c *Customer) ([]*Invoice, error) {
-	q := query.Select(
-		query.Columns("*"),
-		query.From(invoiceTable),
+	return p.getInvoices(
 		query.Where("customer_id", "=", query.Arg(c.ID)),
 		query.OrderDesc("created_at"),
 	)
+}
+
+// InvoicesByStatus returns all of the Invoices with the given status from the
+// stripe_invoices table, sorted from newest to oldest.
+func (p PSQL) InvoicesByStatus(status string) ([]*Invoice, error) {
+	return p.getInvoices(
+		query.Where("status", "=", query.Arg(status)),
+		query.OrderDesc("created_at"),
+	)
+}
+
+func (p PSQL) getInvoices(opts ...query.Option) ([]*Invoice, error) {
+	opts = append([]query.Option{
+		query.From(p.table(invoiceTable)),
+	}, opts...)
+
+	q := query.Select(query.Columns(p.columns("id", "customer_id", "number", "amount", "status", "created_at", "updated_at")...), opts...)
 
 	rows, err := p.Query(q.Build(), q.Args()...)
 
@@ -341,17 +837,23 @@ func (p PSQL) Invoices(c *Customer) ([]*Invoice, error) {
 		return nil, err
 	}
 
+	defer rows.Close()
+
 	invs := make([]*Invoice, 0)
 
 	for rows.Next() {
-		var created time.Time
+		var (
+			raw     []byte
+			created time.Time
+		)
 
 		inv := &Invoice{
 			Invoice: &stripe.Invoice{},
 		}
 		inv.Customer = &stripe.Customer{}
 
-		err := rows.Scan(
+		err := rows.Scan(p.scanDest(
+			&raw,
 			&inv.ID,
 			&inv.Customer.ID,
 			&inv.Number,
@@ -359,15 +861,20 @@ func (p PSQL) Invoices(c *Customer) ([]*Invoice, error) {
 			&inv.Status,
 			&created,
 			&inv.Updated,
-		)
+		)...)
 
 		if err != nil {
 			return nil, err
 		}
 
 		inv.Created = created.Unix()
+		inv.raw = raw
 		invs = append(invs, inv)
 	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return invs, nil
 }
 
@@ -380,30 +887,69 @@ func (p PSQL) PaymentMethods(c *Customer) ([]*PaymentMethod, error) {
 	)
 }
 
+// ExpiringCards returns all of the card PaymentMethods from the
+// stripe_payment_methods table that will have expired by the given time. This
+// is done by querying the exp_year and exp_month fields of the info JSON,
+//
+//     make_date((info->>'exp_year')::int, (info->>'exp_month')::int, 1) + INTERVAL '1 month'
+//
+// which gives the time at which the card expires.
+func (p PSQL) ExpiringCards(before time.Time) ([]*PaymentMethod, error) {
+	return p.getPaymentMethods(
+		query.Where("type", "=", query.Arg("card")),
+		query.Where("make_date((info->>'exp_year')::int, (info->>'exp_month')::int, 1) + INTERVAL '1 month'", "<=", query.Arg(before)),
+		query.OrderAsc("created_at"),
+	)
+}
+
 func (p PSQL) putCustomer(c *Customer) error {
-	_, ok, err := p.LookupCustomer(c.Email)
+	// Check for the Customer by ID rather than email, since the email may
+	// have been changed.
+	q := query.Select(
+		query.Columns("id"),
+		query.From(p.table(customerTable)),
+		query.Where("id", "=", query.Arg(c.ID)),
+	)
 
-	if err != nil {
-		return err
+	var id string
+
+	if err := p.QueryRow(q.Build(), q.Args()...).Scan(&id); err != nil {
+		if err != sql.ErrNoRows {
+			return err
+		}
 	}
 
-	if ok {
-		q := query.Update(
-			customerTable,
+	if id != "" {
+		address, shipping, err := customerAddress(c)
+
+		if err != nil {
+			return err
+		}
+
+		opts, err := p.updateRaw(c, []query.Option{
 			query.Set("email", query.Arg(c.Email)),
 			query.Set("jurisdiction", query.Arg(c.Jurisdiction)),
-			query.Where("id", "=", query.Arg(c.ID)),
-		)
+			query.Set("address", query.Arg(address)),
+			query.Set("shipping", query.Arg(shipping)),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		q := query.Update(p.table(customerTable), append(opts, query.Where("id", "=", query.Arg(c.ID)))...)
 
 		_, err = p.Exec(q.Build(), q.Args()...)
 		return err
 	}
 
-	q := query.Insert(
-		customerTable,
-		query.Columns("id", "email", "jurisdiction", "created_at"),
-		query.Values(c.ID, c.Email, c.Jurisdiction, time.Unix(c.Created, 0)),
-	)
+	table, cols, vals, err := p.insertColumns(c)
+
+	if err != nil {
+		return err
+	}
+
+	q = query.Insert(table, query.Columns(cols...), query.Values(vals...))
 
 	_, err = p.Exec(q.Build(), q.Args()...)
 	return err
@@ -412,7 +958,7 @@ func (p PSQL) putCustomer(c *Customer) error {
 func (p PSQL) putInvoice(i *Invoice) error {
 	q := query.Select(
 		query.Columns("id"),
-		query.From(invoiceTable),
+		query.From(p.table(invoiceTable)),
 		query.Where("id", "=", query.Arg(i.ID)),
 	)
 
@@ -425,33 +971,43 @@ func (p PSQL) putInvoice(i *Invoice) error {
 	}
 
 	if id == "" {
-		created := time.Unix(i.Created, 0)
+		table, cols, vals, err := p.insertColumns(i)
 
-		q = query.Insert(
-			invoiceTable,
-			query.Columns("id", "customer_id", "number", "amount", "status", "created_at", "updated_at"),
-			query.Values(i.ID, i.Customer.ID, i.Number, i.AmountDue, i.Status, created, created),
-		)
+		if err != nil {
+			return err
+		}
 
-		_, err := p.Exec(q.Build(), q.Args()...)
+		q = query.Insert(table, query.Columns(cols...), query.Values(vals...))
+
+		_, err = p.Exec(q.Build(), q.Args()...)
 		return err
 	}
 
-	q = query.Update(
-		invoiceTable,
+	opts, err := p.updateRaw(i, []query.Option{
 		query.Set("status", query.Arg(i.Status)),
 		query.Set("updated_at", query.Arg(time.Now())),
-		query.Where("id", "=", query.Arg(i.ID)),
-	)
+	})
 
-	_, err := p.Exec(q.Build(), q.Args()...)
+	if err != nil {
+		return err
+	}
+
+	q = query.Update(p.table(invoiceTable), append(opts, query.Where("id", "=", query.Arg(i.ID)))...)
+
+	_, err = p.Exec(q.Build(), q.Args()...)
 	return err
 }
 
 func (p PSQL) putPaymentMethod(pm *PaymentMethod) error {
+	table, cols, vals, err := p.insertColumns(pm)
+
+	if err != nil {
+		return err
+	}
+
 	if pm.Default {
 		q := query.Update(
-			paymentMethodTable,
+			p.table(paymentMethodTable),
 			query.Set("is_default", query.Arg(false)),
 			query.Where("customer_id", "=", query.Arg(pm.Customer.ID)),
 		)
@@ -463,7 +1019,7 @@ func (p PSQL) putPaymentMethod(pm *PaymentMethod) error {
 
 	q := query.Select(
 		query.Columns("id"),
-		query.From(paymentMethodTable),
+		query.From(p.table(paymentMethodTable)),
 		query.Where("id", "=", query.Arg(pm.ID)),
 	)
 
@@ -476,12 +1032,17 @@ func (p PSQL) putPaymentMethod(pm *PaymentMethod) error {
 	}
 
 	if id == "" {
-		info := getPaymentMethodInfo(pm)
+		q = query.Insert(table, query.Columns(cols...), query.Values(vals...))
 
-		q = query.Insert(
-			paymentMethodTable,
-			query.Columns("id", "customer_id", "type", "info", "is_default", "created_at"),
-			query.Values(pm.ID, pm.Customer.ID, pm.Type, info, pm.Default, time.Unix(pm.Created, 0)),
+		_, err = p.Exec(q.Build(), q.Args()...)
+		return err
+	}
+
+	if pm.Default {
+		q = query.Update(
+			p.table(paymentMethodTable),
+			query.Set("is_default", query.Arg(true)),
+			query.Where("id", "=", query.Arg(pm.ID)),
 		)
 
 		_, err := p.Exec(q.Build(), q.Args()...)
@@ -493,7 +1054,7 @@ func (p PSQL) putPaymentMethod(pm *PaymentMethod) error {
 func (p PSQL) putSubscription(s *Subscription) error {
 	q := query.Select(
 		query.Columns("id"),
-		query.From(subscriptionTable),
+		query.From(p.table(subscriptionTable)),
 		query.Where("id", "=", query.Arg(s.ID)),
 	)
 
@@ -506,24 +1067,31 @@ func (p PSQL) putSubscription(s *Subscription) error {
 	}
 
 	if id == "" {
-		q = query.Insert(
-			subscriptionTable,
-			query.Columns("id", "customer_id", "status", "started_at", "ends_at"),
-			query.Values(s.ID, s.Customer.ID, s.Status, time.Unix(s.StartDate, 0), s.EndsAt),
-		)
+		table, cols, vals, err := p.insertColumns(s)
 
-		_, err := p.Exec(q.Build(), q.Args()...)
+		if err != nil {
+			return err
+		}
+
+		q = query.Insert(table, query.Columns(cols...), query.Values(vals...))
+
+		_, err = p.Exec(q.Build(), q.Args()...)
 		return err
 	}
 
-	q = query.Update(
-		subscriptionTable,
+	opts, err := p.updateRaw(s, []query.Option{
 		query.Set("status", query.Arg(s.Status)),
 		query.Set("ends_at", query.Arg(s.EndsAt)),
-		query.Where("id", "=", query.Arg(s.ID)),
-	)
+		query.Set("cancel_at_period_end", query.Arg(s.CancelAtPeriodEnd)),
+	})
 
-	_, err := p.Exec(q.Build(), q.Args()...)
+	if err != nil {
+		return err
+	}
+
+	q = query.Update(p.table(subscriptionTable), append(opts, query.Where("id", "=", query.Arg(s.ID)))...)
+
+	_, err = p.Exec(q.Build(), q.Args()...)
 	return err
 }
 
@@ -544,28 +1112,156 @@ func (p PSQL) Put(r Resource) error {
 	}
 }
 
+// BulkInsert will insert all of the given Resources into the PostgreSQL
+// database within a single transaction. Unlike Put, this does not check if
+// each Resource already exists, instead the Resources are inserted in batches
+// of multiple rows with ON CONFLICT DO NOTHING, so any Resource that already
+// exists is left as it is. This is intended for the initial import of
+// Resources into the database, such as when migrating from another billing
+// system, where calling Put for each Resource would be too slow. If any of the
+// Resources cannot be stored then nothing is inserted, and the error is
+// returned.
+//
+// Since existing Resources are not updated, the Default field of the given
+// PaymentMethods is inserted as is, so only one PaymentMethod for each
+// Customer should be the default.
+func (p PSQL) BulkInsert(rr []Resource) error {
+	tables := make([]string, 0)
+	batches := make(map[string]*bulkBatch)
+
+	for _, r := range rr {
+		table, cols, vals, err := p.insertColumns(r)
+
+		if err != nil {
+			return err
+		}
+
+		b, ok := batches[table]
+
+		if !ok {
+			b = &bulkBatch{
+				table: table,
+				cols:  cols,
+			}
+
+			tables = append(tables, table)
+			batches[table] = b
+		}
+		b.rows = append(b.rows, vals)
+	}
+
+	tx, err := p.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		b := batches[table]
+
+		for i := 0; i < len(b.rows); i += bulkBatchSize {
+			end := i + bulkBatchSize
+
+			if end > len(b.rows) {
+				end = len(b.rows)
+			}
+
+			q, args := b.build(b.rows[i:end])
+
+			if _, err := tx.Exec(q, args...); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// bulkBatchSize is the maximum number of rows inserted in a single query by
+// BulkInsert.
+const bulkBatchSize = 500
+
+// bulkBatch is the rows to insert into a table via BulkInsert.
+type bulkBatch struct {
+	table string
+	cols  []string
+	rows  [][]interface{}
+}
+
+// build returns the query for inserting the given rows into the table of the
+// batch, and the arguments for the query. Rows that conflict with an existing
+// row are ignored.
+func (b *bulkBatch) build(rows [][]interface{}) (string, []interface{}) {
+	var buf strings.Builder
+
+	args := make([]interface{}, 0, len(rows)*len(b.cols))
+
+	buf.WriteString("INSERT INTO " + b.table + " (" + strings.Join(b.cols, ", ") + ") VALUES ")
+
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+
+		buf.WriteByte('(')
+
+		for j, val := range row {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+
+			args = append(args, val)
+			buf.WriteString("$" + strconv.Itoa(len(args)))
+		}
+		buf.WriteByte(')')
+	}
+
+	buf.WriteString(" ON CONFLICT DO NOTHING")
+	return buf.String(), args
+}
+
+// Remove will remove the given Resource from the PostgreSQL database. The
+// table the Resource is removed from is determined by the Resource's kind. A
+// Resource without an ID is not removed.
 func (p PSQL) Remove(r Resource) error {
-	var id, table string
+	table, ok := kindTables[r.Kind()]
 
-	switch v := r.(type) {
-	case *Customer:
-		id = v.ID
-		table = customerTable
-	case *Invoice:
-		id = v.ID
-		table = invoiceTable
-	case *PaymentMethod:
-		id = v.ID
-		table = paymentMethodTable
-	case *Subscription:
-		id = v.ID
-		table = subscriptionTable
-	default:
+	if !ok {
 		return nil
 	}
 
-	q := query.Delete(table, query.Where("id", "=", query.Arg(id)))
+	id := resourceID(r)
+
+	if id == "" {
+		return nil
+	}
+
+	q := query.Delete(p.table(table), query.Where("id", "=", query.Arg(id)))
 
 	_, err := p.Exec(q.Build(), q.Args()...)
 	return err
 }
+
+// resourceID returns the ID of the given Resource, if it is one of the
+// Resources stored by the PSQL store.
+func resourceID(r Resource) string {
+	switch v := r.(type) {
+	case *Customer:
+		if v.Customer != nil {
+			return v.ID
+		}
+	case *Invoice:
+		if v.Invoice != nil {
+			return v.ID
+		}
+	case *PaymentMethod:
+		if v.PaymentMethod != nil {
+			return v.ID
+		}
+	case *Subscription:
+		if v.Subscription != nil {
+			return v.ID
+		}
+	}
+	return ""
+}
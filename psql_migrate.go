@@ -0,0 +1,289 @@
+package stripeutil
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+)
+
+// ErrInvalidSchemaVersion is returned when migrating down to a version that
+// does not exist, or when the current version of the schema is newer than the
+// migrations known to the PSQL store.
+var ErrInvalidSchemaVersion = errors.New("invalid schema version")
+
+// migration is a single versioned change to the schema required by the PSQL
+// store. The up statements apply the change, and the down statements revert
+// it. The indexes are created after the up statements, and dropped before the
+// down statements.
+type migration struct {
+	up      []string
+	down    []string
+	indexes []index
+}
+
+// index is an index on a single column of one of the tables used by the PSQL
+// store. The table is given by its default name.
+type index struct {
+	table  string
+	column string
+}
+
+var (
+	// migrations are the migrations for the schema required by the PSQL store.
+	// The version of each migration is its position in the slice plus one. New
+	// migrations should only ever be appended. Table names are given as
+	// ${table} so the table names configured on the store are used.
+	migrations = []migration{
+		{
+			up: []string{
+				`CREATE TABLE IF NOT EXISTS ${stripe_customers} (
+	id           VARCHAR NOT NULL UNIQUE,
+	email        VARCHAR NOT NULL UNIQUE,
+	jurisdiction VARCHAR NULL,
+	created_at   TIMESTAMP NOT NULL
+)`,
+				`CREATE TABLE IF NOT EXISTS ${stripe_events} (
+	id VARCHAR NOT NULL UNIQUE
+)`,
+				`CREATE TABLE IF NOT EXISTS ${stripe_invoices} (
+	id          VARCHAR NOT NULL UNIQUE,
+	customer_id VARCHAR NOT NULL,
+	number      VARCHAR NOT NULL,
+	amount      NUMERIC NOT NULL,
+	status      VARCHAR NOT NULL,
+	created_at  TIMESTAMP NOT NULL,
+	updated_at  TIMESTAMP NOT NULL
+)`,
+				`CREATE TABLE IF NOT EXISTS ${stripe_payment_methods} (
+	id          VARCHAR NOT NULL UNIQUE,
+	customer_id VARCHAR NOT NULL,
+	type        VARCHAR NOT NULL,
+	info        JSON NOT NULL,
+	is_default  BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at  TIMESTAMP NOT NULL
+)`,
+				`CREATE TABLE IF NOT EXISTS ${stripe_subscriptions} (
+	id          VARCHAR NOT NULL UNIQUE,
+	customer_id VARCHAR NOT NULL,
+	status      VARCHAR NOT NULL,
+	started_at  TIMESTAMP NOT NULL,
+	ends_at     TIMESTAMP NULL
+)`,
+			},
+			down: []string{
+				"DROP TABLE IF EXISTS ${stripe_subscriptions}",
+				"DROP TABLE IF EXISTS ${stripe_payment_methods}",
+				"DROP TABLE IF EXISTS ${stripe_invoices}",
+				"DROP TABLE IF EXISTS ${stripe_events}",
+				"DROP TABLE IF EXISTS ${stripe_customers}",
+			},
+		},
+		{
+			up: []string{
+				"ALTER TABLE ${stripe_subscriptions} ADD COLUMN IF NOT EXISTS cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE",
+			},
+			down: []string{
+				"ALTER TABLE ${stripe_subscriptions} DROP COLUMN IF EXISTS cancel_at_period_end",
+			},
+		},
+		{
+			up: []string{
+				"ALTER TABLE ${stripe_customers} ADD COLUMN IF NOT EXISTS address JSON NULL",
+				"ALTER TABLE ${stripe_customers} ADD COLUMN IF NOT EXISTS shipping JSON NULL",
+			},
+			down: []string{
+				"ALTER TABLE ${stripe_customers} DROP COLUMN IF EXISTS shipping",
+				"ALTER TABLE ${stripe_customers} DROP COLUMN IF EXISTS address",
+			},
+		},
+		{
+			up: []string{
+				"ALTER TABLE ${stripe_events} ADD COLUMN IF NOT EXISTS payload JSONB NULL",
+			},
+			down: []string{
+				"ALTER TABLE ${stripe_events} DROP COLUMN IF EXISTS payload",
+			},
+		},
+		{
+			indexes: []index{
+				{invoiceTable, "customer_id"},
+				{invoiceTable, "status"},
+				{paymentMethodTable, "customer_id"},
+				{subscriptionTable, "customer_id"},
+				{subscriptionTable, "status"},
+			},
+		},
+	}
+
+	// rawTables are the tables that have the raw column added to them when
+	// the PSQL store keeps the raw JSON of resources.
+	rawTables = []string{customerTable, invoiceTable, paymentMethodTable, subscriptionTable}
+)
+
+// indexName returns the name of the given index, and the schema of the table
+// the index is on, if the table is qualified with one. The name of the index
+// is derived from the name of its table, since the name of an index must be
+// unique within a schema.
+func (p PSQL) indexName(idx index) (string, string) {
+	table := p.table(idx.table)
+	schema := ""
+
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schema = table[:i+1]
+		table = table[i+1:]
+	}
+	return table + "_" + idx.column + "_idx", schema
+}
+
+// createIndex returns the statement for creating the given index.
+func (p PSQL) createIndex(idx index) string {
+	name, _ := p.indexName(idx)
+
+	return "CREATE INDEX IF NOT EXISTS " + name + " ON " + p.table(idx.table) + " (" + idx.column + ")"
+}
+
+// dropIndex returns the statement for dropping the given index.
+func (p PSQL) dropIndex(idx index) string {
+	name, schema := p.indexName(idx)
+
+	return "DROP INDEX IF EXISTS " + schema + name
+}
+
+// schemaVersion returns the current version of the schema from the
+// stripe_schema_version table. If create is true then the table is created if
+// it does not exist, otherwise a version of 0 is returned if the table does not
+// exist.
+func (p PSQL) schemaVersion(tx *sql.Tx, create bool) (int, error) {
+	table := p.table(schemaVersionTable)
+
+	if create {
+		if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + table + " (version INTEGER NOT NULL UNIQUE)"); err != nil {
+			return 0, err
+		}
+	} else {
+		var exists bool
+
+		if err := tx.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			return 0, nil
+		}
+	}
+
+	var version int
+
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM " + table).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (p PSQL) migrate(create bool, fn func(tx *sql.Tx, version int) error) error {
+	tx, err := p.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	version, err := p.schemaVersion(tx, create)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := fn(tx, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migrate will bring the schema required by the PSQL store up to date. The
+// version of the schema is tracked in the stripe_schema_version table, and
+// only the migrations that have not yet been applied are run. If KeepRaw is
+// true, then the raw column will be added to the tables that require it. The
+// indexes on the customer_id and status columns are also created, unless they
+// already exist. This is safe to call multiple times.
+func (p PSQL) Migrate() error {
+	return p.migrate(true, func(tx *sql.Tx, version int) error {
+		for i := version; i < len(migrations); i++ {
+			for _, stmt := range migrations[i].up {
+				if _, err := tx.Exec(os.Expand(stmt, p.table)); err != nil {
+					return err
+				}
+			}
+
+			for _, idx := range migrations[i].indexes {
+				if _, err := tx.Exec(p.createIndex(idx)); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec("INSERT INTO "+p.table(schemaVersionTable)+" (version) VALUES ($1)", i+1); err != nil {
+				return err
+			}
+		}
+
+		if p.KeepRaw {
+			for _, table := range rawTables {
+				if _, err := tx.Exec("ALTER TABLE " + p.table(table) + " ADD COLUMN IF NOT EXISTS raw JSONB NULL"); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown will revert the schema required by the PSQL store down to the
+// given version. Reverting to version 0 will drop all of the tables used by
+// the PSQL store. ErrInvalidSchemaVersion is returned if the given version is
+// not a known version, or if the current version of the schema is newer than
+// the migrations known to the PSQL store. The stripe_schema_version table is
+// not created if it does not exist.
+func (p PSQL) MigrateDown(to int) error {
+	if to < 0 || to > len(migrations) {
+		return ErrInvalidSchemaVersion
+	}
+
+	return p.migrate(false, func(tx *sql.Tx, version int) error {
+		if version > len(migrations) {
+			return ErrInvalidSchemaVersion
+		}
+
+		for i := version; i > to; i-- {
+			for _, idx := range migrations[i-1].indexes {
+				if _, err := tx.Exec(p.dropIndex(idx)); err != nil {
+					return err
+				}
+			}
+
+			for _, stmt := range migrations[i-1].down {
+				if _, err := tx.Exec(os.Expand(stmt, p.table)); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec("DELETE FROM "+p.table(schemaVersionTable)+" WHERE (version = $1)", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SchemaVersion returns the current version of the schema required by the PSQL
+// store. If the schema has not been migrated then this returns 0.
+func (p PSQL) SchemaVersion() (int, error) {
+	var version int
+
+	err := p.migrate(false, func(_ *sql.Tx, v int) error {
+		version = v
+		return nil
+	})
+	return version, err
+}
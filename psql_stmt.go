@@ -0,0 +1,122 @@
+package stripeutil
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/andrewpillar/query"
+)
+
+// stmtCache caches the prepared statements for queries, keyed by the built
+// query. Only queries whose shape does not change between calls should be
+// cached, otherwise the cache would grow without bound.
+type stmtCache struct {
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+// rowScanner is the interface for scanning a single row returned from a
+// query, such as *sql.Row.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// errRow is returned from queryRow when a statement could not be prepared, the
+// error is returned when the row is scanned like it would be for *sql.Row.
+type errRow struct {
+	err error
+}
+
+// CacheStatements will have the PSQL store prepare the queries made on the hot
+// path, and cache the prepared statements so they are reused across calls.
+// These queries are those made via LookupCustomer, LookupInvoice,
+// Subscription, and DefaultPaymentMethod. This saves the database from having
+// to parse, and plan the same queries on every call, and saves a round trip
+// per query with drivers that prepare per call, such as lib/pq, since a
+// statement no longer has to be prepared before it is executed. This would
+// typically be used when the store is queried on every request, such as in
+// middleware that checks the status of a Subscription.
+//
+// This should be called after Migrate, since the prepared statements will fail
+// if the columns of the tables change after they have been prepared. The PSQL
+// store is passed around by value, so this must be called before the store is
+// given to New, or copied elsewhere, otherwise the copies will not cache the
+// statements. The statements can be closed via CloseStatements.
+func (p *PSQL) CacheStatements() {
+	p.stmts = &stmtCache{
+		stmts: make(map[string]*sql.Stmt),
+	}
+}
+
+// CloseStatements will close all of the prepared statements cached by the PSQL
+// store, if CacheStatements was called.
+func (p PSQL) CloseStatements() error {
+	if p.stmts == nil {
+		return nil
+	}
+	return p.stmts.close()
+}
+
+// queryRow executes the given query, returning the row it returns. If the
+// statements are being cached then the prepared statement for the query is
+// used.
+func (p PSQL) queryRow(q query.Query) rowScanner {
+	if p.stmts == nil {
+		return p.QueryRow(q.Build(), q.Args()...)
+	}
+
+	stmt, err := p.stmts.prepare(p.DB, q.Build())
+
+	if err != nil {
+		return errRow{err: err}
+	}
+	return stmt.QueryRow(q.Args()...)
+}
+
+func (r errRow) Scan(_ ...interface{}) error { return r.err }
+
+// prepare returns the prepared statement for the given query, preparing it
+// on the given database if it has not yet been cached.
+func (c *stmtCache) prepare(db *sql.DB, q string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[q]
+	c.mu.RUnlock()
+
+	if ok {
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The statement may have been prepared whilst waiting for the lock.
+	if stmt, ok := c.stmts[q]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := db.Prepare(q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.stmts[q] = stmt
+	return stmt, nil
+}
+
+// close closes all of the cached statements, returning the first error that
+// occurs.
+func (c *stmtCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+
+	for q, stmt := range c.stmts {
+		if err1 := stmt.Close(); err1 != nil && err == nil {
+			err = err1
+		}
+		delete(c.stmts, q)
+	}
+	return err
+}
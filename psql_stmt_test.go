@@ -0,0 +1,122 @@
+package stripeutil
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchDriver is a database driver that returns a single customer for every
+// query, and counts the round trips that would be made to the database. The
+// connections do not implement driver.Queryer, so like lib/pq with query
+// arguments, a statement is prepared for each query that is not prepared
+// already.
+type benchDriver struct {
+	roundTrips int64
+}
+
+type benchConn struct {
+	drv *benchDriver
+}
+
+type benchStmt struct {
+	drv *benchDriver
+}
+
+type benchRows struct {
+	done bool
+}
+
+var benchDrv = &benchDriver{}
+
+func init() {
+	sql.Register("stripeutil_bench", benchDrv)
+}
+
+func (d *benchDriver) Open(_ string) (driver.Conn, error) { return benchConn{drv: d}, nil }
+
+func (c benchConn) Prepare(_ string) (driver.Stmt, error) {
+	atomic.AddInt64(&c.drv.roundTrips, 1)
+	return benchStmt{drv: c.drv}, nil
+}
+
+func (benchConn) Close() error { return nil }
+
+func (benchConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (benchStmt) Close() error { return nil }
+
+func (benchStmt) NumInput() int { return -1 }
+
+func (benchStmt) Exec(_ []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s benchStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	atomic.AddInt64(&s.drv.roundTrips, 1)
+	return &benchRows{}, nil
+}
+
+func (*benchRows) Columns() []string {
+	return []string{"id", "email", "jurisdiction", "created_at", "address", "shipping"}
+}
+
+func (*benchRows) Close() error { return nil }
+
+func (r *benchRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+
+	dest[0] = "cus_123456"
+	dest[1] = "me@example.com"
+	dest[2] = nil
+	dest[3] = time.Now()
+	dest[4] = nil
+	dest[5] = nil
+	return nil
+}
+
+// benchmarkLookupCustomer reports the round trips made by LookupCustomer per
+// call, with and without CacheStatements. Caching takes this from 2 round trips
+// down to 1, whilst the time spent in process stays about the same, so the
+// saving depends on the latency to the database.
+func benchmarkLookupCustomer(b *testing.B, cache bool) {
+	db, err := sql.Open("stripeutil_bench", "")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer db.Close()
+
+	store := PSQL{DB: db}
+
+	if cache {
+		store.CacheStatements()
+		defer store.CloseStatements()
+	}
+
+	atomic.StoreInt64(&benchDrv.roundTrips, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := store.LookupCustomer("me@example.com"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(atomic.LoadInt64(&benchDrv.roundTrips))/float64(b.N), "roundtrips/op")
+}
+
+func Benchmark_LookupCustomer(b *testing.B) { benchmarkLookupCustomer(b, false) }
+
+func Benchmark_LookupCustomerCacheStatements(b *testing.B) { benchmarkLookupCustomer(b, true) }
@@ -2,6 +2,11 @@ package stripeutil
 
 import (
 	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"regexp"
 	"testing"
 	"time"
@@ -34,27 +39,27 @@ func Test_LookupCustomer(t *testing.T) {
 	}{
 		{
 			"customer@example.com",
-			"SELECT * FROM stripe_customers WHERE (email = $1)",
+			"SELECT id, email, jurisdiction, created_at, address, shipping FROM stripe_customers WHERE (email = $1)",
 			true,
-			[]driver.Value{"cus_123456", "customer@example.com", nil, time.Now()},
+			[]driver.Value{"cus_123456", "customer@example.com", nil, time.Now(), []byte(`{"country": "GB"}`), nil},
 		},
 		{
 			"foo@example.com",
-			"SELECT * FROM stripe_customers WHERE (email = $1)",
+			"SELECT id, email, jurisdiction, created_at, address, shipping FROM stripe_customers WHERE (email = $1)",
 			false,
 			[]driver.Value{},
 		},
 	}
 
 	for i, test := range tests {
-		rows := sqlmock.NewRows([]string{"id", "email", "jurisdiction", "created_at"})
+		rows := sqlmock.NewRows([]string{"id", "email", "jurisdiction", "created_at", "address", "shipping"})
 
 		if len(test.row) > 0 {
 			rows.AddRow(test.row...)
 		}
 		mock.ExpectQuery(regexp.QuoteMeta(test.expectedQuery)).WithArgs(test.email).WillReturnRows(rows)
 
-		_, ok, err := store.LookupCustomer(test.email)
+		c, ok, err := store.LookupCustomer(test.email)
 
 		if err != nil {
 			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
@@ -64,6 +69,10 @@ func Test_LookupCustomer(t *testing.T) {
 			t.Errorf("tests[%d] - expected customer lookup to be ok=%v, it was not\n", i, test.expectedOk)
 			continue
 		}
+
+		if ok && c.Address.Country != "GB" {
+			t.Errorf("tests[%d] - expected customer address to be decoded\n", i)
+		}
 	}
 }
 
@@ -83,20 +92,20 @@ func Test_Subscription(t *testing.T) {
 					ID: "cus_123456",
 				},
 			},
-			"SELECT * FROM stripe_subscriptions WHERE (customer_id = $1)",
+			"SELECT id, customer_id, status, started_at, ends_at, cancel_at_period_end FROM stripe_subscriptions WHERE (customer_id = $1)",
 			true,
-			[]driver.Value{"sub_123456", "cus_123456", "active", time.Now(), nil},
+			[]driver.Value{"sub_123456", "cus_123456", "active", time.Now(), nil, false},
 		},
 		{
 			&Customer{Customer: &stripe.Customer{}},
-			"SELECT * FROM stripe_subscriptions WHERE (customer_id = $1)",
+			"SELECT id, customer_id, status, started_at, ends_at, cancel_at_period_end FROM stripe_subscriptions WHERE (customer_id = $1)",
 			false,
 			[]driver.Value{},
 		},
 	}
 
 	for i, test := range tests {
-		rows := sqlmock.NewRows([]string{"id", "customer_id", "status", "started_at", "ends_at"})
+		rows := sqlmock.NewRows([]string{"id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end"})
 
 		if len(test.row) > 0 {
 			rows.AddRow(test.row...)
@@ -132,7 +141,7 @@ func Test_DefaultPaymentMethod(t *testing.T) {
 					ID: "cus_123456",
 				},
 			},
-			"SELECT * FROM stripe_payment_methods WHERE (customer_id = $1 AND is_default = $2)",
+			"SELECT id, customer_id, type, info, is_default, created_at FROM stripe_payment_methods WHERE (customer_id = $1 AND is_default = $2)",
 			true,
 			[]driver.Value{
 				"pm_123456",
@@ -145,7 +154,7 @@ func Test_DefaultPaymentMethod(t *testing.T) {
 		},
 		{
 			&Customer{Customer: &stripe.Customer{}},
-			"SELECT * FROM stripe_payment_methods WHERE (customer_id = $1 AND is_default = $2)",
+			"SELECT id, customer_id, type, info, is_default, created_at FROM stripe_payment_methods WHERE (customer_id = $1 AND is_default = $2)",
 			false,
 			[]driver.Value{},
 		},
@@ -171,3 +180,746 @@ func Test_DefaultPaymentMethod(t *testing.T) {
 		}
 	}
 }
+
+func Test_LookupCustomerKeepRaw(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	store.KeepRaw = true
+
+	raw := `{"id": "cus_123456", "email": "customer@example.com"}`
+
+	rows := sqlmock.NewRows([]string{"id", "email", "jurisdiction", "created_at", "address", "shipping", "raw"}).
+		AddRow("cus_123456", "customer@example.com", nil, time.Now(), nil, nil, []byte(raw))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, email, jurisdiction, created_at, address, shipping, raw FROM stripe_customers WHERE (email = $1)")).
+		WithArgs("customer@example.com").
+		WillReturnRows(rows)
+
+	c, ok, err := store.LookupCustomer("customer@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected customer lookup to be ok=true, it was not")
+	}
+
+	b, err := c.Raw()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != raw {
+		t.Fatalf("unexpected raw json, expected=%q, got=%q\n", raw, string(b))
+	}
+}
+
+func Test_ExpiringCards(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	before := time.Now().AddDate(0, 1, 0)
+
+	rows := mock.NewRows([]string{"id", "customer_id", "type", "info", "is_default", "created_at"}).
+		AddRow(
+			"pm_123456",
+			"cus_123456",
+			"card",
+			`{"brand": "visa", "last4": "4242", "exp_month": 2, "exp_year": 24}`,
+			true,
+			time.Now(),
+		)
+
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT id, customer_id, type, info, is_default, created_at FROM stripe_payment_methods WHERE (type = $1 AND make_date((info->>'exp_year')::int, (info->>'exp_month')::int, 1) + INTERVAL '1 month' <= $2) ORDER BY created_at ASC",
+	)).WithArgs("card", before).WillReturnRows(rows)
+
+	pms, err := store.ExpiringCards(before)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pms) != 1 {
+		t.Fatalf("unexpected payment methods, expected=%d, got=%d\n", 1, len(pms))
+	}
+}
+
+func Test_PutDefaultPaymentMethod(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{
+			ID:       "pm_123456",
+			Customer: &stripe.Customer{ID: "cus_123456"},
+			Type:     "card",
+		},
+		Default: true,
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE stripe_payment_methods SET is_default = $1 WHERE (customer_id = $2)")).
+		WithArgs(false, "cus_123456").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM stripe_payment_methods WHERE (id = $1)")).
+		WithArgs("pm_123456").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("pm_123456"))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE stripe_payment_methods SET is_default = $1 WHERE (id = $2)")).
+		WithArgs(true, "pm_123456").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.Put(pm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	store.KeepRaw = true
+	store.Prefix = "tenant1."
+
+	// Assume the first migration has already been applied.
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS tenant1.stripe_schema_version")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM tenant1.stripe_schema_version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+
+	for i, m := range migrations[1:] {
+		for _, stmt := range m.up {
+			mock.ExpectExec(regexp.QuoteMeta(os.Expand(stmt, store.table))).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		for _, idx := range m.indexes {
+			mock.ExpectExec(regexp.QuoteMeta(store.createIndex(idx))).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tenant1.stripe_schema_version (version) VALUES ($1)")).
+			WithArgs(i + 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	for _, table := range rawTables {
+		mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE tenant1." + table + " ADD COLUMN IF NOT EXISTS raw JSONB NULL")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	mock.ExpectCommit()
+
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MigrateDown(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL")).
+		WithArgs("stripe_schema_version").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM stripe_schema_version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations)))
+
+	for i := len(migrations); i > 1; i-- {
+		for _, idx := range migrations[i-1].indexes {
+			mock.ExpectExec(regexp.QuoteMeta(store.dropIndex(idx))).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		for _, stmt := range migrations[i-1].down {
+			mock.ExpectExec(regexp.QuoteMeta(os.Expand(stmt, store.table))).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM stripe_schema_version WHERE (version = $1)")).
+			WithArgs(i).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	mock.ExpectCommit()
+
+	if err := store.MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MigrateDownInvalid(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	for _, to := range []int{-1, len(migrations) + 1} {
+		if err := store.MigrateDown(to); err != ErrInvalidSchemaVersion {
+			t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrInvalidSchemaVersion, err)
+		}
+	}
+
+	// The schema has been migrated by a newer version of the PSQL store.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL")).
+		WithArgs("stripe_schema_version").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM stripe_schema_version")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations) + 1))
+	mock.ExpectRollback()
+
+	if err := store.MigrateDown(0); err != ErrInvalidSchemaVersion {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrInvalidSchemaVersion, err)
+	}
+
+	// The schema has never been migrated, so nothing is created or reverted.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL")).
+		WithArgs("stripe_schema_version").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectCommit()
+
+	if err := store.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ActiveSubscriptions(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end"}).
+		AddRow("sub_123456", "cus_123456", "active", time.Now(), nil, false).
+		AddRow("sub_654321", "cus_654321", "trialing", time.Now(), nil, false)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, customer_id, status, started_at, ends_at, cancel_at_period_end FROM stripe_subscriptions WHERE (status IN ($1, $2)) ORDER BY started_at DESC, id ASC LIMIT 10 OFFSET 20")).
+		WithArgs("active", "trialing").
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+
+	subs, err := store.ActiveSubscriptions(10, 20)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if len(subs) != 2 {
+		t.Fatalf("expected 2 subscriptions, got %d\n", len(subs))
+	}
+
+	if subs[1].Customer.ID != "cus_654321" {
+		t.Fatalf("unexpected customer, expected=%q, got=%q\n", "cus_654321", subs[1].Customer.ID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rows are closed when a row fails to scan.
+	rows = sqlmock.NewRows([]string{"id", "customer_id", "status", "started_at", "ends_at", "cancel_at_period_end"}).
+		AddRow("sub_123456", "cus_123456", "active", "yesterday", nil, false)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, customer_id, status, started_at, ends_at, cancel_at_period_end FROM stripe_subscriptions")).
+		WithArgs("active", "trialing").
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+
+	if _, err := store.ActiveSubscriptions(10, 0); err == nil {
+		t.Fatal("expected scan error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_InvoicesByStatus(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "customer_id", "number", "amount", "status", "created_at", "updated_at"}).
+		AddRow("in_123456", "cus_123456", "000001", 1000, "open", time.Now(), time.Now()).
+		AddRow("in_654321", "cus_654321", "000002", 2000, "open", time.Now(), time.Now())
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, customer_id, number, amount, status, created_at, updated_at FROM stripe_invoices WHERE (status = $1) ORDER BY created_at DESC")).
+		WithArgs("open").
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+
+	invs, err := store.InvoicesByStatus("open")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if len(invs) != 2 {
+		t.Fatalf("expected 2 invoices, got %d\n", len(invs))
+	}
+
+	if invs[1].Customer.ID != "cus_654321" {
+		t.Fatalf("unexpected customer, expected=%q, got=%q\n", "cus_654321", invs[1].Customer.ID)
+	}
+
+	// An error from the rows is returned, and the rows are still closed.
+	rowErr := errors.New("connection reset")
+
+	rows = sqlmock.NewRows([]string{"id", "customer_id", "number", "amount", "status", "created_at", "updated_at"}).
+		AddRow("in_123456", "cus_123456", "000001", 1000, "open", time.Now(), time.Now()).
+		AddRow("in_654321", "cus_654321", "000002", 2000, "open", time.Now(), time.Now()).
+		RowError(1, rowErr)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, customer_id, number, amount, status, created_at, updated_at FROM stripe_invoices")).
+		WithArgs("open").
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+
+	if _, err := store.InvoicesByStatus("open"); err != rowErr {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", rowErr, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_LogEventPayload(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	store.KeepEvents = true
+
+	payload := `{"id": "evt_123456", "type": "invoice.paid", "object": "event"}`
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(id) FROM stripe_events WHERE (id = $1)")).
+		WithArgs("evt_123456").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO stripe_events (id, payload) VALUES ($1, $2)")).
+		WithArgs("evt_123456", payload).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.LogEventPayload("evt_123456", []byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT payload FROM stripe_events WHERE (id = $1)")).
+		WithArgs("evt_123456").
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).AddRow([]byte(payload)))
+
+	event, ok, err := store.Event("evt_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected event to be found")
+	}
+
+	if event.Type != "invoice.paid" {
+		t.Fatalf("unexpected event type, expected=%q, got=%q\n", "invoice.paid", event.Type)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT payload FROM stripe_events WHERE (id = $1)")).
+		WithArgs("evt_654321").
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).AddRow(nil))
+
+	if _, ok, err := store.Event("evt_654321"); err != nil || ok {
+		t.Fatalf("expected event without payload to not be found, ok=%v, err=%v\n", ok, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PutCustomerEmailChange(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "new@example.com"},
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM stripe_customers WHERE (id = $1)")).
+		WithArgs("cus_123456").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("cus_123456"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE stripe_customers SET email = $1, jurisdiction = $2, address = $3, shipping = $4 WHERE (id = $5)")).
+		WithArgs("new@example.com", "", nil, nil, "cus_123456").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.Put(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PSQLTables(t *testing.T) {
+	tests := []struct {
+		prefix        string
+		tables        map[string]string
+		expectedQuery string
+	}{
+		{"", nil, "SELECT id, email, jurisdiction, created_at, address, shipping FROM stripe_customers WHERE (email = $1)"},
+		{"tenant1.", nil, "SELECT id, email, jurisdiction, created_at, address, shipping FROM tenant1.stripe_customers WHERE (email = $1)"},
+		{"tenant1.", map[string]string{"stripe_customers": "customers"}, "SELECT id, email, jurisdiction, created_at, address, shipping FROM customers WHERE (email = $1)"},
+		{"", map[string]string{"stripe_invoices": "invoices"}, "SELECT id, email, jurisdiction, created_at, address, shipping FROM stripe_customers WHERE (email = $1)"},
+	}
+
+	for i, test := range tests {
+		store, mock := newStore(t)
+
+		store.Prefix = test.prefix
+		store.Tables = test.tables
+
+		mock.ExpectQuery(regexp.QuoteMeta(test.expectedQuery)).
+			WithArgs("me@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "jurisdiction", "created_at", "address", "shipping"}))
+
+		if _, _, err := store.LookupCustomer("me@example.com"); err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+		store.DB.Close()
+	}
+}
+
+func Test_PutUnsupportedPaymentMethod(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{
+			ID:       "pm_123456",
+			Customer: &stripe.Customer{ID: "cus_123456"},
+			Type:     "link",
+		},
+	}
+
+	if err := store.Put(pm); err != ErrUnsupportedPaymentMethod {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrUnsupportedPaymentMethod, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PaymentMethodInfo(t *testing.T) {
+	tests := []struct {
+		pm       *PaymentMethod
+		expected string
+	}{
+		{
+			&PaymentMethod{
+				PaymentMethod: &stripe.PaymentMethod{
+					Type: stripe.PaymentMethodTypeCard,
+					Card: &stripe.PaymentMethodCard{Brand: "visa", Last4: "4242", ExpMonth: 12, ExpYear: 2024},
+				},
+			},
+			`{"brand":"visa","exp_month":12,"exp_year":2024,"last4":"4242"}`,
+		},
+		{
+			&PaymentMethod{
+				PaymentMethod: &stripe.PaymentMethod{
+					Type:   stripe.PaymentMethodTypeSofort,
+					Sofort: &stripe.PaymentMethodSofort{Country: "DE"},
+				},
+			},
+			`{"country":"DE"}`,
+		},
+		{
+			&PaymentMethod{
+				PaymentMethod: &stripe.PaymentMethod{Type: PaymentMethodTypeUSBankAccount},
+				USBankAccount: &USBankAccount{
+					AccountType:   "checking",
+					BankName:      "STRIPE TEST BANK",
+					Last4:         "6789",
+					RoutingNumber: "110000000",
+				},
+			},
+			`{"account_type":"checking","bank_name":"STRIPE TEST BANK","last4":"6789","routing_number":"110000000"}`,
+		},
+		{
+			&PaymentMethod{
+				PaymentMethod: &stripe.PaymentMethod{Type: "link"},
+				raw:           []byte(`{"id": "pm_123456", "type": "link", "link": {"email": "customer@example.com"}}`),
+			},
+			`{"email":"customer@example.com"}`,
+		},
+	}
+
+	for i, test := range tests {
+		info, err := getPaymentMethodInfo(test.pm)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		b, err := json.Marshal(info)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if string(b) != test.expected {
+			t.Errorf("tests[%d] - unexpected info, expected=%s, got=%s\n", i, test.expected, string(b))
+		}
+	}
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Type: stripe.PaymentMethodTypeSofort},
+	}
+
+	if err := unmarshalPaymentMethodInfo([]byte(`{"country": "DE"}`), pm); err != nil {
+		t.Fatal(err)
+	}
+
+	if pm.ID != "pm_123456" {
+		t.Fatalf("unexpected id, expected=%q, got=%q\n", "pm_123456", pm.ID)
+	}
+
+	if pm.Sofort == nil || pm.Sofort.Country != "DE" {
+		t.Fatalf("expected sofort details to be unmarshalled\n")
+	}
+}
+
+func Test_RetrievedPaymentMethodInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "pm_123456", "type": "link", "link": {"email": "customer@example.com"}}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	pm, err := RetrievePaymentMethod(s, "pm_123456")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := getPaymentMethodInfo(pm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info["email"] != "customer@example.com" {
+		t.Fatalf("unexpected info, expected email=%q, got=%v\n", "customer@example.com", info)
+	}
+}
+
+func Test_UnmarshalUSBankAccount(t *testing.T) {
+	pm := &PaymentMethod{}
+
+	b := []byte(`{
+		"id": "pm_123456",
+		"type": "us_bank_account",
+		"us_bank_account": {
+			"account_type": "checking",
+			"bank_name": "STRIPE TEST BANK",
+			"last4": "6789",
+			"routing_number": "110000000"
+		}
+	}`)
+
+	if err := json.Unmarshal(b, pm); err != nil {
+		t.Fatal(err)
+	}
+
+	if pm.USBankAccount == nil || pm.USBankAccount.RoutingNumber != "110000000" {
+		t.Fatalf("expected us_bank_account to be decoded\n")
+	}
+
+	info, err := getPaymentMethodInfo(pm)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = json.Marshal(info)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Type: PaymentMethodTypeUSBankAccount},
+	}
+
+	if err := unmarshalPaymentMethodInfo(b, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if *stored.USBankAccount != *pm.USBankAccount {
+		t.Fatalf("unexpected us_bank_account, expected=%v, got=%v\n", *pm.USBankAccount, *stored.USBankAccount)
+	}
+
+	if !stored.delayedNotification() {
+		t.Fatal("expected us_bank_account to be delayed")
+	}
+}
+
+func Test_BulkInsert(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	c := &stripe.Customer{ID: "cus_123456"}
+
+	rr := []Resource{
+		&Customer{Customer: &stripe.Customer{ID: "cus_123456", Email: "customer@example.com"}},
+		&Subscription{Subscription: &stripe.Subscription{ID: "sub_123456", Customer: c, Status: "active"}},
+		&Customer{Customer: &stripe.Customer{ID: "cus_654321", Email: "foo@example.com"}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO stripe_customers (id, email, jurisdiction, created_at, address, shipping) VALUES ($1, $2, $3, $4, $5, $6), ($7, $8, $9, $10, $11, $12) ON CONFLICT DO NOTHING")).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO stripe_subscriptions (id, customer_id, status, started_at, ends_at, cancel_at_period_end) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := store.BulkInsert(rr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = append(rr, &Price{Price: &stripe.Price{ID: "price_123456"}})
+
+	if err := store.BulkInsert(rr); err != ErrUnknownResource {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrUnknownResource, err)
+	}
+}
+
+func Test_PSQLCacheStatements(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	store.CacheStatements()
+
+	q := regexp.QuoteMeta("SELECT id, email, jurisdiction, created_at, address, shipping FROM stripe_customers WHERE (email = $1)")
+
+	stmt := mock.ExpectPrepare(q)
+
+	emails := []string{"customer@example.com", "foo@example.com"}
+
+	for _, email := range emails {
+		rows := sqlmock.NewRows([]string{"id", "email", "jurisdiction", "created_at", "address", "shipping"}).
+			AddRow("cus_123456", email, nil, time.Now(), nil, nil)
+
+		stmt.ExpectQuery().WithArgs(email).WillReturnRows(rows)
+	}
+
+	for i, email := range emails {
+		c, ok, err := store.LookupCustomer(email)
+
+		if err != nil {
+			t.Fatalf("emails[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if !ok || c.Email != email {
+			t.Fatalf("emails[%d] - expected customer to be found\n", i)
+		}
+	}
+
+	stmt.WillBeClosed()
+
+	if err := store.CloseStatements(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PSQLIndexes(t *testing.T) {
+	tests := []struct {
+		store          PSQL
+		idx            index
+		expectedCreate string
+		expectedDrop   string
+	}{
+		{
+			PSQL{},
+			index{invoiceTable, "customer_id"},
+			"CREATE INDEX IF NOT EXISTS stripe_invoices_customer_id_idx ON stripe_invoices (customer_id)",
+			"DROP INDEX IF EXISTS stripe_invoices_customer_id_idx",
+		},
+		{
+			PSQL{Prefix: "tenant1."},
+			index{subscriptionTable, "status"},
+			"CREATE INDEX IF NOT EXISTS stripe_subscriptions_status_idx ON tenant1.stripe_subscriptions (status)",
+			"DROP INDEX IF EXISTS tenant1.stripe_subscriptions_status_idx",
+		},
+		{
+			PSQL{Tables: map[string]string{paymentMethodTable: "billing.cards"}},
+			index{paymentMethodTable, "customer_id"},
+			"CREATE INDEX IF NOT EXISTS cards_customer_id_idx ON billing.cards (customer_id)",
+			"DROP INDEX IF EXISTS billing.cards_customer_id_idx",
+		},
+	}
+
+	for i, test := range tests {
+		if stmt := test.store.createIndex(test.idx); stmt != test.expectedCreate {
+			t.Errorf("tests[%d] - unexpected statement, expected=%q, got=%q\n", i, test.expectedCreate, stmt)
+		}
+
+		if stmt := test.store.dropIndex(test.idx); stmt != test.expectedDrop {
+			t.Errorf("tests[%d] - unexpected statement, expected=%q, got=%q\n", i, test.expectedDrop, stmt)
+		}
+	}
+}
+
+func Test_PSQLRemove(t *testing.T) {
+	store, mock := newStore(t)
+	defer store.DB.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM stripe_subscriptions WHERE (id = $1)")).
+		WithArgs("sub_123456").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.Remove(&Subscription{Subscription: &stripe.Subscription{ID: "sub_123456"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resources without an ID would otherwise be removed by the last part of
+	// their endpoint, such as "customers".
+	rr := []Resource{
+		&Customer{Customer: &stripe.Customer{}},
+		&Invoice{},
+		&PaymentMethod{PaymentMethod: &stripe.PaymentMethod{}},
+	}
+
+	for _, r := range rr {
+		if err := store.Remove(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
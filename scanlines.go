@@ -0,0 +1,104 @@
+package stripeutil
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+func getr(br *bufio.Reader) (rune, error) {
+	r, _, err := br.ReadRune()
+
+	if err != nil {
+		if err != io.EOF {
+			return -1, err
+		}
+		return -1, nil
+	}
+	return r, nil
+}
+
+func ungetr(br *bufio.Reader) { br.UnreadRune() }
+
+func skipline(br *bufio.Reader) error {
+	for {
+		r, err := getr(br)
+
+		if err != nil {
+			return err
+		}
+
+		if r == '\n' || r == -1 {
+			return nil
+		}
+	}
+}
+
+// scanline scans in the rest of the current line. Any inline comment on the
+// line is discarded, along with any trailing whitespace.
+func scanline(br *bufio.Reader) (string, error) {
+	buf := make([]rune, 0)
+
+	for {
+		r, err := getr(br)
+
+		if err != nil {
+			return "", err
+		}
+
+		if r == '\n' || r == -1 {
+			break
+		}
+
+		if r == '#' {
+			if err := skipline(br); err != nil {
+				return "", err
+			}
+			break
+		}
+		buf = append(buf, r)
+	}
+	return strings.TrimRight(string(buf), " \t\r"), nil
+}
+
+// scanlines scans each line from the given io.Reader, and passes it to the
+// given callback. Leading whitespace, blank lines, and comments (anything
+// following a #) are ignored.
+func scanlines(r io.Reader, fn func(string) error) error {
+	br := bufio.NewReader(r)
+
+	for {
+		r, err := getr(br)
+
+		if err != nil {
+			return err
+		}
+
+		if r == -1 {
+			return nil
+		}
+
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			continue
+		}
+
+		if r == '#' {
+			if err := skipline(br); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ungetr(br)
+
+		line, err := scanline(br)
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package stripeutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_scanlines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"\n\n\n", []string{}},
+		{"# Only a comment", []string{}},
+		{"price_123456", []string{"price_123456"}},
+		{"price_123456\nprice_654321", []string{"price_123456", "price_654321"}},
+		{"price_123456\nprice_654321\n\n\n", []string{"price_123456", "price_654321"}},
+		{"price_123456\r\nprice_654321\r\n", []string{"price_123456", "price_654321"}},
+		{"price_123456 # Basic plan\n# price_000000\nprice_654321", []string{"price_123456", "price_654321"}},
+	}
+
+	for i, test := range tests {
+		lines := make([]string, 0)
+
+		err := scanlines(strings.NewReader(test.input), func(line string) error {
+			lines = append(lines, line)
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if len(lines) != len(test.expected) {
+			t.Fatalf("tests[%d] - unexpected lines, expected=%q, got=%q\n", i, test.expected, lines)
+		}
+
+		for j, line := range lines {
+			if line != test.expected[j] {
+				t.Errorf("tests[%d] - unexpected line, expected=%q, got=%q\n", i, test.expected[j], line)
+			}
+		}
+	}
+}
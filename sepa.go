@@ -0,0 +1,52 @@
+package stripeutil
+
+import (
+	"errors"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// ErrNotSEPADebit is returned from SubscribeSEPA when the given PaymentMethod
+// is not a SEPA Direct Debit PaymentMethod.
+var ErrNotSEPADebit = errors.New("payment method is not sepa_debit")
+
+// SubscribeSEPA creates a new subscription for the given Customer that will be
+// paid via SEPA Direct Debit using the given PaymentMethod. This sets the
+// payment_settings.payment_method_types of the Subscription to "sepa_debit",
+// and otherwise behaves the same as Subscribe. If the given PaymentMethod is
+// not a SEPA Direct Debit PaymentMethod then ErrNotSEPADebit is returned.
+//
+// The given PaymentMethod should have been created from a SetupIntent that was
+// confirmed by the Customer, so that a mandate exists for the PaymentMethod.
+// iDEAL cannot be used for recurring payments, instead an iDEAL SetupIntent
+// is used for generating a SEPA Direct Debit PaymentMethod, which is then
+// passed to SubscribeSEPA, for example,
+//
+//     // The ID of the generated_sepa_debit PaymentMethod from the SetupAttempt
+//     // of the confirmed iDEAL SetupIntent.
+//     pm, err := stripeutil.RetrievePaymentMethod(stripe, "pm_123456")
+//
+//     if err != nil {
+//         // Handle error.
+//     }
+//
+//     sub, err := stripe.SubscribeSEPA(c, pm, stripeutil.Params{
+//         "items": []stripeutil.Params{
+//             {"price": "price_123456"},
+//         },
+//     })
+//
+// Payments made via SEPA Direct Debit are confirmed asynchronously, so the
+// returned Subscription will typically be "incomplete", with the PaymentIntent
+// of its latest Invoice "processing". The Subscription is updated in the store
+// once the webhook for the succeeded, or failed payment is received.
+func (s *Stripe) SubscribeSEPA(c *Customer, pm *PaymentMethod, params Params) (*Subscription, error) {
+	if pm.Type != stripe.PaymentMethodTypeSepaDebit {
+		return nil, ErrNotSEPADebit
+	}
+
+	settings := nestedParams(params, "payment_settings")
+	settings["payment_method_types"] = []string{string(stripe.PaymentMethodTypeSepaDebit)}
+
+	return s.Subscribe(c, pm, params)
+}
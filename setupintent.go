@@ -0,0 +1,82 @@
+package stripeutil
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// SetupIntent is the SetupIntent resource from Stripe. Embedded in this struct
+// is the stripe.SetupIntent struct from Stripe.
+type SetupIntent struct {
+	*stripe.SetupIntent
+}
+
+// ErrSetupIntent represents a SetupIntent with an invalid status. This will
+// contain the ID of the original SetupIntent, and the status that caused the
+// error in the first place.
+type ErrSetupIntent struct {
+	ID     string
+	Status stripe.SetupIntentStatus
+}
+
+var (
+	_ Resource = (*SetupIntent)(nil)
+
+	setupIntentEndpoint = "/v1/setup_intents"
+
+	// ErrSetupIntentCustomer denotes when a SetupIntent does not belong to the
+	// Customer it is being completed for.
+	ErrSetupIntentCustomer = errors.New("setup intent belongs to another customer")
+)
+
+// CreateSetupIntent will create a new SetupIntent in Stripe with the given
+// request Params.
+func CreateSetupIntent(s *Stripe, params Params) (*SetupIntent, error) {
+	si := &SetupIntent{}
+
+	err := s.post(setupIntentEndpoint, params, &si.SetupIntent)
+	return si, err
+}
+
+// RetrieveSetupIntent will get the SetupIntent of the given ID from Stripe and
+// return it.
+func RetrieveSetupIntent(s *Stripe, id string) (*SetupIntent, error) {
+	si := &SetupIntent{
+		SetupIntent: &stripe.SetupIntent{
+			ID: id,
+		},
+	}
+
+	err := si.Load(s)
+	return si, err
+}
+
+func (e ErrSetupIntent) Error() string { return string(e.Status) }
+
+// Kind implements the Resource interface.
+func (si *SetupIntent) Kind() string { return "setup_intent" }
+
+// Endpoint implements the Resource interface.
+func (si *SetupIntent) Endpoint(uris ...string) string {
+	endpoint := setupIntentEndpoint
+
+	if si.ID != "" {
+		endpoint += "/" + si.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Load implements the Resource interface.
+func (si *SetupIntent) Load(s *Stripe) error { return si.LoadExpanded(s) }
+
+// LoadExpanded will load in the SetupIntent from the Stripe API, expanding the
+// objects at the given paths in the response.
+func (si *SetupIntent) LoadExpanded(s *Stripe, expand ...string) error {
+	return s.get(si.Endpoint(), &si.SetupIntent, expand...)
+}
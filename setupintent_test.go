@@ -0,0 +1,127 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_SaveCardForLater(t *testing.T) {
+	var form url.Values
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/setup_intents") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+		form = r.PostForm
+
+		w.Write([]byte(`{"id": "seti_123456", "client_secret": "seti_123456_secret", "status": "requires_payment_method"}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+	}
+
+	si, err := s.SaveCardForLater(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if si.ClientSecret != "seti_123456_secret" {
+		t.Fatalf("unexpected client secret, expected=%q, got=%q\n", "seti_123456_secret", si.ClientSecret)
+	}
+
+	expected := map[string]string{
+		"customer":                "cus_123456",
+		"payment_method_types[0]": "card",
+		"usage":                   "off_session",
+	}
+
+	for k, v := range expected {
+		if form.Get(k) != v {
+			t.Errorf("unexpected %s, expected=%q, got=%q\n", k, v, form.Get(k))
+		}
+	}
+}
+
+func Test_CompleteCardSetup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/setup_intents/seti_123456"):
+			w.Write([]byte(`{"id": "seti_123456", "customer": "cus_123456", "status": "succeeded", "payment_method": "pm_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/setup_intents/seti_654321"):
+			w.Write([]byte(`{"id": "seti_654321", "customer": "cus_123456", "status": "requires_action", "payment_method": "pm_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/setup_intents/seti_abcdef"):
+			w.Write([]byte(`{"id": "seti_abcdef", "customer": "cus_654321", "status": "succeeded", "payment_method": "pm_654321"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456"):
+			w.Write([]byte(`{"id": "pm_123456", "type": "card", "customer": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			r.ParseForm()
+
+			if pm := r.PostForm.Get("invoice_settings[default_payment_method]"); pm != "pm_123456" {
+				t.Errorf("unexpected default payment method, expected=%q, got=%q\n", "pm_123456", pm)
+			}
+			w.Write([]byte(`{"id": "cus_123456", "email": "me@example.com"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		id  string
+		err error
+	}{
+		{"seti_123456", nil},
+		{"seti_654321", ErrSetupIntent{ID: "seti_654321", Status: stripe.SetupIntentStatusRequiresAction}},
+		{"seti_abcdef", ErrSetupIntentCustomer},
+	}
+
+	for i, test := range tests {
+		store := newTestStore()
+
+		s := New("sk_test_123456", store)
+		s.endpoint = srv.URL
+
+		c := &Customer{
+			Customer: &stripe.Customer{ID: "cus_123456", Email: "me@example.com"},
+		}
+
+		pm, err := s.CompleteCardSetup(c, test.id)
+
+		if err != test.err {
+			t.Fatalf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.err, err)
+		}
+
+		pms, _ := store.PaymentMethods(c)
+
+		if test.err != nil {
+			if len(pms) != 0 {
+				t.Fatalf("tests[%d] - unexpected payment methods, expected=%d, got=%d\n", i, 0, len(pms))
+			}
+			continue
+		}
+
+		if pm.ID != "pm_123456" || !pm.Default {
+			t.Fatalf("tests[%d] - expected %q to be the default payment method\n", i, "pm_123456")
+		}
+
+		if len(pms) != 1 {
+			t.Fatalf("tests[%d] - unexpected payment methods, expected=%d, got=%d\n", i, 1, len(pms))
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package stripeutil
 
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
 type TestStore struct {
 	customers      map[string]*Customer
 	invoices       map[string][]*Invoice
@@ -19,8 +27,12 @@ func newTestStore() TestStore {
 }
 
 func (s TestStore) LookupCustomer(email string) (*Customer, bool, error) {
-	c, ok := s.customers[email]
-	return c, ok, nil
+	for _, c := range s.customers {
+		if c.Email == email {
+			return c, true, nil
+		}
+	}
+	return nil, false, nil
 }
 
 func (s TestStore) LookupInvoice(c *Customer, number string) (*Invoice, bool, error) {
@@ -39,6 +51,32 @@ func (s TestStore) Subscription(c *Customer) (*Subscription, bool, error) {
 	return sub, ok, nil
 }
 
+func (s TestStore) ActiveSubscriptions(limit, offset int) ([]*Subscription, error) {
+	subs := make([]*Subscription, 0)
+
+	for _, sub := range s.subscriptions {
+		switch sub.Status {
+		case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+			subs = append(subs, sub)
+		}
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].StartDate > subs[j].StartDate
+	})
+
+	if offset >= len(subs) {
+		return nil, nil
+	}
+
+	subs = subs[offset:]
+
+	if limit < len(subs) {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
 func (s TestStore) DefaultPaymentMethod(c *Customer) (*PaymentMethod, bool, error) {
 	for _, pm := range s.paymentMethods[c.ID] {
 		if pm.Default {
@@ -52,14 +90,50 @@ func (s TestStore) PaymentMethods(c *Customer) ([]*PaymentMethod, error) {
 	return s.paymentMethods[c.ID], nil
 }
 
+func (s TestStore) ExpiringCards(before time.Time) ([]*PaymentMethod, error) {
+	pms := make([]*PaymentMethod, 0)
+
+	for _, cpms := range s.paymentMethods {
+		for _, pm := range cpms {
+			if pm.Type != "card" {
+				continue
+			}
+
+			expires := time.Date(int(pm.Card.ExpYear), time.Month(pm.Card.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+
+			if !expires.After(before) {
+				pms = append(pms, pm)
+			}
+		}
+	}
+	return pms, nil
+}
+
 func (s TestStore) Invoices(c *Customer) ([]*Invoice, error) {
 	return s.invoices[c.ID], nil
 }
 
+func (s TestStore) InvoicesByStatus(status string) ([]*Invoice, error) {
+	invs := make([]*Invoice, 0)
+
+	for _, cinvs := range s.invoices {
+		for _, inv := range cinvs {
+			if string(inv.Status) == status {
+				invs = append(invs, inv)
+			}
+		}
+	}
+
+	sort.Slice(invs, func(i, j int) bool {
+		return invs[i].Created > invs[j].Created
+	})
+	return invs, nil
+}
+
 func (s TestStore) Put(r Resource) error {
 	switch v := r.(type) {
 	case *Customer:
-		s.customers[v.Email] = v
+		s.customers[v.ID] = v
 	case *Invoice:
 		s.invoices[v.Customer.ID] = append(s.invoices[v.Customer.ID], v)
 	case *Subscription:
@@ -74,3 +148,65 @@ func (s TestStore) LogEvent(_ string) error { return nil }
 
 // Remove is no-op for now.
 func (s TestStore) Remove(_ Resource) error { return nil }
+
+func Test_StoreCustomerEmailChange(t *testing.T) {
+	stores := []Store{
+		newTestStore(),
+		NewMemoryStore(),
+	}
+
+	for i, store := range stores {
+		c := &Customer{
+			Customer: &stripe.Customer{ID: "cus_123456", Email: "old@example.com"},
+		}
+
+		if err := store.Put(c); err != nil {
+			t.Fatalf("stores[%d] - unexpected error: %s\n", i, err)
+		}
+
+		c = &Customer{
+			Customer: &stripe.Customer{ID: "cus_123456", Email: "new@example.com"},
+		}
+
+		if err := store.Put(c); err != nil {
+			t.Fatalf("stores[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if _, ok, _ := store.LookupCustomer("old@example.com"); ok {
+			t.Errorf("stores[%d] - expected customer to not be found by old email\n", i)
+		}
+
+		found, ok, err := store.LookupCustomer("new@example.com")
+
+		if err != nil {
+			t.Fatalf("stores[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if !ok || found.ID != "cus_123456" {
+			t.Errorf("stores[%d] - expected customer to be found by new email\n", i)
+		}
+	}
+}
+
+// copyStore is a TestStore that returns a copy of each Subscription looked up
+// from it, and counts the resources put in it, so changes made to a returned
+// Subscription are not reflected in the store until it is put back.
+type copyStore struct {
+	TestStore
+
+	puts int
+}
+
+func (s *copyStore) Subscription(c *Customer) (*Subscription, bool, error) {
+	sub, ok, err := s.TestStore.Subscription(c)
+
+	if !ok || err != nil {
+		return sub, ok, err
+	}
+	return sub.copy(), true, nil
+}
+
+func (s *copyStore) Put(r Resource) error {
+	s.puts++
+	return s.TestStore.Put(r)
+}
@@ -1,16 +1,22 @@
 package stripeutil
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"reflect"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/stripe/stripe-go/v72"
 )
@@ -24,24 +30,38 @@ type Client struct {
 
 	secret   string
 	endpoint string
+	uploads  string
 	version  string
+	log      Logger
+	metrics  Metrics
+	strict   bool
 }
 
+// Error is the error returned from the Stripe API when a request does not
+// succeed. Status is the textual status of the response, and StatusCode is the
+// numeric status code. If the request failed because of a PaymentIntent, such
+// as when confirming a PaymentIntent off-session, then the PaymentIntent will
+// be set on the error.
 type Error struct {
-	Status string `json:"-"`
-	Err    struct {
-		Code    string
-		Message string
-		Type    string
+	Status     string `json:"-"`
+	StatusCode int    `json:"-"`
+	Err        struct {
+		Code          string
+		Message       string
+		Type          string
+		PaymentIntent *stripe.PaymentIntent `json:"payment_intent"`
 	} `json:"error"`
 }
 
 // ErrPaymentIntent represents a PaymentIntent with an invalid status. This
 // will contain the ID of the original PaymentIntent, and the status that
-// caused the error in the first place.
+// caused the error in the first place. If the PaymentIntent requires further
+// action from the Customer, such as 3D Secure authentication, then the client
+// secret of the PaymentIntent is set so the action can be handled client side.
 type ErrPaymentIntent struct {
-	ID     string
-	Status stripe.PaymentIntentStatus
+	ID           string
+	Status       stripe.PaymentIntentStatus
+	ClientSecret string
 }
 
 // Resource represents a resource that has been retrieved by Stripe.
@@ -56,6 +76,11 @@ type Resource interface {
 	// Stripe API using the Resource's endpoint. This should overwrite the
 	// fields in the Resource with the decoded response from Stripe.
 	Load(s *Stripe) error
+
+	// Kind returns the kind of the Resource, this would be the name of the
+	// object in Stripe, for example "customer". This can be used by a Store
+	// for determining where the Resource should be stored.
+	Kind() string
 }
 
 // Store provides an interface for storing and retrieving resources that have
@@ -81,6 +106,12 @@ type Store interface {
 	// value.
 	Subscription(c *Customer) (*Subscription, bool, error)
 
+	// ActiveSubscriptions returns the active subscriptions across all
+	// customers. A subscription is active if its status is either "active" or
+	// "trialing". The given limit and offset are used for paginating through
+	// the subscriptions.
+	ActiveSubscriptions(limit, offset int) ([]*Subscription, error)
+
 	// DefaultPaymentMethod returns the default payment method for the given
 	// Customer. Whether or not the Customer has a default payment method is
 	// denoted by the returned bool value.
@@ -90,15 +121,28 @@ type Store interface {
 	// invoices should be sorted from newest to oldest.
 	Invoices(c *Customer) ([]*Invoice, error)
 
+	// InvoicesByStatus returns all of the invoices across all customers with
+	// the given status, such as "open". The returned invoices should be sorted
+	// from newest to oldest.
+	InvoicesByStatus(status string) ([]*Invoice, error)
+
 	// PaymentMethods returns all of the payment methods that has been attached
 	// to the given Customer.
 	PaymentMethods(c *Customer) ([]*PaymentMethod, error)
 
+	// ExpiringCards returns all of the card payment methods that will have
+	// expired by the given time. A card is considered expired once the month
+	// of its expiry has passed.
+	ExpiringCards(before time.Time) ([]*PaymentMethod, error)
+
 	// Put will put the given Resource into the underlying data store. If the
 	// given Resource already exists in the data store, then that should simply
-	// be updated. If the given Resource is the PaymentMethod resource, then a
-	// check should be done to ensure that only one PaymentMethod for a Customer
-	// is the default PaymentMethod.
+	// be updated. Resources should be identified by their ID, so if the email
+	// of a Customer has changed, then the email of the existing Customer
+	// should be updated, rather than a new Customer being stored. If the
+	// given Resource is the PaymentMethod resource, then a check should be
+	// done to ensure that only one PaymentMethod for a Customer is the default
+	// PaymentMethod.
 	Put(r Resource) error
 
 	// Remove will remove the given Resource from the underlying data store. If
@@ -106,11 +150,58 @@ type Store interface {
 	Remove(r Resource) error
 }
 
+// EventStore is an optional interface a Store can implement for storing the
+// payloads of the events received by a HookHandler. The stored events can then
+// be retrieved for replaying via HookHandler.Replay.
+type EventStore interface {
+	// LogEventPayload will log the given event ID, along with the raw payload
+	// of the event. Like LogEvent, if the event already exists then
+	// ErrEventExists should be returned.
+	LogEventPayload(id string, payload []byte) error
+
+	// Event returns the event of the given ID, decoded from its stored
+	// payload. Whether or not the event could be found is denoted by the
+	// returned bool value.
+	Event(id string) (stripe.Event, bool, error)
+}
+
 // Stripe provides a simple way of managing the flow of creating customers and
-// subscriptions, and for storing them in a data store.
+// subscriptions, and for storing them in a data store. All of the transport
+// concerns of talking to the Stripe API are handled by the embedded Client,
+// Stripe only adds the semantics of storing the resources retrieved.
 type Stripe struct {
 	*Client
 	Store
+
+	subch func(old, new *Subscription)
+	custs keyMutex
+}
+
+// keyMutex provides a mutex for each key that is locked, this is used for
+// serializing operations against a single key, such as a Customer's email.
+type keyMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+
+	refs int
+}
+
+// listPage is a single page of objects returned from a list endpoint of the
+// Stripe API.
+type listPage struct {
+	Data    []json.RawMessage `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
+
+// rawDecoder is implemented by the resources that keep the raw JSON of the
+// response they were decoded from, so the fields of the response that are not
+// known to stripe-go are not lost.
+type rawDecoder interface {
+	setRaw(b json.RawMessage)
 }
 
 type pair struct {
@@ -128,6 +219,22 @@ var (
 	ErrUnknownResource = errors.New("unknown resource")
 )
 
+// nestedParams returns the Params nested under the given key in the given
+// Params. If there are no Params under the key then new Params are set, and
+// returned.
+func nestedParams(p Params, key string) Params {
+	switch v := p[key].(type) {
+	case Params:
+		return v
+	case map[string]interface{}:
+		return Params(v)
+	}
+
+	nested := Params{}
+	p[key] = nested
+	return nested
+}
+
 // encodeSliceToPairs will encode an arbitrary slice of values into a slice of
 // pairs. It is expected for the given reflect.Value to be a of reflect.Slice.
 // The given key denotes the key in the original parameter set for which the
@@ -144,6 +251,11 @@ func encodeSliceToPairs(key string, val reflect.Value) []pair {
 			pairs = append(pairs, p.encodeToPairs(k)...)
 			continue
 		}
+
+		if val.Index(i).Kind() == reflect.Map {
+			pairs = append(pairs, encodeMapToPairs(k, val.Index(i))...)
+			continue
+		}
 		pairs = append(pairs, pair{
 			key:   k,
 			value: v,
@@ -152,15 +264,212 @@ func encodeSliceToPairs(key string, val reflect.Value) []pair {
 	return pairs
 }
 
+// encodeMapToPairs will encode an arbitrary map of values into a slice of
+// pairs. It is expected for the given reflect.Value to be of reflect.Map. Each
+// pair encoded will have a key of key[k] where key is the passed key argument,
+// and k is the key of the pair's value in the map. This allows for maps such
+// as map[string]string to be used for metadata in place of Params.
+func encodeMapToPairs(key string, val reflect.Value) []pair {
+	p := make(Params)
+
+	iter := val.MapRange()
+
+	for iter.Next() {
+		p[fmt.Sprintf("%v", iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return p.encodeToPairs(key)
+}
+
+// rawJSON returns the given raw JSON if it has been set, otherwise the given
+// value is encoded to JSON and returned. The raw JSON of a resource is set when
+// it is decoded from a response from the Stripe API, so the encoded value
+// would only be returned for a resource that was built by hand, and would be
+// missing any fields that are not known to stripe-go.
+func rawJSON(raw json.RawMessage, v interface{}) (json.RawMessage, error) {
+	if raw != nil {
+		return raw, nil
+	}
+	return json.Marshal(v)
+}
+
+// rawField returns the raw JSON of the object under the given key of the given
+// raw JSON object. This is used for getting the raw JSON of an expanded
+// object. If there is no object under the key then nil is returned.
+func rawField(raw json.RawMessage, key string) json.RawMessage {
+	obj := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil
+	}
+
+	b := obj[key]
+
+	if len(b) == 0 || b[0] != '{' {
+		return nil
+	}
+	return b
+}
+
+// marshalFields encodes the given value to a JSON object, and merges the given
+// fields into that object. This is used for encoding the fields of the
+// embedded stripe-go structs alongside the fields added by this library.
+func marshalFields(v interface{}, fields map[string]interface{}) ([]byte, error) {
+	obj := make(map[string]json.RawMessage)
+
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(b) != "null" {
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range fields {
+		b, err := json.Marshal(v)
+
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = b
+	}
+	return json.Marshal(obj)
+}
+
+// unmarshalFields decodes the given JSON into the given value, and decodes the
+// given fields from the JSON object. A field is only decoded if it is present
+// in the JSON object, so the existing value is kept otherwise.
+func unmarshalFields(b []byte, v interface{}, fields map[string]interface{}) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	obj := make(map[string]json.RawMessage)
+
+	// The JSON may not be an object, for example when a resource is given as
+	// just its ID, in which case there are no fields to decode.
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil
+	}
+
+	for k, v := range fields {
+		raw, ok := obj[k]
+
+		if !ok {
+			continue
+		}
+
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock locks the mutex for the given key, and returns a function for unlocking
+// it. The mutex for the key is discarded once there are no more references to
+// it.
+func (m *keyMutex) lock(key string) func() {
+	m.mu.Lock()
+
+	if m.locks == nil {
+		m.locks = make(map[string]*keyLock)
+	}
+
+	l, ok := m.locks[key]
+
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+
+	m.mu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		l.refs--
+
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
+
+// loadResources concurrently loads each of the given Resources from the Stripe
+// API. Any errors that occur when loading a Resource are passed to the given
+// errh callback. The Resources that were successfully loaded are returned.
+func loadResources(s *Stripe, rs []Resource, errh func(error)) []Resource {
+	sems := make(chan struct{}, runtime.GOMAXPROCS(0)+10)
+	errs := make(chan error)
+	oks := make([]bool, len(rs))
+
+	var wg sync.WaitGroup
+	wg.Add(len(rs))
+
+	for i, r := range rs {
+		go func(i int, r Resource) {
+			sems <- struct{}{}
+			defer func() {
+				<-sems
+				wg.Done()
+			}()
+
+			if err := r.Load(s); err != nil {
+				errs <- err
+				return
+			}
+			oks[i] = true
+		}(i, r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	for e := range errs {
+		errh(e)
+	}
+
+	loaded := make([]Resource, 0, len(rs))
+
+	for i, r := range rs {
+		if oks[i] {
+			loaded = append(loaded, r)
+		}
+	}
+	return loaded
+}
+
 func respCode2xx(code int) bool { return code >= 200 && code < 300 }
 
 // New configures a new Stripe client with the given secret for authenticatio
-// and Store for storing/retrieving resources.
+// and Store for storing/retrieving resources. If the given Store is nil then
+// NopStore is used, and no resources will be stored. The client will use the
+// version of the Stripe API that stripe-go was built against, stripe.APIVersion.
 func New(secret string, s Store) *Stripe {
-	return &Stripe{
-		Store:  s,
-		Client: NewClient(stripe.APIVersion, secret),
-	}
+	return NewVersioned(stripe.APIVersion, secret, s)
+}
+
+// NewVersioned configures a new Stripe client like New, only the given version
+// of the Stripe API will be used instead of stripe.APIVersion. This can be
+// used to pin the version of the Stripe API independently of stripe-go, so
+// stripe-go can be upgraded without also upgrading the version of the Stripe
+// API that is used, for example,
+//
+//     stripe := stripeutil.NewVersioned("2020-08-27", secret, store)
+func NewVersioned(version, secret string, s Store) *Stripe {
+	return NewClient(version, secret).WithStore(s)
 }
 
 // NewClient configures a new Client for interfacing with the Stripe API using
@@ -170,7 +479,181 @@ func NewClient(version, secret string) *Client {
 		Client:   http.DefaultClient,
 		secret:   secret,
 		endpoint: stripe.APIURL,
+		uploads:  stripe.UploadsURL,
 		version:  version,
+		log:      nopLogger{},
+		metrics:  nopMetrics{},
+	}
+}
+
+// WithVersion returns a copy of the current Client that will use the given
+// version of the Stripe API. This can be used for making one-off requests to
+// the Stripe API with a different version, for example,
+//
+//     resp, err := stripe.WithVersion("2006-01-02").Get("/v1/customers")
+func (c Client) WithVersion(version string) Client {
+	c.version = version
+	return c
+}
+
+// Version returns the version of the Stripe API used by the Client.
+func (c Client) Version() string { return c.version }
+
+// SetLogger sets the Logger to use for logging the requests made to the
+// Stripe API. If the Client is embedded in Stripe, then this will also be used
+// for logging the resources put in, and removed from the underlying store.
+func (c *Client) SetLogger(l Logger) { c.log = l }
+
+func (c Client) logger() Logger {
+	if c.log == nil {
+		return nopLogger{}
+	}
+	return c.log
+}
+
+// SetMetrics sets the Metrics to use for instrumenting the requests made to the
+// Stripe API.
+func (c *Client) SetMetrics(m Metrics) { c.metrics = m }
+
+func (c Client) meter() Metrics {
+	if c.metrics == nil {
+		return nopMetrics{}
+	}
+	return c.metrics
+}
+
+// SetStrictDecoding sets whether or not the responses from the Stripe API
+// should be decoded strictly. When strict, decoding a response that contains a
+// field that is not known to the type being decoded into will return an error.
+// This is off by default, and would typically only be turned on in a staging
+// environment, for detecting drift between the version of stripe-go used by
+// this library and the responses sent by Stripe.
+//
+// Most of the types in stripe-go implement their own decoding, so a response
+// is checked by encoding the decoded value back to JSON, and looking for
+// fields in the response that are missing from the encoding. The "object"
+// field is not checked, since Stripe sets it on lists which have no field for
+// it.
+func (c *Client) SetStrictDecoding(strict bool) { c.strict = strict }
+
+// decode decodes the JSON from the given io.Reader into the given value,
+// returning an error on unknown fields if strict decoding is set.
+func (c Client) decode(r io.Reader, v interface{}) error {
+	b, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+	return c.unmarshal(b, v)
+}
+
+// unmarshal decodes the given JSON into the given value, returning an error on
+// unknown fields if strict decoding is set. If the value is a rawDecoder then
+// the given JSON is kept as its raw JSON.
+func (c Client) unmarshal(b []byte, v interface{}) error {
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	if c.strict {
+		if err := checkUnknownFields(b, v); err != nil {
+			return err
+		}
+	}
+
+	if rd, ok := v.(rawDecoder); ok {
+		rd.setRaw(b)
+	}
+	return nil
+}
+
+// checkUnknownFields encodes the given value back to JSON, and returns an error
+// for the first field in the given JSON that is not in the encoding.
+func checkUnknownFields(b []byte, v interface{}) error {
+	enc, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	var got, want interface{}
+
+	if err := json.Unmarshal(b, &got); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(enc, &want); err != nil {
+		return err
+	}
+
+	if field := unknownField(got, want); field != "" {
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return nil
+}
+
+// unknownField returns the path to the first field in got that is not in want.
+// Objects and arrays are only compared if they are present in both, this
+// allows for expandable fields being an ID in one, and an object in the other.
+func unknownField(got, want interface{}) string {
+	switch got := got.(type) {
+	case map[string]interface{}:
+		want, ok := want.(map[string]interface{})
+
+		if !ok {
+			return ""
+		}
+
+		keys := make([]string, 0, len(got))
+
+		for k := range got {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if k == "object" {
+				continue
+			}
+
+			w, ok := want[k]
+
+			if !ok {
+				return k
+			}
+
+			if field := unknownField(got[k], w); field != "" {
+				return k + "." + field
+			}
+		}
+	case []interface{}:
+		want, ok := want.([]interface{})
+
+		if !ok || len(want) != len(got) {
+			return ""
+		}
+
+		for i := range got {
+			if field := unknownField(got[i], want[i]); field != "" {
+				return strconv.Itoa(i) + "." + field
+			}
+		}
+	}
+	return ""
+}
+
+// WithStore returns a new Stripe using the current Client for talking to the
+// Stripe API, and the given Store for storing/retrieving resources. This would
+// be used if the Client has been configured differently to what New provides.
+// If the given Store is nil then NopStore is used.
+func (c *Client) WithStore(s Store) *Stripe {
+	if s == nil {
+		s = NopStore{}
+	}
+
+	return &Stripe{
+		Client: c,
+		Store:  s,
 	}
 }
 
@@ -178,9 +661,58 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("stripeutil/stripe.go: stripe api error %s: %s", e.Status, e.Err.Message)
 }
 
+// IsDeclined returns whether or not the request failed because a card was
+// declined, this is denoted by a 402 Payment Required status.
+func (e *Error) IsDeclined() bool { return e.StatusCode == http.StatusPaymentRequired }
+
+// IsNotFound returns whether or not the request failed because the resource
+// requested could not be found.
+func (e *Error) IsNotFound() bool { return e.StatusCode == http.StatusNotFound }
+
 func (e ErrPaymentIntent) Error() string { return string(e.Status) }
 
-func (p pair) encode() string { return p.key + "=" + url.QueryEscape(fmt.Sprintf("%v", p.value)) }
+// RequiresAction returns whether or not the PaymentIntent requires further
+// action from the Customer before it can complete.
+func (e ErrPaymentIntent) RequiresAction() bool {
+	return e.Status == stripe.PaymentIntentStatusRequiresAction
+}
+
+func (p pair) encode() string { return p.key + "=" + url.QueryEscape(formatValue(p.value)) }
+
+// formatValue formats the given value of a pair into a string. Integers are
+// formatted exactly, and floats are formatted without an exponent, so a large
+// amount such as 10000000000 is never encoded as 1e+10. Amounts in Stripe are
+// in the smallest unit of a currency, so integers should be used for them.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
 
 func (p Params) encodeToPairs(parent string) []pair {
 	pairs := make([]pair, 0)
@@ -195,8 +727,12 @@ func (p Params) encodeToPairs(parent string) []pair {
 			continue
 		}
 
-		if reflect.TypeOf(v).Kind() == reflect.Slice {
-			pairs = append(pairs, encodeSliceToPairs(k, reflect.ValueOf(v))...)
+		switch val := reflect.ValueOf(v); val.Kind() {
+		case reflect.Slice:
+			pairs = append(pairs, encodeSliceToPairs(k, val)...)
+			continue
+		case reflect.Map:
+			pairs = append(pairs, encodeMapToPairs(k, val)...)
 			continue
 		}
 		pairs = append(pairs, pair{
@@ -207,17 +743,62 @@ func (p Params) encodeToPairs(parent string) []pair {
 	return pairs
 }
 
+// naturalLess reports whether the string a sorts before the string b, where
+// runs of digits within the strings are compared numerically. This ensures
+// the key items[2] sorts before items[10].
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			starti, startj := i, j
+
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+
+			n := strings.TrimLeft(a[starti:i], "0")
+			m := strings.TrimLeft(b[startj:j], "0")
+
+			if len(n) != len(m) {
+				return len(n) < len(m)
+			}
+			if n != m {
+				return n < m
+			}
+			continue
+		}
+
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	return len(a)-i < len(b)-j
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
 // Encode encodes the current Params into an x-www-form-urlencoded string and
-// returns it.
+// returns it. The encoded pairs are sorted by key, with slice indices sorted
+// numerically.
 func (p Params) Encode() string {
-	pairs := make([]string, 0)
+	pairs := p.encodeToPairs("")
 
-	for _, pair := range p.encodeToPairs("") {
-		pairs = append(pairs, pair.encode())
-	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return naturalLess(pairs[i].key, pairs[j].key)
+	})
+
+	encoded := make([]string, 0, len(pairs))
 
-	sort.Strings(pairs)
-	return strings.Join(pairs, "&")
+	for _, pair := range pairs {
+		encoded = append(encoded, pair.encode())
+	}
+	return strings.Join(encoded, "&")
 }
 
 // Reader returns an io.Reader for the x-www-form-urlencoded string of the
@@ -225,30 +806,52 @@ func (p Params) Encode() string {
 func (p Params) Reader() io.Reader { return strings.NewReader(p.Encode()) }
 
 func (c Client) do(method, uri string, r io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, c.endpoint+"/"+uri, r)
-
-	if err != nil {
-		return nil, err
-	}
-
 	contentType := map[string]string{
 		"POST":   "application/x-www-form-urlencoded",
 		"GET":    "application/json; charset=utf-8",
 		"DELETE": "application/json; charset=utf-8",
 	}
+	return c.send(c.endpoint, method, uri, contentType[method], r)
+}
+
+// send will send a request to the given URI at the given endpoint with the
+// given content type.
+func (c Client) send(endpoint, method, uri, contentType string, r io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint+"/"+uri, r)
+
+	if err != nil {
+		return nil, err
+	}
 
 	req.Header.Set("Authorization", "Bearer "+c.secret)
-	req.Header.Set("Content-Type", contentType[method])
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Stripe-Version", c.version)
 
-	return c.Do(req)
+	log := c.logger()
+	log.Debugf("%s %s", method, uri)
+
+	label := metricsURI(uri)
+	start := time.Now()
+
+	resp, err := c.Do(req)
+
+	if err != nil {
+		c.meter().ObserveRequest(method, label, 0, time.Since(start))
+		log.Errorf("%s %s: %s", method, uri, err)
+		return nil, err
+	}
+
+	c.meter().ObserveRequest(method, label, resp.StatusCode, time.Since(start))
+	log.Debugf("%s %s: %s", method, uri, resp.Status)
+	return resp, nil
 }
 
 // Error decodes an error from the Stripe API from the given http.Response and
 // returns it as a pointer to Error.
 func (c Client) Error(resp *http.Response) error {
 	e := &Error{
-		Status: resp.Status,
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
@@ -262,91 +865,634 @@ func (c Client) Get(uri string) (*http.Response, error) {
 	return c.do("GET", uri, nil)
 }
 
-// Post will send a POST request to the given URI of the Stripe API, along with
-// the given io.Reader as the request body.
-func (c Client) Post(uri string, r io.Reader) (*http.Response, error) {
-	return c.do("POST", uri, r)
-}
+// list will page through every object at the given list URI of the Stripe API,
+// passing the raw JSON of each object to the given callback. Each page is
+// requested with a limit of 100, unless the given URI already sets the limit.
+func (c Client) list(uri string, fn func(json.RawMessage) error) error {
+	if u, err := url.Parse(uri); err != nil || u.Query().Get("limit") == "" {
+		sep := "?"
 
-// Delete will send a DELETE request to the given URI of the Stripe API.
-func (c Client) Delete(uri string) (*http.Response, error) {
-	return c.do("DELETE", uri, nil)
-}
+		if strings.Contains(uri, "?") {
+			sep = "&"
+		}
+		uri += sep + "limit=100"
+	}
 
-// Post will send a POST request to the given URI of the Stripe API.
-func (s *Stripe) Post(uri string, params Params) (*http.Response, error) {
-	return s.Client.Post(uri, params.Reader())
-}
+	after := ""
 
-// Customer will get the Stripe customer by the given email. If a customer does
-// not exist in the underlying data store then one is created via Stripe and
-// subsequently stored in the underlying data store.
-func (s *Stripe) Customer(email string) (*Customer, error) {
-	c, ok, err := s.Store.LookupCustomer(email)
+	for {
+		page := uri
 
-	if err != nil {
-		return c, err
-	}
+		if after != "" {
+			page += "&starting_after=" + url.QueryEscape(after)
+		}
 
-	if !ok {
-		resp, err := s.Post(customerEndpoint, Params{"email": email})
+		resp, err := c.Get(page)
 
 		if err != nil {
-			return c, err
+			return err
 		}
 
-		defer resp.Body.Close()
-
 		if !respCode2xx(resp.StatusCode) {
-			return c, s.Error(resp)
+			defer resp.Body.Close()
+			return c.Error(resp)
 		}
 
-		c = &Customer{
-			Customer: &stripe.Customer{},
+		var lp listPage
+
+		err = json.NewDecoder(resp.Body).Decode(&lp)
+		resp.Body.Close()
+
+		if err != nil {
+			return err
 		}
 
-		if err := json.NewDecoder(resp.Body).Decode(&c.Customer); err != nil {
-			return c, err
+		for _, raw := range lp.Data {
+			var obj struct {
+				ID string `json:"id"`
+			}
+
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return err
+			}
+
+			if err := fn(raw); err != nil {
+				return err
+			}
+			after = obj.ID
 		}
 
-		if err := s.Store.Put(c); err != nil {
-			return c, err
+		if !lp.HasMore || len(lp.Data) == 0 {
+			break
 		}
 	}
-	return c, err
+	return nil
 }
 
-// Subscribe creates a new subscription for the given Customer using the given
-// PaymentMethod. The given Params will be passed through directly to the
-// request that creates the Subscription in Stripe. The given PaymentMethod and
-// returned Subscription will be stored in the underlying data store. If the
-// payment for the Subscription fails then this will be returned via
-// ErrPaymentIntent.
-func (s *Stripe) Subscribe(c *Customer, pm *PaymentMethod, params Params) (*Subscription, error) {
-	sub, ok, err := s.Subscription(c)
+// Post will send a POST request to the given URI of the Stripe API, along with
+// the given io.Reader as the request body.
+func (c Client) Post(uri string, r io.Reader) (*http.Response, error) {
+	return c.do("POST", uri, r)
+}
 
-	if err != nil {
-		return sub, err
-	}
+// PostRaw will send a POST request to the given URI of the Stripe API, with the
+// contents of the given io.Reader as the body of the request. The given content
+// type is used for the request, instead of the form encoding used by Post. This
+// can be used for endpoints that are not covered by the rest of the Client.
+func (c Client) PostRaw(uri, contentType string, r io.Reader) (*http.Response, error) {
+	return c.send(c.endpoint, "POST", uri, contentType, r)
+}
 
-	if err := pm.Attach(s, c); err != nil {
-		return sub, err
-	}
+// Upload will upload the contents of the given io.Reader as a file to the given
+// URI of the Stripe API. The file is sent as multipart/form-data in the form
+// field of the given name, with the given file name. The purpose of the file,
+// such as "dispute_evidence", is sent alongside it. Files are uploaded to a
+// different host than the rest of the Stripe API, for example,
+//
+//     resp, err := stripe.Upload("/v1/files", "file", "receipt.pdf", f, "dispute_evidence")
+func (c Client) Upload(uri, fieldName, fileName string, r io.Reader, purpose string) (*http.Response, error) {
+	var buf bytes.Buffer
 
-	err = c.Update(s, Params{
+	w := multipart.NewWriter(&buf)
+
+	if err := w.WriteField("purpose", purpose); err != nil {
+		return nil, err
+	}
+
+	part, err := w.CreateFormFile(fieldName, fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return c.send(c.uploads, "POST", uri, w.FormDataContentType(), &buf)
+}
+
+// Delete will send a DELETE request to the given URI of the Stripe API.
+func (c Client) Delete(uri string) (*http.Response, error) {
+	return c.do("DELETE", uri, nil)
+}
+
+// get will send a GET request to the given URI of the Stripe API, and decode
+// the response into the given value. The given expand paths will be added to
+// the query string of the URI. If a non-2xx response is received then the
+// decoded Error is returned.
+func (s *Stripe) get(uri string, v interface{}, expand ...string) error {
+	if len(expand) > 0 {
+		q := make([]string, 0, len(expand))
+
+		for _, path := range expand {
+			q = append(q, "expand[]="+url.QueryEscape(path))
+		}
+
+		sep := "?"
+
+		if strings.Contains(uri, "?") {
+			sep = "&"
+		}
+		uri += sep + strings.Join(q, "&")
+	}
+
+	resp, err := s.Get(uri)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if !respCode2xx(resp.StatusCode) {
+		return s.Error(resp)
+	}
+	return s.decode(resp.Body, v)
+}
+
+// post will send a POST request to the given URI of the Stripe API with the
+// given Params, and decode the response into the given value. If a non-2xx
+// response is received then the decoded Error is returned.
+func (s *Stripe) post(uri string, params Params, v interface{}) error {
+	resp, err := s.Post(uri, params)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if !respCode2xx(resp.StatusCode) {
+		return s.Error(resp)
+	}
+	return s.decode(resp.Body, v)
+}
+
+// Put will put the given Resource into the underlying store.
+func (s *Stripe) Put(r Resource) error {
+	log := s.logger()
+	log.Debugf("put %s", r.Endpoint())
+
+	if err := s.Store.Put(r); err != nil {
+		log.Errorf("put %s: %s", r.Endpoint(), err)
+		return err
+	}
+	return nil
+}
+
+// Remove will remove the given Resource from the underlying store.
+func (s *Stripe) Remove(r Resource) error {
+	log := s.logger()
+	log.Debugf("remove %s", r.Endpoint())
+
+	if err := s.Store.Remove(r); err != nil {
+		log.Errorf("remove %s: %s", r.Endpoint(), err)
+		return err
+	}
+	return nil
+}
+
+// Post will send a POST request to the given URI of the Stripe API.
+func (s *Stripe) Post(uri string, params Params) (*http.Response, error) {
+	return s.Client.Post(uri, params.Reader())
+}
+
+func (s *Stripe) subscriptionChanged(old, new *Subscription) {
+	if s.subch != nil {
+		s.subch(old, new)
+	}
+}
+
+// OnSubscriptionChange registers the given callback to be invoked whenever a
+// Customer's Subscription is changed via Subscribe, Resubscribe, or
+// Unsubscribe. The callback is invoked after the Subscription has been put in
+// the underlying store. The old Subscription will be nil if the Customer did
+// not previously have a Subscription.
+func (s *Stripe) OnSubscriptionChange(fn func(old, new *Subscription)) {
+	s.subch = fn
+}
+
+// Customer will get the Stripe customer by the given email. If a customer does
+// not exist in the underlying data store then one is created via Stripe and
+// subsequently stored in the underlying data store.
+func (s *Stripe) Customer(email string) (*Customer, error) {
+	return s.CustomerWithParams(email, Params{})
+}
+
+// CustomerWithParams will get the Stripe customer by the given email. If a
+// customer does not exist in the underlying data store then one is created via
+// Stripe with the given Params, and subsequently stored in the underlying data
+// store. The given email is set on a copy of the Params that are used for
+// creating the customer, so the given Params are not modified. Concurrent
+// calls for the same email are serialized, so only one customer will be
+// created in Stripe. A customer deleted in Stripe should be removed from the
+// store via Remove, otherwise it will still be returned.
+func (s *Stripe) CustomerWithParams(email string, params Params) (*Customer, error) {
+	unlock := s.custs.lock(email)
+	defer unlock()
+
+	c, ok, err := s.Store.LookupCustomer(email)
+
+	if err != nil {
+		return c, err
+	}
+
+	if !ok {
+		p := Params{}
+
+		for k, v := range params {
+			p[k] = v
+		}
+
+		p["email"] = email
+
+		c, err = CreateCustomer(s, p)
+
+		if err != nil {
+			return c, err
+		}
+
+		if err := s.Put(c); err != nil {
+			return c, err
+		}
+	}
+	return c, err
+}
+
+// CustomerExists will return whether or not a Customer with the given email
+// exists in the underlying data store. Unlike Customer, this will never create
+// the Customer, and does not make any requests to Stripe.
+func (s *Stripe) CustomerExists(email string) (bool, error) {
+	unlock := s.custs.lock(email)
+	defer unlock()
+
+	_, ok, err := s.Store.LookupCustomer(email)
+	return ok, err
+}
+
+// ChangeEmail will change the email of the given Customer to the given email,
+// in both Stripe and the underlying data store. Customers are looked up in the
+// store by their email, so changing the email of a Customer via Customer.Update
+// without also putting the Customer in the store would leave the store stale.
+// If another Customer in the store already has the given email then
+// ErrEmailTaken is returned. If the Customer cannot be put in the store once
+// updated in Stripe, then the email is changed back in Stripe, so the two do
+// not diverge.
+func (s *Stripe) ChangeEmail(c *Customer, email string) error {
+	old := c.Email
+
+	if old == email {
+		return nil
+	}
+
+	// Lock the emails in a consistent order, so concurrent changes between
+	// the same emails do not deadlock.
+	emails := []string{old, email}
+	sort.Strings(emails)
+
+	for _, e := range emails {
+		unlock := s.custs.lock(e)
+		defer unlock()
+	}
+
+	other, ok, err := s.Store.LookupCustomer(email)
+
+	if err != nil {
+		return err
+	}
+
+	if ok && other.ID != c.ID {
+		return ErrEmailTaken
+	}
+
+	if err := c.Update(s, Params{"email": email}); err != nil {
+		return err
+	}
+
+	if err := s.Put(c); err != nil {
+		if rerr := c.Update(s, Params{"email": old}); rerr != nil {
+			s.logger().Errorf("failed to revert email of customer %s: %s", c.ID, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// setDefaultPaymentMethod sets the given PaymentMethod as the default for the
+// given Customer, and stores the PaymentMethod in the underlying data store.
+func (s *Stripe) setDefaultPaymentMethod(c *Customer, pm *PaymentMethod) error {
+	err := c.Update(s, Params{
 		"invoice_settings": Params{
 			"default_payment_method": pm.ID,
 		},
 	})
 
 	if err != nil {
-		return sub, err
+		return err
 	}
 
 	pm.Customer = c.Customer
 	pm.Default = true
 
-	if err := s.Store.Put(pm); err != nil {
+	return s.Put(pm)
+}
+
+// SaveCardForLater creates a new SetupIntent for the given Customer for saving
+// a card to be used in future off-session payments. The returned SetupIntent
+// would be confirmed on the client, after which CompleteCardSetup would be
+// called.
+func (s *Stripe) SaveCardForLater(c *Customer) (*SetupIntent, error) {
+	return CreateSetupIntent(s, Params{
+		"customer":             c.ID,
+		"payment_method_types": []string{"card"},
+		"usage":                "off_session",
+	})
+}
+
+// CompleteCardSetup will retrieve the SetupIntent of the given ID, and set the
+// PaymentMethod of that SetupIntent as the default for the given Customer. The
+// PaymentMethod is stored in the underlying data store, and returned. If the
+// SetupIntent has not succeeded then this will be returned via
+// ErrSetupIntent. If the SetupIntent is not for the given Customer then
+// ErrSetupIntentCustomer is returned, since the ID of the SetupIntent would
+// typically come from the client.
+func (s *Stripe) CompleteCardSetup(c *Customer, id string) (*PaymentMethod, error) {
+	si, err := RetrieveSetupIntent(s, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if si.Customer == nil || si.Customer.ID != c.ID {
+		return nil, ErrSetupIntentCustomer
+	}
+
+	if si.Status != stripe.SetupIntentStatusSucceeded {
+		return nil, ErrSetupIntent{
+			ID:     si.ID,
+			Status: si.Status,
+		}
+	}
+
+	if si.PaymentMethod == nil {
+		return nil, ErrNoPaymentMethod
+	}
+
+	pm, err := RetrievePaymentMethod(s, si.PaymentMethod.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.setDefaultPaymentMethod(c, pm); err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+// SyncInvoices will retrieve all of the invoices for the given Customer from
+// Stripe, and put each of them in the underlying data store. This can be used
+// for backfilling the invoices of a Customer that were created before the
+// Customer was stored.
+func (s *Stripe) SyncInvoices(c *Customer) error {
+	return s.list(invoiceEndpoint+"?customer="+url.QueryEscape(c.ID), func(raw json.RawMessage) error {
+		inv := &Invoice{
+			Invoice: &stripe.Invoice{},
+		}
+
+		if err := s.unmarshal(raw, inv); err != nil {
+			return err
+		}
+
+		if inv.Customer == nil {
+			inv.Customer = c.Customer
+		}
+		return s.Put(inv)
+	})
+}
+
+// RunDunning will retry the payment of the open Invoices in the underlying
+// data store that are past due. An Invoice is past due if Stripe has already
+// attempted to pay it, and its due date, if it has one, has passed. Invoices
+// with the collection method of send_invoice are skipped, since they are paid
+// by the Customer. The Invoices are loaded from Stripe if the store does not
+// have their collection method, and are put back in the store if they are no
+// longer open. Each Invoice is paid using the default PaymentMethod of its
+// Customer, Invoices for Customers without a default PaymentMethod are
+// skipped. Each Invoice that is paid is put back in the underlying data store.
+// Any errors that occur when paying an Invoice are passed to the given errh
+// callback, if not nil, and the remaining Invoices will still be retried. This
+// returns the number of Invoices that were successfully paid, and an error if
+// the Invoices could not be retrieved from the store.
+//
+// This would typically be called periodically, for example,
+//
+//     retried, err := stripe.RunDunning(func(err error) {
+//         log.Println("failed to retry invoice:", err)
+//     })
+func (s *Stripe) RunDunning(errh func(error)) (int, error) {
+	if errh == nil {
+		errh = func(error) {}
+	}
+
+	invs, err := s.InvoicesByStatus(string(stripe.InvoiceStatusOpen))
+
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+
+	for _, inv := range invs {
+		if inv.Customer == nil {
+			continue
+		}
+
+		pm, ok, err := s.DefaultPaymentMethod(&Customer{Customer: inv.Customer})
+
+		if err != nil {
+			errh(err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		due, err := s.invoiceDue(inv)
+
+		if err != nil {
+			errh(err)
+			continue
+		}
+
+		if !due {
+			continue
+		}
+
+		if err := inv.Pay(s, pm); err != nil {
+			errh(err)
+			continue
+		}
+
+		if err := s.Put(inv); err != nil {
+			errh(err)
+			continue
+		}
+		retried++
+	}
+	return retried, nil
+}
+
+// invoiceDue returns whether or not the given open Invoice from the store is
+// past due, and should be paid by RunDunning. The Invoice is loaded from Stripe
+// if its collection method is not known, and put back in the store if it is no
+// longer open.
+func (s *Stripe) invoiceDue(inv *Invoice) (bool, error) {
+	if inv.CollectionMethod == nil {
+		if err := inv.Load(s); err != nil {
+			return false, err
+		}
+
+		if inv.Status != stripe.InvoiceStatusOpen {
+			return false, s.Put(inv)
+		}
+	}
+
+	if inv.CollectionMethod != nil && *inv.CollectionMethod == stripe.InvoiceCollectionMethodSendInvoice {
+		return false, nil
+	}
+
+	if !inv.Attempted {
+		return false, nil
+	}
+	return inv.DueDate == 0 || !time.Unix(inv.DueDate, 0).After(time.Now()), nil
+}
+
+// SubscriptionOrFetch returns the Subscription for the given Customer from the
+// underlying data store. If the Subscription cannot be found in the store, then
+// the latest Subscription for the Customer is retrieved from Stripe, and put in
+// the store before being returned. This can be used for repairing the store
+// when a webhook event for a Subscription was missed.
+func (s *Stripe) SubscriptionOrFetch(c *Customer) (*Subscription, bool, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ok {
+		return sub, true, nil
+	}
+
+	err = s.list(subscriptionEndpoint+"?status=all&customer="+url.QueryEscape(c.ID), func(raw json.RawMessage) error {
+		fetched := &Subscription{
+			Subscription: &stripe.Subscription{},
+		}
+
+		if err := s.unmarshal(raw, fetched); err != nil {
+			return err
+		}
+
+		if sub == nil || fetched.Created > sub.Created {
+			sub = fetched
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if sub == nil {
+		return nil, false, nil
+	}
+
+	if sub.Customer == nil {
+		sub.Customer = c.Customer
+	}
+
+	sub.setEndsAt()
+
+	if err := s.Put(sub); err != nil {
+		return nil, false, err
+	}
+	return sub, true, nil
+}
+
+// SubscribeOption is an option that can be passed to Subscribe for setting
+// the Params of the Subscription being created.
+type SubscribeOption func(Params)
+
+// RequestThreeDSecure returns a SubscribeOption that sets whether 3D Secure
+// should be requested for the card payments of the Subscription. If true then
+// payment_settings.payment_method_options.card.request_three_d_secure is set
+// to "any", which will request 3D Secure authentication for the first payment
+// of the Subscription regardless of Radar rules. Otherwise it is set to
+// "automatic", so 3D Secure is only requested when required, for example,
+//
+//     sub, err := stripe.Subscribe(c, pm, params, stripeutil.RequestThreeDSecure(true))
+func RequestThreeDSecure(request bool) SubscribeOption {
+	return func(params Params) {
+		v := "automatic"
+
+		if request {
+			v = "any"
+		}
+
+		settings := nestedParams(params, "payment_settings")
+		opts := nestedParams(settings, "payment_method_options")
+		card := nestedParams(opts, "card")
+
+		card["request_three_d_secure"] = v
+	}
+}
+
+// Subscribe creates a new subscription for the given Customer using the given
+// PaymentMethod. The given Params will be passed through directly to the
+// request that creates the Subscription in Stripe. The given PaymentMethod and
+// returned Subscription will be stored in the underlying data store. If the
+// payment for the Subscription fails then this will be returned via
+// ErrPaymentIntent. The Subscription is still stored with its status, which
+// would be "incomplete", so it can later be updated once payment completes.
+//
+// If the payment requires further action, such as 3D Secure authentication,
+// then ErrPaymentIntent is returned with the client secret of the
+// PaymentIntent set, this should be passed to Stripe.js to complete the
+// payment. The stored Subscription will be updated once the webhook for the
+// completed payment is received.
+//
+// If the given PaymentMethod is confirmed asynchronously, such as with SEPA or
+// BACS Direct Debit, then the PaymentIntent will be "processing", and the
+// Subscription is returned without an error. The Subscription will remain
+// "incomplete" until the webhook for the succeeded, or failed payment is
+// received.
+//
+// If the Customer already has an incomplete Subscription then the payment of
+// that Subscription's latest Invoice is retried with the given PaymentMethod,
+// instead of creating a new Subscription. This means Subscribe can be safely
+// called again after a failed payment. The payment is not retried if the
+// PaymentIntent of the latest Invoice is still processing.
+//
+// The given SubscribeOptions are applied to the Params before the Subscription
+// is created. These are not applied when the payment of an existing
+// Subscription is retried.
+func (s *Stripe) Subscribe(c *Customer, pm *PaymentMethod, params Params, opts ...SubscribeOption) (*Subscription, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return sub, err
+	}
+
+	if err := pm.Attach(s, c); err != nil {
+		return sub, err
+	}
+
+	if err := s.setDefaultPaymentMethod(c, pm); err != nil {
 		return sub, err
 	}
 
@@ -354,38 +1500,330 @@ func (s *Stripe) Subscribe(c *Customer, pm *PaymentMethod, params Params) (*Subs
 		if sub.Valid() {
 			return sub, nil
 		}
+
+		// The initial payment for the existing Subscription failed, so retry
+		// the payment instead of creating another Subscription.
+		if sub.Pending() {
+			return s.retrySubscription(sub, pm)
+		}
+	}
+
+	for _, opt := range opts {
+		opt(params)
 	}
 
 	params["customer"] = c.ID
 	params["expand"] = []string{"latest_invoice.payment_intent"}
 
+	old := sub
+
 	sub, err = CreateSubscription(s, params)
 
 	if err != nil {
 		return sub, err
 	}
+	return s.putSubscription(old, sub)
+}
+
+// retrySubscription will retry the payment of the latest Invoice of the given
+// incomplete Subscription with the given PaymentMethod. If the payment of the
+// latest Invoice is still processing then it is not retried, since a delayed
+// notification PaymentMethod may have been used for it.
+func (s *Stripe) retrySubscription(sub *Subscription, pm *PaymentMethod) (*Subscription, error) {
+	old := sub.copy()
 
+	if err := sub.LoadExpanded(s, "latest_invoice.payment_intent"); err != nil {
+		return sub, err
+	}
+
+	if sub.Pending() && !paymentProcessing(sub.LatestInvoice) {
+		inv := &Invoice{
+			Invoice: sub.LatestInvoice,
+		}
+
+		if err := inv.Pay(s, pm); err != nil {
+			// A failed payment will be reported via the status of the
+			// PaymentIntent once the Subscription is reloaded, so only
+			// return if the error did not come from Stripe.
+			if _, ok := err.(*Error); !ok {
+				return sub, err
+			}
+		}
+	}
+
+	if err := sub.LoadExpanded(s, "latest_invoice.payment_intent"); err != nil {
+		return sub, err
+	}
+	return s.putSubscription(old, sub)
+}
+
+// paymentProcessing returns whether or not the payment for the given
+// stripe.Invoice is processing.
+func paymentProcessing(inv *stripe.Invoice) bool {
+	if inv == nil || inv.PaymentIntent == nil {
+		return false
+	}
+	return inv.PaymentIntent.Status == stripe.PaymentIntentStatusProcessing
+}
+
+// putSubscription will store the given Subscription and its latest Invoice,
+// returning ErrPaymentIntent if the payment for the latest Invoice did not
+// succeed. The given old Subscription is passed to the subscription change
+// callback.
+func (s *Stripe) putSubscription(old, sub *Subscription) (*Subscription, error) {
 	statuses := map[stripe.PaymentIntentStatus]struct{}{
 		stripe.PaymentIntentStatusProcessing: {},
 		stripe.PaymentIntentStatusSucceeded:  {},
-		// Part of 3DS, so continue, and let them handle the rest of the flow.
-		stripe.PaymentIntentStatusRequiresAction: {},
+	}
+
+	if err := s.storeSubscription(old, sub); err != nil {
+		return sub, err
 	}
 
 	if _, ok := statuses[sub.LatestInvoice.PaymentIntent.Status]; ok {
-		if err := s.Store.Put(sub); err != nil {
-			return sub, err
-		}
+		return sub, nil
+	}
+	return sub, ErrPaymentIntent{
+		ID:           sub.LatestInvoice.ID,
+		Status:       sub.LatestInvoice.PaymentIntent.Status,
+		ClientSecret: sub.LatestInvoice.PaymentIntent.ClientSecret,
+	}
+}
 
-		err = s.Store.Put(&Invoice{
+// storeSubscription will store the given Subscription and its latest Invoice,
+// if it has one. The given old Subscription is passed to the subscription
+// change callback.
+func (s *Stripe) storeSubscription(old, sub *Subscription) error {
+	if err := s.Put(sub); err != nil {
+		return err
+	}
+
+	if sub.LatestInvoice != nil {
+		err := s.Put(&Invoice{
 			Invoice: sub.LatestInvoice,
+			raw:     rawField(sub.raw, "latest_invoice"),
 		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	s.subscriptionChanged(old, sub)
+	return nil
+}
+
+// SubscribeIncomplete creates a new subscription for the given Customer that
+// is to be paid for by the Customer confirming the payment on the client. The
+// given Params will be passed through directly to the request that creates the
+// Subscription in Stripe, with payment_behavior set to "default_incomplete".
+// This returns the Subscription along with the client secret that should be
+// passed to Stripe.js for confirming the payment. The Subscription, and its
+// latest Invoice are stored in the underlying data store. Unlike Subscribe, an
+// "incomplete" Subscription is not treated as an error.
+//
+// The client secret is of the PaymentIntent of the Subscription's latest
+// Invoice. If no payment is due, for example if the Subscription has a trial,
+// then the client secret is of the pending SetupIntent of the Subscription
+// instead, which would be used for collecting a PaymentMethod.
+//
+// If the Customer already has a valid Subscription then it is returned with an
+// empty client secret. If the Customer already has an incomplete Subscription
+// then that Subscription is reloaded from Stripe, and returned with its client
+// secret, instead of creating another Subscription. Once the payment has been
+// confirmed on the client, FinalizeSubscription can be called.
+func (s *Stripe) SubscribeIncomplete(c *Customer, params Params) (*Subscription, string, error) {
+	expand := []string{"latest_invoice.payment_intent", "pending_setup_intent"}
+
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return sub, "", err
+	}
+
+	if ok {
+		if sub.Valid() {
+			return sub, "", nil
+		}
+
+		if sub.Pending() {
+			old := sub.copy()
+
+			if err := sub.LoadExpanded(s, expand...); err != nil {
+				return sub, "", err
+			}
+
+			if err := s.storeSubscription(old, sub); err != nil {
+				return sub, "", err
+			}
+			return sub, subscriptionClientSecret(sub), nil
+		}
+	}
+
+	params["customer"] = c.ID
+	params["payment_behavior"] = "default_incomplete"
+	params["expand"] = expand
+
+	old := sub
+
+	sub, err = CreateSubscription(s, params)
+
+	if err != nil {
+		return sub, "", err
+	}
+
+	if err := s.storeSubscription(old, sub); err != nil {
+		return sub, "", err
+	}
+	return sub, subscriptionClientSecret(sub), nil
+}
+
+// FinalizeSubscription will reload the given Customer's incomplete
+// Subscription from Stripe, this would be called once the payment for a
+// Subscription created via SubscribeIncomplete has been confirmed on the
+// client. If the status of the Subscription has changed, for example to
+// "active", then the Subscription, and its latest Invoice are stored in the
+// underlying data store. The reloaded Subscription is returned, which would
+// still be "incomplete" if the payment has not yet completed. If the Customer
+// does not have a Subscription then nil is returned, and if the Subscription
+// is not incomplete then it is returned as is from the store.
+//
+// This can be used for finalizing a Subscription synchronously, without
+// waiting for the webhook for the completed payment to be received.
+func (s *Stripe) FinalizeSubscription(c *Customer) (*Subscription, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	if !sub.Pending() {
+		return sub, nil
+	}
+
+	old := sub.copy()
+
+	if err := sub.LoadExpanded(s, "latest_invoice.payment_intent"); err != nil {
 		return sub, err
 	}
-	return sub, ErrPaymentIntent{
-		ID:     sub.LatestInvoice.ID,
-		Status: sub.LatestInvoice.PaymentIntent.Status,
+
+	if sub.Status == old.Status {
+		return sub, nil
+	}
+
+	if err := s.storeSubscription(old, sub); err != nil {
+		return sub, err
+	}
+	return sub, nil
+}
+
+// subscriptionClientSecret returns the client secret of the PaymentIntent of
+// the latest Invoice of the given Subscription, or of its pending SetupIntent
+// if there is no PaymentIntent.
+func subscriptionClientSecret(sub *Subscription) string {
+	if sub.LatestInvoice != nil && sub.LatestInvoice.PaymentIntent != nil {
+		return sub.LatestInvoice.PaymentIntent.ClientSecret
+	}
+
+	if sub.PendingSetupIntent != nil {
+		return sub.PendingSetupIntent.ClientSecret
+	}
+	return ""
+}
+
+// IsSubscribed returns whether or not the given Customer has a Subscription
+// that is valid, or that was cancelled but still lies within the grace period.
+// If the Customer does not have a Subscription then false is returned.
+func (s *Stripe) IsSubscribed(c *Customer) (bool, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+	return sub.Valid() || sub.WithinGrace(), nil
+}
+
+// SubscribedPrice returns the Price the given Customer is subscribed to, along
+// with whether or not the Customer is subscribed. The Price is resolved from
+// the given Prices using the price of the first item in the Customer's
+// Subscription. If the Subscription is not valid, and does not lie within the
+// grace period, then false is returned. If the price of the Subscription
+// cannot be found in the given Prices then ErrUnknownPrice is returned.
+//
+// The items of the Subscription are taken from the store, or from the raw JSON
+// of the Subscription if the store keeps it. Otherwise, such as with PSQL
+// without KeepRaw, the Subscription is loaded from Stripe, so this will make a
+// request to Stripe on every call.
+//
+// This would typically be used for gating features on the plan a Customer is
+// subscribed to,
+//
+//     pr, ok, err := stripe.SubscribedPrice(c, prices)
+//
+//     if err != nil {
+//         // Handle error.
+//     }
+//
+//     if !ok || pr.Product.ID != proProductID {
+//         // Deny access to the feature.
+//     }
+func (s *Stripe) SubscribedPrice(c *Customer, prices *Prices) (*Price, bool, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok || !(sub.Valid() || sub.WithinGrace()) {
+		return nil, false, nil
+	}
+
+	if err := s.subscriptionItems(sub); err != nil {
+		return nil, false, err
+	}
+
+	if sub.Items == nil || len(sub.Items.Data) == 0 || sub.Items.Data[0].Price == nil {
+		return nil, false, nil
+	}
+
+	pr, err := prices.Get(sub.Items.Data[0].Price.ID)
+
+	if err != nil {
+		return nil, false, err
 	}
+	return pr, true, nil
+}
+
+// subscriptionItems ensures the items of the given Subscription retrieved from
+// the store are set, decoding them from the raw JSON of the Subscription if
+// present, otherwise loading the Subscription from Stripe.
+func (s *Stripe) subscriptionItems(sub *Subscription) error {
+	if sub.Items != nil && len(sub.Items.Data) > 0 {
+		return nil
+	}
+
+	if sub.raw != nil {
+		stored := &stripe.Subscription{}
+
+		if err := json.Unmarshal(sub.raw, stored); err != nil {
+			return err
+		}
+
+		if stored.Items != nil && len(stored.Items.Data) > 0 {
+			sub.Items = stored.Items
+			sub.Subscription.Discount = stored.Discount
+			return nil
+		}
+	}
+	return sub.Load(s)
 }
 
 // Resubscribe will reactivate the given Customer's Subscription, if that
@@ -405,10 +1843,18 @@ func (s *Stripe) Resubscribe(c *Customer) error {
 		return nil
 	}
 
+	old := *sub
+
 	if err := sub.Reactivate(s); err != nil {
 		return err
 	}
-	return s.Put(sub)
+
+	if err := s.Put(sub); err != nil {
+		return err
+	}
+
+	s.subscriptionChanged(&old, sub)
+	return nil
 }
 
 // Unsubscribe will cancel the subscription for the given Customer if that
@@ -433,6 +1879,8 @@ func (s *Stripe) Unsubscribe(c *Customer) (*Subscription, error) {
 		return sub, nil
 	}
 
+	old := *sub
+
 	if err := sub.Cancel(s); err != nil {
 		return nil, err
 	}
@@ -440,5 +1888,51 @@ func (s *Stripe) Unsubscribe(c *Customer) (*Subscription, error) {
 	if err := s.Put(sub); err != nil {
 		return nil, err
 	}
+
+	s.subscriptionChanged(&old, sub)
+	return sub, nil
+}
+
+// UnsubscribeAt will cancel the subscription for the given Customer at the
+// given time, if that subscription exists, and is valid. The subscription will
+// be updated in the underlying store. If the given time is invalid then
+// ErrInvalidCancelAt is returned. Like Unsubscribe, if the subscription has
+// already been canceled then it is returned as is.
+func (s *Stripe) UnsubscribeAt(c *Customer, at time.Time) (*Subscription, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	if !sub.Valid() {
+		return nil, nil
+	}
+
+	if sub.EndsAt.Valid {
+		return sub, nil
+	}
+
+	// The stored Subscription will not have the current period, so load it in
+	// from Stripe before canceling.
+	if err := sub.Load(s); err != nil {
+		return nil, err
+	}
+
+	old := *sub
+
+	if err := sub.CancelAt(s, at); err != nil {
+		return nil, err
+	}
+
+	if err := s.Put(sub); err != nil {
+		return nil, err
+	}
+
+	s.subscriptionChanged(&old, sub)
 	return sub, nil
 }
@@ -1,9 +1,16 @@
 package stripeutil
 
 import (
+	"database/sql"
 	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -45,6 +52,34 @@ func Test_Params(t *testing.T) {
 			},
 			"amount=2000&currency=gbp&payment_method_types[0]=card",
 		},
+		{
+			Params{
+				"expand": []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			},
+			"expand[0]=a&expand[1]=b&expand[2]=c&expand[3]=d&expand[4]=e&expand[5]=f&expand[6]=g&expand[7]=h&expand[8]=i&expand[9]=j&expand[10]=k&expand[11]=l",
+		},
+		{
+			Params{
+				"metadata": map[string]string{"plan": "pro", "seats": "5"},
+			},
+			"metadata[plan]=pro&metadata[seats]=5",
+		},
+		{
+			Params{
+				"items": []map[string]interface{}{
+					{"price": "pr_123456", "metadata": map[string]interface{}{"source": "web"}},
+				},
+			},
+			"items[0][metadata][source]=web&items[0][price]=pr_123456",
+		},
+		{
+			Params{
+				"amount":     int64(10000000000),
+				"percentage": 12.5,
+				"tax":        float64(10000000000),
+			},
+			"amount=10000000000&percentage=12.5&tax=10000000000",
+		},
 	}
 
 	for i, test := range tests {
@@ -56,6 +91,47 @@ func Test_Params(t *testing.T) {
 	}
 }
 
+func Test_post(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/cus_404") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "No such customer"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "cus_123456", "email": "me@example.com"}`))
+	}))
+	defer srv.Close()
+
+	stripe := New("sk_test_123456", newTestStore())
+	stripe.endpoint = srv.URL
+
+	c, err := CreateCustomer(stripe, Params{"email": "me@example.com"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.ID != "cus_123456" {
+		t.Errorf("unexpected customer id, expected=%q, got=%q\n", "cus_123456", c.ID)
+	}
+
+	_, err = postCustomer(stripe, "/v1/customers/cus_404", nil)
+
+	stripeErr, ok := err.(*Error)
+
+	if !ok {
+		t.Fatalf("unexpected error, expected=%T, got=%T\n", &Error{}, err)
+	}
+
+	if stripeErr.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status code, expected=%d, got=%d\n", http.StatusNotFound, stripeErr.StatusCode)
+	}
+
+	if !stripeErr.IsNotFound() || stripeErr.IsDeclined() {
+		t.Errorf("expected error to be not found, and not declined\n")
+	}
+}
+
 func Test_Stripe(t *testing.T) {
 	secret := os.Getenv("STRIPE_SECRET")
 	price := os.Getenv("STRIPE_PRICE")
@@ -129,3 +205,395 @@ func Test_Stripe(t *testing.T) {
 		t.Fatal(stripe.Error(resp1))
 	}
 }
+
+func Test_RawResponse(t *testing.T) {
+	body := `{"id": "sub_123456", "status": "active", "unmodelled": true, "latest_invoice": {"id": "in_123456", "customer": "cus_123456", "unmodelled": true}}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripelib.Subscription{ID: "sub_123456"},
+		raw:          []byte(`{"id": "sub_123456"}`),
+	}
+
+	if err := sub.Load(s); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := sub.Raw()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != body {
+		t.Fatalf("unexpected raw json, expected=%q, got=%q\n", body, string(b))
+	}
+
+	sub.Customer = &stripelib.Customer{ID: "cus_123456"}
+
+	if err := s.storeSubscription(nil, sub); err != nil {
+		t.Fatal(err)
+	}
+
+	inv, ok, err := store.LookupInvoice(&Customer{Customer: sub.Customer}, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected latest invoice to be stored")
+	}
+
+	b, err = inv.Raw()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "unmodelled") {
+		t.Fatalf("expected raw json of latest invoice to be kept, got=%q\n", string(b))
+	}
+}
+
+func Test_WithVersion(t *testing.T) {
+	var version string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.Header.Get("Stripe-Version")
+	}))
+	defer srv.Close()
+
+	c := NewClient("2006-01-02", "sk_test_123456")
+	c.endpoint = srv.URL
+
+	if _, err := c.WithVersion("2020-08-27").Get("/v1/customers"); err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "2020-08-27" {
+		t.Errorf("unexpected version, expected=%q, got=%q\n", "2020-08-27", version)
+	}
+
+	if c.version != "2006-01-02" {
+		t.Errorf("unexpected client version, expected=%q, got=%q\n", "2006-01-02", c.version)
+	}
+}
+
+func Test_NewVersioned(t *testing.T) {
+	var version string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.Header.Get("Stripe-Version")
+	}))
+	defer srv.Close()
+
+	if v := New("sk_test_123456", nil).Version(); v != stripelib.APIVersion {
+		t.Errorf("unexpected version, expected=%q, got=%q\n", stripelib.APIVersion, v)
+	}
+
+	s := NewVersioned("2020-08-27", "sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	if v := s.Version(); v != "2020-08-27" {
+		t.Errorf("unexpected version, expected=%q, got=%q\n", "2020-08-27", v)
+	}
+
+	if _, err := s.Get("/v1/customers"); err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "2020-08-27" {
+		t.Errorf("unexpected version, expected=%q, got=%q\n", "2020-08-27", version)
+	}
+}
+
+func Test_StrictDecoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "obj_123456", "unknown": true}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	var obj struct {
+		ID string `json:"id"`
+	}
+
+	if err := s.get("/v1/objects/obj_123456", &obj); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	s.SetStrictDecoding(true)
+
+	if err := s.get("/v1/objects/obj_123456", &obj); err == nil {
+		t.Fatal("expected error for unknown field, got nil")
+	}
+
+	tests := []struct {
+		body    string
+		v       interface{}
+		wantErr bool
+	}{
+		{
+			`{
+				"id": "sub_123456",
+				"object": "subscription",
+				"customer": "cus_123456",
+				"status": "active",
+				"discount": null,
+				"metadata": {"plan": "premium"},
+				"items": {
+					"object": "list",
+					"has_more": false,
+					"url": "/v1/subscription_items",
+					"data": [{"id": "si_123456", "object": "subscription_item", "price": {"id": "price_123456"}}]
+				}
+			}`,
+			&Subscription{},
+			false,
+		},
+		{
+			`{"id": "sub_123456", "status": "active", "unknown": true}`,
+			&Subscription{},
+			true,
+		},
+		{
+			`{
+				"id": "sub_123456",
+				"items": {
+					"data": [{"id": "si_123456", "price": {"id": "price_123456", "unknown": true}}]
+				}
+			}`,
+			&Subscription{},
+			true,
+		},
+		{
+			`{"id": "cus_123456", "object": "customer", "email": "me@example.com", "address": null}`,
+			&Customer{},
+			false,
+		},
+		{
+			`{"id": "cus_123456", "email": "me@example.com", "unknown": true}`,
+			&Customer{},
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		if err := s.unmarshal([]byte(test.body), test.v); (err != nil) != test.wantErr {
+			t.Errorf("tests[%d] - unexpected error, expected error=%v, got=%v\n", i, test.wantErr, err)
+		}
+	}
+}
+
+func Test_CustomerConcurrent(t *testing.T) {
+	var created int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&created, 1)
+		w.Write([]byte(`{"id": "cus_123456", "email": "me@example.com"}`))
+	}))
+	defer srv.Close()
+
+	stripe := New("sk_test_123456", newTestStore())
+	stripe.endpoint = srv.URL
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := stripe.Customer("me@example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("unexpected number of customers created, expected=%d, got=%d\n", 1, created)
+	}
+}
+
+func Test_MarshalJSON(t *testing.T) {
+	endsAt := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	c := &Customer{
+		Customer:     &stripelib.Customer{ID: "cus_123456", Email: "me@example.com"},
+		Jurisdiction: "GB",
+	}
+	inv := &Invoice{
+		Invoice: &stripelib.Invoice{ID: "in_123456"},
+		Updated: endsAt,
+	}
+	pm := &PaymentMethod{
+		PaymentMethod: &stripelib.PaymentMethod{ID: "pm_123456"},
+		Default:       true,
+	}
+	sub := &Subscription{
+		Subscription: &stripelib.Subscription{ID: "sub_123456"},
+		EndsAt:       sql.NullTime{Time: endsAt, Valid: true},
+	}
+
+	tests := []struct {
+		r     Resource
+		check func(Resource) bool
+	}{
+		{c, func(r Resource) bool {
+			c2 := r.(*Customer)
+			return c2.ID == c.ID && c2.Email == c.Email && c2.Jurisdiction == c.Jurisdiction
+		}},
+		{inv, func(r Resource) bool {
+			inv2 := r.(*Invoice)
+			return inv2.ID == inv.ID && inv2.Updated.Equal(inv.Updated)
+		}},
+		{pm, func(r Resource) bool {
+			pm2 := r.(*PaymentMethod)
+			return pm2.ID == pm.ID && pm2.Default
+		}},
+		{sub, func(r Resource) bool {
+			sub2 := r.(*Subscription)
+			return sub2.ID == sub.ID && sub2.EndsAt.Valid && sub2.EndsAt.Time.Equal(endsAt)
+		}},
+		{&Subscription{Subscription: &stripelib.Subscription{ID: "sub_123456"}}, func(r Resource) bool {
+			return !r.(*Subscription).EndsAt.Valid
+		}},
+	}
+
+	for i, test := range tests {
+		b, err := json.Marshal(test.r)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		var r Resource
+
+		switch test.r.(type) {
+		case *Customer:
+			r = &Customer{}
+		case *Invoice:
+			r = &Invoice{}
+		case *PaymentMethod:
+			r = &PaymentMethod{}
+		case *Subscription:
+			r = &Subscription{}
+		}
+
+		if err := json.Unmarshal(b, r); err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if !test.check(r) {
+			t.Fatalf("tests[%d] - %s did not round-trip, got %s\n", i, r.Kind(), string(b))
+		}
+	}
+}
+
+func Test_Upload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "` + err.Error() + `"}}`))
+			return
+		}
+
+		if r.FormValue("purpose") != "dispute_evidence" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "Invalid purpose"}}`))
+			return
+		}
+
+		f, hdr, err := r.FormFile("file")
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "` + err.Error() + `"}}`))
+			return
+		}
+		defer f.Close()
+
+		b, _ := ioutil.ReadAll(f)
+
+		w.Write([]byte(`{"id": "file_123456", "filename": "` + hdr.Filename + `", "size": ` + strconv.Itoa(len(b)) + `}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(stripelib.APIVersion, "sk_test_123456")
+	c.uploads = srv.URL
+
+	resp, err := c.Upload("/v1/files", "file", "receipt.txt", strings.NewReader("receipt"), "dispute_evidence")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status, expected=%d, got=%d\n", http.StatusOK, resp.StatusCode)
+	}
+
+	var file struct {
+		Filename string
+		Size     int
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		t.Fatal(err)
+	}
+
+	if file.Filename != "receipt.txt" || file.Size != len("receipt") {
+		t.Fatalf("unexpected file, got=%+v\n", file)
+	}
+}
+
+func Test_PostRaw(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+
+		w.Write([]byte(`{"content_type": "` + r.Header.Get("Content-Type") + `", "body": ` + strconv.Quote(string(b)) + `}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(stripelib.APIVersion, "sk_test_123456")
+	c.endpoint = srv.URL
+
+	resp, err := c.PostRaw("/v1/customers/search", "application/json", strings.NewReader(`{"query": "email:'me@example.com'"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var echo struct {
+		ContentType string `json:"content_type"`
+		Body        string
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&echo); err != nil {
+		t.Fatal(err)
+	}
+
+	if echo.ContentType != "application/json" {
+		t.Errorf("unexpected content type, expected=%q, got=%q\n", "application/json", echo.ContentType)
+	}
+
+	if echo.Body != `{"query": "email:'me@example.com'"}` {
+		t.Errorf("unexpected body, got=%q\n", echo.Body)
+	}
+}
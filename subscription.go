@@ -3,6 +3,7 @@ package stripeutil
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"strings"
 	"time"
 
@@ -15,6 +16,8 @@ type Subscription struct {
 	*stripe.Subscription
 
 	EndsAt sql.NullTime // EndsAt is the time the Subscription ends if it was cancelled.
+
+	raw json.RawMessage
 }
 
 var (
@@ -22,8 +25,33 @@ var (
 
 	subscriptionEndpoint = "/v1/subscriptions"
 
+	// ErrInvalidTrialEnd denotes when a trial is being extended to a time that
+	// is not in the future.
+	ErrInvalidTrialEnd = errors.New("invalid trial end")
+
+	// ErrInvalidCancelAt denotes when a Subscription is being canceled at a
+	// time that is not in the future, or is before the current period start.
+	ErrInvalidCancelAt = errors.New("invalid cancel at")
+
+	// ErrInvalidCollectionMethod denotes when a Subscription is given a
+	// collection method that is not "charge_automatically" or "send_invoice".
+	ErrInvalidCollectionMethod = errors.New("invalid collection method")
+
+	// ErrInvalidDaysUntilDue denotes when the days until due given for a
+	// collection method is invalid. The "send_invoice" collection method
+	// requires a positive number of days, and "charge_automatically" requires
+	// none.
+	ErrInvalidDaysUntilDue = errors.New("invalid days until due")
+
+	// ErrNoSubscriptionItems denotes when the price of a Subscription is being
+	// changed, but the Subscription has no items.
+	ErrNoSubscriptionItems = errors.New("subscription has no items")
+
+	// ErrUnknownSubscriptionItem is returned when removing an item from a
+	// Subscription that the Subscription does not have.
+	ErrUnknownSubscriptionItem = errors.New("unknown subscription item")
+
 	validSubscriptionStatuses = map[stripe.SubscriptionStatus]struct{}{
-		stripe.SubscriptionStatusAll:      {},
 		stripe.SubscriptionStatusActive:   {},
 		stripe.SubscriptionStatusTrialing: {},
 	}
@@ -32,19 +60,7 @@ var (
 func postSubscription(st *Stripe, uri string, params map[string]interface{}) (*Subscription, error) {
 	sub := &Subscription{}
 
-	resp, err := st.Post(uri, params)
-
-	if err != nil {
-		return sub, err
-	}
-
-	defer resp.Body.Close()
-
-	if !respCode2xx(resp.StatusCode) {
-		return sub, st.Error(resp)
-	}
-
-	err = json.NewDecoder(resp.Body).Decode(&sub.Subscription)
+	err := st.post(uri, params, sub)
 	return sub, err
 }
 
@@ -55,9 +71,16 @@ func CreateSubscription(st *Stripe, params Params) (*Subscription, error) {
 }
 
 // Reactivate will reactivate the current subscription by setting the property
-// cancel_at_period_end to false. This will set the EndsAt field to be invalid.
+// cancel_at_period_end to false, and clearing the cancel_at property, so a
+// cancellation scheduled via either Cancel or CancelAt will no longer happen.
+// This will set the EndsAt field to be invalid.
 func (s *Subscription) Reactivate(st *Stripe) error {
-	if err := s.Update(st, Params{"cancel_at_period_end": false}); err != nil {
+	params := Params{
+		"cancel_at_period_end": false,
+		"cancel_at":            "",
+	}
+
+	if err := s.Update(st, params); err != nil {
 		return err
 	}
 	s.EndsAt = sql.NullTime{}
@@ -78,7 +101,244 @@ func (s *Subscription) Cancel(st *Stripe) error {
 	return nil
 }
 
+// CancelAt will cancel the current Subscription at the given time. This will
+// set the EndsAt field to the given time. If the given time is not in the
+// future, or is before the start of the current period then
+// ErrInvalidCancelAt is returned.
+func (s *Subscription) CancelAt(st *Stripe, at time.Time) error {
+	if !at.After(time.Now()) || at.Before(time.Unix(s.CurrentPeriodStart, 0)) {
+		return ErrInvalidCancelAt
+	}
+
+	if err := s.Update(st, Params{"cancel_at": at.Unix()}); err != nil {
+		return err
+	}
+	s.EndsAt = sql.NullTime{
+		Time:  at,
+		Valid: true,
+	}
+	return nil
+}
+
+// ExtendTrial will extend the trial of the current Subscription until the given
+// time. If the given time is not in the future then ErrInvalidTrialEnd is
+// returned.
+func (s *Subscription) ExtendTrial(st *Stripe, until time.Time) error {
+	if !until.After(time.Now()) {
+		return ErrInvalidTrialEnd
+	}
+	return s.Update(st, Params{"trial_end": until.Unix()})
+}
+
+// SetCollectionMethod will set the collection method of the current
+// Subscription, along with the number of days until an Invoice is due. If the
+// method is "send_invoice" then daysUntilDue must be positive, otherwise if the
+// method is "charge_automatically" then daysUntilDue must be 0. If the
+// combination is invalid then ErrInvalidDaysUntilDue is returned, and if the
+// method is unknown then ErrInvalidCollectionMethod is returned.
+func (s *Subscription) SetCollectionMethod(st *Stripe, method string, daysUntilDue int) error {
+	params := Params{
+		"collection_method": method,
+	}
+
+	switch stripe.SubscriptionCollectionMethod(method) {
+	case stripe.SubscriptionCollectionMethodChargeAutomatically:
+		if daysUntilDue != 0 {
+			return ErrInvalidDaysUntilDue
+		}
+	case stripe.SubscriptionCollectionMethodSendInvoice:
+		if daysUntilDue <= 0 {
+			return ErrInvalidDaysUntilDue
+		}
+		params["days_until_due"] = daysUntilDue
+	default:
+		return ErrInvalidCollectionMethod
+	}
+	return s.Update(st, params)
+}
+
+// Discount returns the Discount that has been applied to the current
+// Subscription, if any.
+func (s *Subscription) Discount() *stripe.Discount { return s.Subscription.Discount }
+
+// ApplyCoupon will apply the coupon of the given ID to the current
+// Subscription.
+func (s *Subscription) ApplyCoupon(st *Stripe, coupon string) error {
+	return s.Update(st, Params{"coupon": coupon})
+}
+
+// RemoveDiscount will remove the Discount that has been applied to the current
+// Subscription.
+func (s *Subscription) RemoveDiscount(st *Stripe) error {
+	resp, err := st.Delete(s.Endpoint("discount"))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if !respCode2xx(resp.StatusCode) {
+		return st.Error(resp)
+	}
+
+	s.Subscription.Discount = nil
+	return nil
+}
+
+// item returns the first item of the current Subscription, loading in the
+// Subscription from Stripe if it has no items.
+func (s *Subscription) item(st *Stripe) (*stripe.SubscriptionItem, error) {
+	if s.Items == nil || len(s.Items.Data) == 0 {
+		if err := s.Load(st); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Items == nil || len(s.Items.Data) == 0 {
+		return nil, ErrNoSubscriptionItems
+	}
+	return s.Items.Data[0], nil
+}
+
+// PreviewPriceChange will preview the Invoice for changing the current
+// Subscription to the Price of the given ID. This returns the upcoming Invoice
+// with the prorations for the change, along with the proration date that was
+// used. The proration date should be passed to ChangePrice, so the Customer is
+// charged exactly what was previewed,
+//
+//     inv, prorationDate, err := sub.PreviewPriceChange(stripe, "price_123456")
+//
+//     if err != nil {
+//         // Handle error.
+//     }
+//
+//     // Show the Invoice to the Customer and get their confirmation.
+//
+//     if err := sub.ChangePrice(stripe, "price_123456", prorationDate); err != nil {
+//         // Handle error.
+//     }
+func (s *Subscription) PreviewPriceChange(st *Stripe, priceID string) (*Invoice, time.Time, error) {
+	it, err := s.item(st)
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	prorationDate := time.Unix(time.Now().Unix(), 0)
+
+	params := Params{
+		"customer":     customerID(s.Customer),
+		"subscription": s.ID,
+		"subscription_items": []Params{
+			{"id": it.ID, "price": priceID},
+		},
+		"subscription_proration_date": prorationDate.Unix(),
+	}
+
+	inv := &Invoice{}
+
+	if err := st.get(invoiceEndpoint+"/upcoming?"+params.Encode(), inv); err != nil {
+		return nil, time.Time{}, err
+	}
+	return inv, prorationDate, nil
+}
+
+// ChangePrice will change the current Subscription to the Price of the given
+// ID. The given proration date is used for calculating the prorations of the
+// change, this should be the date returned from PreviewPriceChange. If the
+// given proration date is zero then the current time is used.
+func (s *Subscription) ChangePrice(st *Stripe, priceID string, prorationDate time.Time) error {
+	it, err := s.item(st)
+
+	if err != nil {
+		return err
+	}
+
+	params := Params{
+		"items": []Params{
+			{"id": it.ID, "price": priceID},
+		},
+	}
+
+	if !prorationDate.IsZero() {
+		params["proration_date"] = prorationDate.Unix()
+	}
+	return s.Update(st, params)
+}
+
+// AddItem will add an item for the given price, with the given quantity, to the
+// current Subscription. This would typically be used for adding an add-on to an
+// existing Subscription. The change is prorated according to the proration
+// behavior of the Subscription.
+func (s *Subscription) AddItem(st *Stripe, priceID string, quantity int64) error {
+	params := Params{
+		"items": []Params{
+			{"price": priceID, "quantity": quantity},
+		},
+	}
+	return s.Update(st, params)
+}
+
+// RemoveItem will remove the item of the given ID from the current
+// Subscription. If the Subscription does not have the item then
+// ErrUnknownSubscriptionItem is returned. If the price of the item is metered,
+// then the usage reported for the item is cleared, since Stripe will not remove
+// a metered item that has usage otherwise. The change is prorated according to
+// the proration behavior of the Subscription.
+func (s *Subscription) RemoveItem(st *Stripe, itemID string) error {
+	if s.Items == nil || len(s.Items.Data) == 0 {
+		if err := s.Load(st); err != nil {
+			return err
+		}
+	}
+
+	var it *stripe.SubscriptionItem
+
+	if s.Items != nil {
+		for _, item := range s.Items.Data {
+			if item.ID == itemID {
+				it = item
+				break
+			}
+		}
+	}
+
+	if it == nil {
+		return ErrUnknownSubscriptionItem
+	}
+
+	item := Params{
+		"id":      it.ID,
+		"deleted": true,
+	}
+
+	if it.Price != nil && it.Price.Recurring != nil && it.Price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+		item["clear_usage"] = true
+	}
+
+	params := Params{
+		"items": []Params{item},
+	}
+	return s.Update(st, params)
+}
+
+// copy returns a copy of the current Subscription, along with a copy of the
+// embedded stripe.Subscription, so decoding into either does not change the
+// other.
+func (s *Subscription) copy() *Subscription {
+	cp := *s
+
+	if s.Subscription != nil {
+		sub := *s.Subscription
+		cp.Subscription = &sub
+	}
+	return &cp
+}
+
 // Update will update the current Subscription in Stripe with the given Params.
+// The EndsAt field is set from the updated Subscription returned from Stripe,
+// so it reflects when the updated Subscription ends, if at all.
 func (s *Subscription) Update(st *Stripe, params Params) error {
 	s1, err := postSubscription(st, s.Endpoint(), params)
 
@@ -86,10 +346,92 @@ func (s *Subscription) Update(st *Stripe, params Params) error {
 		return err
 	}
 
+	s1.setEndsAt()
+
 	(*s) = (*s1)
 	return nil
 }
 
+// setEndsAt sets the EndsAt field of the current Subscription from the
+// time the Subscription ends in Stripe. This will be the end of the current
+// period if the Subscription is set to cancel at the period end, the time set
+// via CancelAt, or the time the Subscription ended. If the Subscription is not
+// set to end then EndsAt is set to be invalid.
+func (s *Subscription) setEndsAt() {
+	var endsAt int64
+
+	switch {
+	case s.CancelAtPeriodEnd:
+		endsAt = s.CurrentPeriodEnd
+	case s.Subscription.CancelAt > 0:
+		endsAt = s.Subscription.CancelAt
+	case s.EndedAt > 0:
+		endsAt = s.EndedAt
+	}
+
+	s.EndsAt = sql.NullTime{}
+
+	if endsAt > 0 {
+		s.EndsAt = sql.NullTime{
+			Time:  time.Unix(endsAt, 0),
+			Valid: true,
+		}
+	}
+}
+
+// MarshalJSON encodes the Subscription to JSON. The fields of the underlying
+// stripe.Subscription are encoded alongside the EndsAt field, under the
+// "ends_at" key. If EndsAt is not valid then it is encoded as null.
+func (s *Subscription) MarshalJSON() ([]byte, error) {
+	var endsAt *time.Time
+
+	if s.EndsAt.Valid {
+		endsAt = &s.EndsAt.Time
+	}
+
+	return marshalFields(s.Subscription, map[string]interface{}{
+		"ends_at": endsAt,
+	})
+}
+
+// UnmarshalJSON decodes the given JSON into the Subscription. This is
+// implemented so the "ends_at" key encoded via MarshalJSON is decoded into the
+// EndsAt field.
+func (s *Subscription) UnmarshalJSON(b []byte) error {
+	if s.Subscription == nil {
+		s.Subscription = &stripe.Subscription{}
+	}
+
+	var endsAt json.RawMessage
+
+	err := unmarshalFields(b, s.Subscription, map[string]interface{}{
+		"ends_at": &endsAt,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if endsAt == nil {
+		return nil
+	}
+
+	if string(endsAt) == "null" {
+		s.EndsAt = sql.NullTime{}
+		return nil
+	}
+
+	if err := json.Unmarshal(endsAt, &s.EndsAt.Time); err != nil {
+		return err
+	}
+
+	s.EndsAt.Valid = true
+	return nil
+}
+
+// Kind implements the Resource interface.
+func (s *Subscription) Kind() string { return "subscription" }
+
 // Endpoint implements the Resource interface.
 func (s *Subscription) Endpoint(uris ...string) string {
 	endpoint := subscriptionEndpoint
@@ -104,24 +446,154 @@ func (s *Subscription) Endpoint(uris ...string) string {
 	return endpoint + strings.Join(uris, "/")
 }
 
+// NextBillingDate returns the date at which the current Subscription will next
+// be billed. This requires the CurrentPeriodEnd of the Subscription to have
+// been loaded from Stripe.
+func (s *Subscription) NextBillingDate() time.Time { return time.Unix(s.CurrentPeriodEnd, 0) }
+
+// DaysUntilRenewal returns the number of whole days from the given time until
+// the Subscription renews. If the renewal date has passed then 0 is returned.
+// Like NextBillingDate, this requires the CurrentPeriodEnd of the Subscription
+// to have been loaded from Stripe.
+func (s *Subscription) DaysUntilRenewal(now time.Time) int {
+	d := s.NextBillingDate().Sub(now)
+
+	if d < 0 {
+		return 0
+	}
+	return int(d.Hours() / 24)
+}
+
+// IsTrialing will return whether or not the current Subscription is in its
+// trial period at the given time. This is the case if the status of the
+// Subscription is "trialing" and the given time is before the TrialEnd of the
+// Subscription. The Subscriptions retrieved from the PSQL store do not have
+// the TrialEnd field set, since it is not stored, so for these only the status
+// is checked. Use Load to get the Subscription from Stripe with its trial
+// fields.
+func (s *Subscription) IsTrialing(now time.Time) bool {
+	if !s.Trialing() {
+		return false
+	}
+
+	if s.TrialEnd == 0 {
+		return true
+	}
+	return now.Before(time.Unix(s.TrialEnd, 0))
+}
+
+// TrialDaysRemaining returns the number of whole days from the given time
+// until the trial period of the Subscription ends. If the Subscription is not
+// in its trial period, or the TrialEnd of the Subscription has not been
+// loaded from Stripe then 0 is returned.
+func (s *Subscription) TrialDaysRemaining(now time.Time) int {
+	if !s.IsTrialing(now) || s.TrialEnd == 0 {
+		return 0
+	}
+	return int(time.Unix(s.TrialEnd, 0).Sub(now).Hours() / 24)
+}
+
 // WithinGrace will return true if the current Subscription has been canceled
-// but stil lies within the grace period. If the Subscription has not been
-// canceled then this will always return true.
+// but stil lies within the grace period. A Subscription is within the grace
+// period if it was set to cancel at the end of the period, or at a given time
+// via CancelAt, and that time has not yet passed. A Subscription with the
+// status "canceled" has already ended, so is never within the grace period.
 func (s *Subscription) WithinGrace() bool {
 	if s == nil {
 		return false
 	}
 
-	if !s.EndsAt.Valid {
+	if !s.EndsAt.Valid || s.Status == stripe.SubscriptionStatusCanceled {
 		return false
 	}
 	return time.Now().Before(s.EndsAt.Time)
 }
 
+// Pending will return whether or not the current Subscription is pending
+// payment. A Subscription is pending if the initial payment for it has not yet
+// been made, in which case the status would be "incomplete". Once the initial
+// payment expires the status becomes "incomplete_expired", and the
+// Subscription is no longer pending.
+func (s *Subscription) Pending() bool {
+	if s == nil {
+		return false
+	}
+	return s.Status == stripe.SubscriptionStatusIncomplete
+}
+
+// Active will return whether or not the current Subscription has the status
+// "active", and has not been canceled.
+func (s *Subscription) Active() bool {
+	if s == nil {
+		return false
+	}
+	return s.Status == stripe.SubscriptionStatusActive && !s.Canceled()
+}
+
+// Trialing will return whether or not the current Subscription is in its trial
+// period. The end of the trial can be retrieved from the TrialEnd field.
+func (s *Subscription) Trialing() bool {
+	if s == nil {
+		return false
+	}
+	return s.Status == stripe.SubscriptionStatusTrialing
+}
+
+// PastDue will return whether or not the payment for the latest Invoice of the
+// current Subscription has failed, and is being retried.
+func (s *Subscription) PastDue() bool {
+	if s == nil {
+		return false
+	}
+	return s.Status == stripe.SubscriptionStatusPastDue
+}
+
+// Canceled will return whether or not the current Subscription has been
+// canceled. A Subscription is canceled if the status is "canceled", or if the
+// EndsAt date of the Subscription has passed. A Subscription that is within
+// the grace period is not considered canceled, use WithinGrace for this.
+func (s *Subscription) Canceled() bool {
+	if s == nil {
+		return false
+	}
+
+	if s.Status == stripe.SubscriptionStatusCanceled {
+		return true
+	}
+	return s.EndsAt.Valid && !time.Now().Before(s.EndsAt.Time)
+}
+
+// Incomplete will return whether or not the initial payment for the current
+// Subscription was not made. Unlike Pending, this will also return true if the
+// initial payment has expired, in which case the status would be
+// "incomplete_expired".
+func (s *Subscription) Incomplete() bool {
+	if s == nil {
+		return false
+	}
+
+	switch s.Status {
+	case stripe.SubscriptionStatusIncomplete, stripe.SubscriptionStatusIncompleteExpired:
+		return true
+	}
+	return false
+}
+
+// Paused will return whether or not the current Subscription has been paused.
+// A Subscription is paused if the status is "paused", or if the collection of
+// payments for the Subscription has been paused.
+func (s *Subscription) Paused() bool {
+	if s == nil {
+		return false
+	}
+	return s.Status == "paused" || s.PauseCollection.Behavior != ""
+}
+
 // Valid will return whether or not the current Subscription is valid. A
-// Subscription is considered valid if the status is one of, "all", "active",
-// or "trialing", or if the Subscription was cancelled but the current time
-// is before the EndsAt date.
+// Subscription is considered valid if the status is either "active", or
+// "trialing", or if the Subscription was cancelled but the current time
+// is before the EndsAt date. A Subscription that is "incomplete" or
+// "incomplete_expired" is not considered valid.
 func (s *Subscription) Valid() bool {
 	if s == nil {
 		return false
@@ -137,18 +609,18 @@ func (s *Subscription) Valid() bool {
 	return false
 }
 
-// Load implements the Resource interface.
-func (s *Subscription) Load(st *Stripe) error {
-	resp, err := st.Client.Get(s.Endpoint())
+// Raw returns the raw JSON of the Subscription. This is the JSON of the
+// response the Subscription was last decoded from, or the JSON kept by the
+// store the Subscription was retrieved from.
+func (s *Subscription) Raw() (json.RawMessage, error) { return rawJSON(s.raw, s.Subscription) }
 
-	if err != nil {
-		return err
-	}
+func (s *Subscription) setRaw(b json.RawMessage) { s.raw = b }
 
-	defer resp.Body.Close()
+// Load implements the Resource interface.
+func (s *Subscription) Load(st *Stripe) error { return s.LoadExpanded(st) }
 
-	if !respCode2xx(resp.StatusCode) {
-		return st.Error(resp)
-	}
-	return json.NewDecoder(resp.Body).Decode(s)
+// LoadExpanded will load in the Subscription from the Stripe API, expanding the
+// objects at the given paths in the response.
+func (s *Subscription) LoadExpanded(st *Stripe, expand ...string) error {
+	return st.get(s.Endpoint(), s, expand...)
 }
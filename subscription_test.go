@@ -0,0 +1,1299 @@
+package stripeutil
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_SetCollectionMethod(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Write([]byte(`{"id": "sub_123456", "collection_method": "` + r.PostForm.Get("collection_method") + `"}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", newTestStore())
+	s.endpoint = srv.URL
+
+	tests := []struct {
+		method       string
+		daysUntilDue int
+		expected     error
+	}{
+		{"charge_automatically", 0, nil},
+		{"charge_automatically", 30, ErrInvalidDaysUntilDue},
+		{"send_invoice", 30, nil},
+		{"send_invoice", 0, ErrInvalidDaysUntilDue},
+		{"send_invoice", -1, ErrInvalidDaysUntilDue},
+		{"invoice", 30, ErrInvalidCollectionMethod},
+	}
+
+	for i, test := range tests {
+		sub := &Subscription{
+			Subscription: &stripe.Subscription{ID: "sub_123456"},
+		}
+
+		if err := sub.SetCollectionMethod(s, test.method, test.daysUntilDue); err != test.expected {
+			t.Errorf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.expected, err)
+			continue
+		}
+
+		if test.expected == nil && string(sub.CollectionMethod) != test.method {
+			t.Errorf("tests[%d] - unexpected collection method, expected=%q, got=%q\n", i, test.method, sub.CollectionMethod)
+		}
+	}
+}
+
+func Test_IsSubscribed(t *testing.T) {
+	store := newTestStore()
+
+	s := New("sk_test_123456", store)
+
+	store.subscriptions["cus_active"] = &Subscription{
+		Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive},
+	}
+	store.subscriptions["cus_grace"] = &Subscription{
+		Subscription: &stripe.Subscription{
+			Status:            stripe.SubscriptionStatusActive,
+			CancelAtPeriodEnd: true,
+		},
+		EndsAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	}
+	store.subscriptions["cus_canceled"] = &Subscription{
+		Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusCanceled},
+		EndsAt:       sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+	}
+	store.subscriptions["cus_incomplete"] = &Subscription{
+		Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusIncomplete},
+	}
+
+	tests := []struct {
+		id       string
+		expected bool
+	}{
+		{"cus_active", true},
+		{"cus_grace", true},
+		{"cus_canceled", false},
+		{"cus_incomplete", false},
+		{"cus_none", false},
+	}
+
+	for i, test := range tests {
+		c := &Customer{
+			Customer: &stripe.Customer{ID: test.id},
+		}
+
+		ok, err := s.IsSubscribed(c)
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if ok != test.expected {
+			t.Errorf("tests[%d] - expected subscribed=%v, got=%v\n", i, test.expected, ok)
+		}
+	}
+}
+
+func Test_SubscribedPrice(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"message": "Not found"}}`))
+	}))
+	defer srv.Close()
+
+	store := newTestStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	items := func(id string) *stripe.SubscriptionItemList {
+		return &stripe.SubscriptionItemList{
+			Data: []*stripe.SubscriptionItem{
+				{Price: &stripe.Price{ID: id}},
+			},
+		}
+	}
+
+	store.subscriptions["cus_pro"] = &Subscription{
+		Subscription: &stripe.Subscription{
+			Status: stripe.SubscriptionStatusActive,
+			Items:  items("price_pro"),
+		},
+	}
+	store.subscriptions["cus_legacy"] = &Subscription{
+		Subscription: &stripe.Subscription{
+			Status: stripe.SubscriptionStatusActive,
+			Items:  items("price_legacy"),
+		},
+	}
+	// Stored without its items, but with its raw JSON.
+	store.subscriptions["cus_raw"] = &Subscription{
+		Subscription: &stripe.Subscription{
+			Status: stripe.SubscriptionStatusActive,
+		},
+		raw: []byte(`{"items": {"data": [{"price": {"id": "price_pro"}}]}}`),
+	}
+	store.subscriptions["cus_canceled"] = &Subscription{
+		Subscription: &stripe.Subscription{
+			Status: stripe.SubscriptionStatusCanceled,
+			Items:  items("price_pro"),
+		},
+	}
+
+	prices := &Prices{
+		ids: []string{"price_pro"},
+		prices: map[string]*Price{
+			"price_pro": {Price: &stripe.Price{ID: "price_pro"}},
+		},
+	}
+
+	tests := []struct {
+		id          string
+		expectedOk  bool
+		expectedErr error
+	}{
+		{"cus_pro", true, nil},
+		{"cus_legacy", false, ErrUnknownPrice},
+		{"cus_raw", true, nil},
+		{"cus_canceled", false, nil},
+		{"cus_none", false, nil},
+	}
+
+	for i, test := range tests {
+		c := &Customer{
+			Customer: &stripe.Customer{ID: test.id},
+		}
+
+		pr, ok, err := s.SubscribedPrice(c, prices)
+
+		if err != test.expectedErr {
+			t.Fatalf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.expectedErr, err)
+		}
+
+		if ok != test.expectedOk {
+			t.Errorf("tests[%d] - expected ok=%v, got=%v\n", i, test.expectedOk, ok)
+			continue
+		}
+
+		if ok && pr.ID != "price_pro" {
+			t.Errorf("tests[%d] - unexpected price, expected=%q, got=%q\n", i, "price_pro", pr.ID)
+		}
+	}
+}
+
+func Test_SubscriptionOrFetch(t *testing.T) {
+	var fetches int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+
+		if r.URL.Query().Get("customer") != "cus_123456" {
+			w.Write([]byte(`{"has_more": false, "data": []}`))
+			return
+		}
+
+		w.Write([]byte(`{"has_more": false, "data": [
+			{"id": "sub_old", "status": "canceled", "created": 1, "ended_at": 2},
+			{"id": "sub_new", "status": "active", "created": 3, "cancel_at_period_end": true, "current_period_end": 4}
+		]}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	sub, ok, err := s.SubscriptionOrFetch(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || sub.ID != "sub_new" {
+		t.Fatalf("unexpected subscription, expected=%q, got=%v\n", "sub_new", sub)
+	}
+
+	if !sub.EndsAt.Valid || sub.EndsAt.Time.Unix() != 4 {
+		t.Fatalf("unexpected ends at, got=%v\n", sub.EndsAt)
+	}
+
+	// The subscription should now be in the store, so Stripe is not hit.
+	if _, ok, _ := s.SubscriptionOrFetch(c); !ok || fetches != 1 {
+		t.Fatalf("expected subscription from store, fetches=%d\n", fetches)
+	}
+
+	if _, ok, err := s.SubscriptionOrFetch(&Customer{Customer: &stripe.Customer{ID: "cus_654321"}}); err != nil || ok {
+		t.Fatalf("expected no subscription, ok=%v, err=%v\n", ok, err)
+	}
+}
+
+func Test_PriceChangeProrationDate(t *testing.T) {
+	var previewed, charged string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/v1/invoices/upcoming") {
+			q := r.URL.Query()
+
+			if q.Get("subscription_items[0][id]") != "si_123456" || q.Get("subscription_items[0][price]") != "price_new" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": {"message": "Invalid items"}}`))
+				return
+			}
+
+			previewed = q.Get("subscription_proration_date")
+			w.Write([]byte(`{"amount_due": 500}`))
+			return
+		}
+
+		r.ParseForm()
+		charged = r.PostForm.Get("proration_date")
+
+		w.Write([]byte(`{"id": "sub_123456", "items": {"data": [{"id": "si_123456", "price": {"id": "` + r.PostForm.Get("items[0][price]") + `"}}]}}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", newTestStore())
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: &stripe.Customer{ID: "cus_123456"},
+			Items: &stripe.SubscriptionItemList{
+				Data: []*stripe.SubscriptionItem{
+					{ID: "si_123456", Price: &stripe.Price{ID: "price_old"}},
+				},
+			},
+		},
+	}
+
+	inv, prorationDate, err := sub.PreviewPriceChange(s, "price_new")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.AmountDue != 500 {
+		t.Fatalf("unexpected amount due, expected=%d, got=%d\n", 500, inv.AmountDue)
+	}
+
+	if err := sub.ChangePrice(s, "price_new", prorationDate); err != nil {
+		t.Fatal(err)
+	}
+
+	if previewed == "" || previewed != charged {
+		t.Fatalf("expected proration dates to match, previewed=%q, charged=%q\n", previewed, charged)
+	}
+
+	if sub.Items.Data[0].Price.ID != "price_new" {
+		t.Fatalf("unexpected price, expected=%q, got=%q\n", "price_new", sub.Items.Data[0].Price.ID)
+	}
+}
+
+func Test_SubscriptionStatus(t *testing.T) {
+	past := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+
+	tests := []struct {
+		sub        *Subscription
+		active     bool
+		trialing   bool
+		pastDue    bool
+		canceled   bool
+		incomplete bool
+		paused     bool
+	}{
+		{&Subscription{Subscription: &stripe.Subscription{Status: "active"}}, true, false, false, false, false, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "active"}, EndsAt: past}, false, false, false, true, false, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing"}}, false, true, false, false, false, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "past_due"}}, false, false, true, false, false, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "canceled"}}, false, false, false, true, false, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "incomplete"}}, false, false, false, false, true, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "incomplete_expired"}}, false, false, false, false, true, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "paused"}}, false, false, false, false, false, true},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "active", PauseCollection: stripe.SubscriptionPauseCollection{Behavior: "void"}}}, true, false, false, false, false, true},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "all"}}, false, false, false, false, false, false},
+		{nil, false, false, false, false, false, false},
+	}
+
+	for i, test := range tests {
+		got := []bool{
+			test.sub.Active(),
+			test.sub.Trialing(),
+			test.sub.PastDue(),
+			test.sub.Canceled(),
+			test.sub.Incomplete(),
+			test.sub.Paused(),
+		}
+
+		expected := []bool{test.active, test.trialing, test.pastDue, test.canceled, test.incomplete, test.paused}
+
+		for j := range expected {
+			if got[j] != expected[j] {
+				t.Errorf("tests[%d] - unexpected status %d, expected=%v, got=%v\n", i, j, expected[j], got[j])
+			}
+		}
+	}
+}
+
+func Test_SubscriptionValid(t *testing.T) {
+	future := sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+
+	tests := []struct {
+		sub      *Subscription
+		expected bool
+	}{
+		{&Subscription{Subscription: &stripe.Subscription{Status: "active"}}, true},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing"}}, true},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "canceled", CancelAtPeriodEnd: true}, EndsAt: future}, true},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "all"}}, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "past_due"}}, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "unpaid"}}, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "canceled"}}, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "incomplete"}}, false},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "incomplete_expired"}}, false},
+	}
+
+	for i, test := range tests {
+		if valid := test.sub.Valid(); valid != test.expected {
+			t.Errorf("tests[%d] - unexpected validity for status %q, expected=%v, got=%v\n", i, test.sub.Status, test.expected, valid)
+		}
+	}
+}
+
+func Test_SubscribeProcessing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456/attach"):
+			w.Write([]byte(`{"id": "pm_123456", "type": "sepa_debit", "customer": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/subscriptions/sub_123456"):
+			w.Write([]byte(`{
+				"id": "sub_123456",
+				"customer": "cus_123456",
+				"status": "incomplete",
+				"latest_invoice": {
+					"id": "in_123456",
+					"status": "open",
+					"payment_intent": {"id": "pi_123456", "status": "processing"}
+				}
+			}`))
+		default:
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusIncomplete,
+		},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Type: stripe.PaymentMethodTypeSepaDebit},
+	}
+
+	if !pm.delayedNotification() {
+		t.Fatalf("expected payment method %q to be delayed\n", pm.Type)
+	}
+
+	sub, err := s.Subscribe(c, pm, Params{})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if !sub.Pending() {
+		t.Fatalf("unexpected subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusIncomplete, sub.Status)
+	}
+}
+
+func Test_SubscribeSEPA(t *testing.T) {
+	var paymentMethodTypes string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456/attach"):
+			w.Write([]byte(`{"id": "pm_123456", "type": "sepa_debit", "customer": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/subscriptions"):
+			r.ParseForm()
+			paymentMethodTypes = r.PostForm.Get("payment_settings[payment_method_types][0]")
+
+			w.Write([]byte(`{
+				"id": "sub_123456",
+				"customer": "cus_123456",
+				"status": "incomplete",
+				"latest_invoice": {
+					"id": "in_123456",
+					"status": "open",
+					"payment_intent": {"id": "pi_123456", "status": "processing"}
+				}
+			}`))
+		default:
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", NewMemoryStore())
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	card := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_654321", Type: stripe.PaymentMethodTypeCard},
+	}
+
+	if _, err := s.SubscribeSEPA(c, card, Params{}); err != ErrNotSEPADebit {
+		t.Fatalf("unexpected error, expected=%q, got=%v\n", ErrNotSEPADebit, err)
+	}
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Type: stripe.PaymentMethodTypeSepaDebit},
+	}
+
+	sub, err := s.SubscribeSEPA(c, pm, Params{
+		"items": []Params{
+			{"price": "price_123456"},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if paymentMethodTypes != "sepa_debit" {
+		t.Fatalf("unexpected payment method types, expected=%q, got=%q\n", "sepa_debit", paymentMethodTypes)
+	}
+
+	if !sub.Pending() {
+		t.Fatalf("unexpected subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusIncomplete, sub.Status)
+	}
+}
+
+func Test_SubscribeIncomplete(t *testing.T) {
+	var paymentBehavior string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/subscriptions") {
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+		paymentBehavior = r.PostForm.Get("payment_behavior")
+
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"customer": "cus_123456",
+			"status": "incomplete",
+			"latest_invoice": {
+				"id": "in_123456",
+				"customer": "cus_123456",
+				"status": "open",
+				"payment_intent": {"id": "pi_123456", "status": "requires_payment_method", "client_secret": "pi_123456_secret"}
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	sub, secret, err := s.SubscribeIncomplete(c, Params{
+		"items": []Params{
+			{"price": "price_123456"},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if paymentBehavior != "default_incomplete" {
+		t.Fatalf("unexpected payment behavior, expected=%q, got=%q\n", "default_incomplete", paymentBehavior)
+	}
+
+	if secret != "pi_123456_secret" {
+		t.Fatalf("unexpected client secret, expected=%q, got=%q\n", "pi_123456_secret", secret)
+	}
+
+	if !sub.Pending() {
+		t.Fatalf("unexpected subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusIncomplete, sub.Status)
+	}
+
+	if _, ok, _ := store.Subscription(c); !ok {
+		t.Fatal("expected subscription to be stored")
+	}
+
+	if _, ok, _ := store.LookupInvoice(c, ""); !ok {
+		t.Fatal("expected invoice to be stored")
+	}
+}
+
+func Test_SubscribeIncompleteExisting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+		}
+
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"customer": "cus_123456",
+			"status": "active",
+			"latest_invoice": {"id": "in_123456", "customer": "cus_123456", "status": "paid"}
+		}`))
+	}))
+	defer srv.Close()
+
+	store := &copyStore{TestStore: newTestStore()}
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.TestStore.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusIncomplete,
+		},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	var old, new stripe.SubscriptionStatus
+
+	s.OnSubscriptionChange(func(o, n *Subscription) {
+		old, new = o.Status, n.Status
+	})
+
+	if _, _, err := s.SubscribeIncomplete(c, Params{}); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if old != stripe.SubscriptionStatusIncomplete || new != stripe.SubscriptionStatusActive {
+		t.Fatalf("unexpected subscription change, expected=%q->%q, got=%q->%q\n", stripe.SubscriptionStatusIncomplete, stripe.SubscriptionStatusActive, old, new)
+	}
+}
+
+func Test_FinalizeSubscription(t *testing.T) {
+	status := "incomplete"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/subscriptions/sub_123456") {
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"customer": "cus_123456",
+			"status": "` + status + `",
+			"latest_invoice": {"id": "in_123456", "customer": "cus_123456", "status": "paid"}
+		}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusIncomplete,
+		},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	sub, err := s.FinalizeSubscription(c)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if !sub.Pending() {
+		t.Fatalf("unexpected subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusIncomplete, sub.Status)
+	}
+
+	status = "active"
+
+	if _, err := s.FinalizeSubscription(c); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	sub, _, err = store.Subscription(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sub.Active() {
+		t.Fatalf("unexpected stored subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusActive, sub.Status)
+	}
+
+	if sub, err := s.FinalizeSubscription(&Customer{Customer: &stripe.Customer{ID: "cus_654321"}}); err != nil || sub != nil {
+		t.Fatalf("expected no subscription, got=%v, err=%v\n", sub, err)
+	}
+}
+
+func Test_FinalizeSubscriptionCopy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"customer": "cus_123456",
+			"status": "active",
+			"latest_invoice": {"id": "in_123456", "customer": "cus_123456", "status": "paid"}
+		}`))
+	}))
+	defer srv.Close()
+
+	store := &copyStore{TestStore: newTestStore()}
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.TestStore.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusIncomplete,
+		},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	var changed *Subscription
+
+	s.OnSubscriptionChange(func(old, new *Subscription) {
+		if old.Status != stripe.SubscriptionStatusIncomplete {
+			t.Errorf("unexpected old subscription status, expected=%q, got=%q\n", stripe.SubscriptionStatusIncomplete, old.Status)
+		}
+		changed = new
+	})
+
+	if _, err := s.FinalizeSubscription(c); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	if store.puts == 0 {
+		t.Fatal("expected subscription to be put in the store")
+	}
+
+	if changed == nil || !changed.Active() {
+		t.Fatal("expected subscription change callback to be invoked with the active subscription")
+	}
+}
+
+func Test_SubscriptionItems(t *testing.T) {
+	var form map[string]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/subscriptions/sub_123456") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		r.ParseForm()
+
+		form = make(map[string]string)
+
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+
+		w.Write([]byte(`{"id": "sub_123456", "status": "active", "items": {"data": [
+			{"id": "si_123456", "price": {"id": "price_123456", "recurring": {"usage_type": "licensed"}}},
+			{"id": "si_654321", "price": {"id": "price_654321", "recurring": {"usage_type": "metered"}}}
+		]}}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_123456"},
+	}
+
+	if err := sub.AddItem(s, "price_654321", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"items[0][price]":    "price_654321",
+		"items[0][quantity]": "2",
+	}
+
+	for k, v := range expected {
+		if form[k] != v {
+			t.Errorf("unexpected %s, expected=%q, got=%q\n", k, v, form[k])
+		}
+	}
+
+	tests := []struct {
+		id         string
+		clearUsage string
+		err        error
+	}{
+		{"si_123456", "", nil},
+		{"si_654321", "true", nil},
+		{"si_000000", "", ErrUnknownSubscriptionItem},
+	}
+
+	for i, test := range tests {
+		form = nil
+
+		if err := sub.RemoveItem(s, test.id); err != test.err {
+			t.Fatalf("tests[%d] - unexpected error, expected=%v, got=%v\n", i, test.err, err)
+		}
+
+		if test.err != nil {
+			if form != nil {
+				t.Errorf("tests[%d] - expected no request to be made\n", i)
+			}
+			continue
+		}
+
+		if form["items[0][id]"] != test.id || form["items[0][deleted]"] != "true" {
+			t.Errorf("tests[%d] - unexpected items, got=%v\n", i, form)
+		}
+
+		if form["items[0][clear_usage]"] != test.clearUsage {
+			t.Errorf("tests[%d] - unexpected clear_usage, expected=%q, got=%q\n", i, test.clearUsage, form["items[0][clear_usage]"])
+		}
+	}
+}
+
+func Test_RequestThreeDSecure(t *testing.T) {
+	tests := []struct {
+		params   Params
+		request  bool
+		expected string
+	}{
+		{
+			Params{},
+			true,
+			"payment_settings[payment_method_options][card][request_three_d_secure]=any",
+		},
+		{
+			Params{},
+			false,
+			"payment_settings[payment_method_options][card][request_three_d_secure]=automatic",
+		},
+		{
+			Params{
+				"payment_settings": Params{
+					"payment_method_types": []string{"card"},
+				},
+			},
+			true,
+			"payment_settings[payment_method_options][card][request_three_d_secure]=any&payment_settings[payment_method_types][0]=card",
+		},
+	}
+
+	for i, test := range tests {
+		RequestThreeDSecure(test.request)(test.params)
+
+		encoded, err := url.QueryUnescape(test.params.Encode())
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if encoded != test.expected {
+			t.Errorf("tests[%d] - unexpected params, expected=%q, got=%q\n", i, test.expected, encoded)
+		}
+	}
+}
+
+func Test_SubscriptionTrial(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sub           *Subscription
+		trialing      bool
+		daysRemaining int
+	}{
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing", TrialEnd: now.Add(time.Hour * 24 * 7).Unix()}}, true, 7},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing", TrialEnd: now.Add(time.Hour * 12).Unix()}}, true, 0},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing", TrialEnd: now.Add(-time.Hour).Unix()}}, false, 0},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "trialing"}}, true, 0},
+		{&Subscription{Subscription: &stripe.Subscription{Status: "active", TrialEnd: now.Add(time.Hour * 24 * 7).Unix()}}, false, 0},
+		{nil, false, 0},
+	}
+
+	for i, test := range tests {
+		if trialing := test.sub.IsTrialing(now); trialing != test.trialing {
+			t.Errorf("tests[%d] - unexpected trialing, expected=%v, got=%v\n", i, test.trialing, trialing)
+		}
+
+		if days := test.sub.TrialDaysRemaining(now); days != test.daysRemaining {
+			t.Errorf("tests[%d] - unexpected days remaining, expected=%d, got=%d\n", i, test.daysRemaining, days)
+		}
+	}
+}
+
+func Test_SubscriptionDiscount(t *testing.T) {
+	var (
+		method string
+		path   string
+		coupon string
+	)
+
+	periodEnd := time.Now().Add(time.Hour * 24).Unix()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		method = r.Method
+		path = r.URL.Path
+		coupon = r.PostForm.Get("coupon")
+
+		if r.Method == "DELETE" {
+			w.Write([]byte(`{"id": "di_123456", "deleted": true}`))
+			return
+		}
+
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"status": "active",
+			"cancel_at_period_end": true,
+			"current_period_end": ` + strconv.FormatInt(periodEnd, 10) + `,
+			"discount": {"coupon": {"id": "` + coupon + `", "percent_off": 20}}
+		}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_123456"},
+		EndsAt: sql.NullTime{
+			Time:  time.Unix(periodEnd, 0),
+			Valid: true,
+		},
+	}
+
+	if sub.Discount() != nil {
+		t.Fatal("expected subscription to have no discount")
+	}
+
+	if err := sub.ApplyCoupon(s, "RETAIN20"); err != nil {
+		t.Fatal(err)
+	}
+
+	if coupon != "RETAIN20" {
+		t.Fatalf("unexpected coupon, expected=%q, got=%q\n", "RETAIN20", coupon)
+	}
+
+	if d := sub.Discount(); d == nil || d.Coupon.ID != "RETAIN20" {
+		t.Fatalf("expected subscription to have the applied discount, got=%v\n", d)
+	}
+
+	if !sub.EndsAt.Valid || sub.EndsAt.Time.Unix() != periodEnd {
+		t.Fatalf("unexpected ends at, expected=%v, got=%v\n", time.Unix(periodEnd, 0), sub.EndsAt)
+	}
+
+	if err := sub.RemoveDiscount(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if method != "DELETE" || !strings.HasSuffix(path, "/v1/subscriptions/sub_123456/discount") {
+		t.Fatalf("unexpected request, expected=%s %s, got=%s %s\n", "DELETE", "/v1/subscriptions/sub_123456/discount", method, path)
+	}
+
+	if sub.Discount() != nil {
+		t.Fatal("expected discount to be removed")
+	}
+}
+
+func Test_SubscriptionWithinGrace(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		sub      *Subscription
+		expected bool
+	}{
+		{
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive, CancelAtPeriodEnd: true},
+				EndsAt:       sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+			},
+			true,
+		},
+		{
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive, CancelAt: now.Add(time.Hour).Unix()},
+				EndsAt:       sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+			},
+			true,
+		},
+		{
+			// A Subscription retrieved from a store will not have CancelAt.
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive},
+				EndsAt:       sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+			},
+			true,
+		},
+		{
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive, CancelAtPeriodEnd: true},
+				EndsAt:       sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+			},
+			false,
+		},
+		{
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusCanceled},
+				EndsAt:       sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+			},
+			false,
+		},
+		{
+			&Subscription{
+				Subscription: &stripe.Subscription{Status: stripe.SubscriptionStatusActive},
+			},
+			false,
+		},
+		{nil, false},
+	}
+
+	for i, test := range tests {
+		if grace := test.sub.WithinGrace(); grace != test.expected {
+			t.Errorf("tests[%d] - unexpected within grace, expected=%v, got=%v\n", i, test.expected, grace)
+		}
+	}
+}
+
+func Test_Resubscribe(t *testing.T) {
+	var form url.Values
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		w.Write([]byte(`{"id": "sub_123456", "customer": "cus_123456", "status": "active", "cancel_at_period_end": false}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusActive,
+			CancelAt: time.Now().Add(time.Hour).Unix(),
+		},
+		EndsAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	if err := s.Resubscribe(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := form["cancel_at"]; !ok || v[0] != "" {
+		t.Fatalf("expected cancel_at to be cleared, got=%v\n", form)
+	}
+
+	if form.Get("cancel_at_period_end") != "false" {
+		t.Fatalf("unexpected cancel_at_period_end, expected=%q, got=%q\n", "false", form.Get("cancel_at_period_end"))
+	}
+
+	sub, _, err := store.Subscription(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sub.EndsAt.Valid || sub.WithinGrace() {
+		t.Fatal("expected subscription to no longer be canceled")
+	}
+}
+
+func Test_UnsubscribeAt(t *testing.T) {
+	requests := 0
+
+	start := time.Now().Add(-time.Hour)
+	at := time.Now().Add(time.Hour * 24)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		r.ParseForm()
+
+		w.Write([]byte(`{
+			"id": "sub_123456",
+			"customer": "cus_123456",
+			"status": "active",
+			"current_period_start": ` + strconv.FormatInt(start.Unix(), 10) + `,
+			"cancel_at": ` + strconv.FormatInt(at.Unix(), 10) + `
+		}`))
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore()
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{
+			ID:       "sub_123456",
+			Customer: c.Customer,
+			Status:   stripe.SubscriptionStatusActive,
+		},
+	})
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	if _, err := s.UnsubscribeAt(c, time.Now().Add(-time.Hour)); err != ErrInvalidCancelAt {
+		t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrInvalidCancelAt, err)
+	}
+
+	sub, err := s.UnsubscribeAt(c, at)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sub.EndsAt.Valid || sub.EndsAt.Time.Unix() != at.Unix() {
+		t.Fatalf("unexpected ends at, expected=%v, got=%v\n", at, sub.EndsAt)
+	}
+
+	if !sub.WithinGrace() {
+		t.Fatal("expected subscription to be within grace")
+	}
+
+	requests = 0
+
+	if _, err := s.UnsubscribeAt(c, at.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != 0 {
+		t.Fatalf("expected no requests for a canceled subscription, got %d\n", requests)
+	}
+}
+
+func Test_ExtendTrial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Write([]byte(`{"id": "sub_123456", "status": "trialing", "trial_end": ` + r.PostForm.Get("trial_end") + `}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_123456", Status: stripe.SubscriptionStatusTrialing},
+	}
+
+	for _, until := range []time.Time{time.Now().Add(-time.Hour), time.Now()} {
+		if err := sub.ExtendTrial(s, until); err != ErrInvalidTrialEnd {
+			t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrInvalidTrialEnd, err)
+		}
+	}
+
+	until := time.Now().Add(time.Hour * 24 * 7)
+
+	if err := sub.ExtendTrial(s, until); err != nil {
+		t.Fatal(err)
+	}
+
+	if sub.TrialEnd != until.Unix() {
+		t.Fatalf("unexpected trial end, expected=%d, got=%d\n", until.Unix(), sub.TrialEnd)
+	}
+
+	now := time.Unix(until.Unix(), 0).Add(-time.Hour * 24 * 3)
+
+	if days := sub.TrialDaysRemaining(now); days != 3 {
+		t.Fatalf("unexpected trial days remaining, expected=%d, got=%d\n", 3, days)
+	}
+}
+
+func Test_OnSubscriptionChange(t *testing.T) {
+	end := strconv.FormatInt(time.Now().Add(time.Hour*24*30).Unix(), 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/payment_methods/pm_123456/attach"):
+			w.Write([]byte(`{"id": "pm_123456", "type": "card", "customer": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/customers/cus_123456"):
+			w.Write([]byte(`{"id": "cus_123456"}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/subscriptions"):
+			w.Write([]byte(`{
+				"id": "sub_123456",
+				"customer": "cus_123456",
+				"status": "active",
+				"current_period_end": ` + end + `,
+				"latest_invoice": {
+					"id": "in_123456",
+					"status": "paid",
+					"payment_intent": {"id": "pi_123456", "status": "succeeded"}
+				}
+			}`))
+		case strings.HasSuffix(r.URL.Path, "/v1/subscriptions/sub_123456"):
+			w.Write([]byte(`{
+				"id": "sub_123456",
+				"customer": "cus_123456",
+				"status": "active",
+				"current_period_end": ` + end + `,
+				"cancel_at_period_end": ` + r.PostForm.Get("cancel_at_period_end") + `
+			}`))
+		default:
+			t.Errorf("unexpected request %s %s\n", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", NewMemoryStore())
+	s.endpoint = srv.URL
+
+	type change struct {
+		old *Subscription
+		new *Subscription
+	}
+
+	changes := make([]change, 0)
+
+	s.OnSubscriptionChange(func(old, new *Subscription) {
+		changes = append(changes, change{old: old, new: new})
+	})
+
+	c := &Customer{
+		Customer: &stripe.Customer{ID: "cus_123456"},
+	}
+
+	pm := &PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456"},
+	}
+
+	if _, err := s.Subscribe(c, pm, Params{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Unsubscribe(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Resubscribe(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("unexpected subscription changes, expected=%d, got=%d\n", 3, len(changes))
+	}
+
+	if changes[0].old != nil || !changes[0].new.Active() {
+		t.Fatalf("expected new active subscription, got old=%v\n", changes[0].old)
+	}
+
+	if changes[1].old.EndsAt.Valid || !changes[1].new.WithinGrace() {
+		t.Fatal("expected subscription to be canceled at the end of the period")
+	}
+
+	if !changes[2].old.WithinGrace() || changes[2].new.EndsAt.Valid {
+		t.Fatal("expected subscription to be reactivated")
+	}
+}
+
+func Test_SubscriptionRenewal(t *testing.T) {
+	now := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		periodEnd    time.Time
+		expectedDays int
+	}{
+		{now.Add(time.Hour * 24 * 30), 30},
+		{now.Add(time.Hour*24*2 + time.Hour*23), 2},
+		{now.Add(time.Hour), 0},
+		{now.Add(-time.Hour * 24), 0},
+	}
+
+	for i, test := range tests {
+		sub := &Subscription{
+			Subscription: &stripe.Subscription{CurrentPeriodEnd: test.periodEnd.Unix()},
+		}
+
+		if date := sub.NextBillingDate(); !date.Equal(test.periodEnd) {
+			t.Errorf("tests[%d] - unexpected next billing date, expected=%v, got=%v\n", i, test.periodEnd, date)
+		}
+
+		if days := sub.DaysUntilRenewal(now); days != test.expectedDays {
+			t.Errorf("tests[%d] - unexpected days until renewal, expected=%d, got=%d\n", i, test.expectedDays, days)
+		}
+	}
+}
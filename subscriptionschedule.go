@@ -0,0 +1,169 @@
+package stripeutil
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// SubscriptionSchedule is the SubscriptionSchedule resource from Stripe.
+// Embedded in this struct is the stripe.SubscriptionSchedule struct from
+// Stripe. A schedule controls how a Subscription changes over time, such as
+// moving a Customer on to a different Price at their next renewal.
+type SubscriptionSchedule struct {
+	*stripe.SubscriptionSchedule
+}
+
+var (
+	_ Resource = (*SubscriptionSchedule)(nil)
+
+	subscriptionScheduleEndpoint = "/v1/subscription_schedules"
+
+	// ErrInvalidMigrateAt denotes when a Subscription is being migrated to a
+	// different Price at a time that is not in the future.
+	ErrInvalidMigrateAt = errors.New("invalid migrate at")
+
+	// ErrNoSchedulePhases denotes when a SubscriptionSchedule created from a
+	// Subscription has no phases to migrate from.
+	ErrNoSchedulePhases = errors.New("subscription schedule has no phases")
+)
+
+// CreateSubscriptionSchedule will create a new SubscriptionSchedule in Stripe
+// with the given request Params.
+func CreateSubscriptionSchedule(s *Stripe, params Params) (*SubscriptionSchedule, error) {
+	ss := &SubscriptionSchedule{}
+
+	err := s.post(subscriptionScheduleEndpoint, params, &ss.SubscriptionSchedule)
+	return ss, err
+}
+
+// Update will update the current SubscriptionSchedule with the given Params.
+func (ss *SubscriptionSchedule) Update(s *Stripe, params Params) error {
+	return s.post(ss.Endpoint(), params, &ss.SubscriptionSchedule)
+}
+
+// Release will release the current SubscriptionSchedule. The Subscription that
+// the schedule was managing will remain, but will no longer be managed by the
+// schedule.
+func (ss *SubscriptionSchedule) Release(s *Stripe) error {
+	return s.post(ss.Endpoint("release"), nil, &ss.SubscriptionSchedule)
+}
+
+// ScheduleMigration will migrate the current Subscription on to the Price of
+// the given ID at the given time, rather than immediately. This is done by
+// creating a SubscriptionSchedule from the Subscription, where the current
+// phase ends at the given time, and the next phase uses the new Price. Like
+// ChangePrice, the new Price replaces the Price of the first item of the
+// Subscription, and every other item is carried over to the next phase as is.
+// The schedule is released once the migration happens. If the given time is
+// not in the future then ErrInvalidMigrateAt is returned.
+//
+// If the created schedule has no phases, or its current phase has no items,
+// then ErrNoSchedulePhases, or ErrNoSubscriptionItems is returned. If the
+// schedule cannot be created with the phases for the migration, then the
+// schedule is released so the Subscription is left as it was.
+//
+// Typically, the given time would be the NextBillingDate of the Subscription,
+// so the Customer is moved on to the new Price when they next renew,
+//
+//     ss, err := sub.ScheduleMigration(stripe, "price_123456", sub.NextBillingDate())
+func (s *Subscription) ScheduleMigration(st *Stripe, priceID string, at time.Time) (*SubscriptionSchedule, error) {
+	if !at.After(time.Now()) {
+		return nil, ErrInvalidMigrateAt
+	}
+
+	ss, err := CreateSubscriptionSchedule(st, Params{
+		"from_subscription": s.ID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ss.migrate(st, priceID, at); err != nil {
+		ss.Release(st)
+		return nil, err
+	}
+	return ss, nil
+}
+
+// migrate updates the phases of the current SubscriptionSchedule so the first
+// item of the current phase is moved on to the Price of the given ID at the
+// given time.
+func (ss *SubscriptionSchedule) migrate(s *Stripe, priceID string, at time.Time) error {
+	if len(ss.Phases) == 0 {
+		return ErrNoSchedulePhases
+	}
+
+	curr := ss.Phases[0]
+
+	if len(curr.Items) == 0 {
+		return ErrNoSubscriptionItems
+	}
+
+	items := make([]Params, 0, len(curr.Items))
+	next := make([]Params, 0, len(curr.Items))
+
+	for i, it := range curr.Items {
+		item := Params{}
+
+		// Metered prices are billed on usage, so have no quantity.
+		if it.Quantity > 0 {
+			item["quantity"] = it.Quantity
+		}
+
+		if it.Price != nil {
+			item["price"] = it.Price.ID
+		}
+		items = append(items, item)
+
+		nextItem := Params{}
+
+		for k, v := range item {
+			nextItem[k] = v
+		}
+
+		if i == 0 {
+			nextItem["price"] = priceID
+		}
+		next = append(next, nextItem)
+	}
+
+	return ss.Update(s, Params{
+		"end_behavior": "release",
+		"phases": []Params{
+			{
+				"items":      items,
+				"start_date": curr.StartDate,
+				"end_date":   at.Unix(),
+			},
+			{
+				"items": next,
+			},
+		},
+	})
+}
+
+// Kind implements the Resource interface.
+func (ss *SubscriptionSchedule) Kind() string { return "subscription_schedule" }
+
+// Endpoint implements the Resource interface.
+func (ss *SubscriptionSchedule) Endpoint(uris ...string) string {
+	endpoint := subscriptionScheduleEndpoint
+
+	if ss.ID != "" {
+		endpoint += "/" + ss.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Load implements the Resource interface.
+func (ss *SubscriptionSchedule) Load(s *Stripe) error {
+	return s.get(ss.Endpoint(), &ss.SubscriptionSchedule)
+}
@@ -0,0 +1,130 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_ScheduleMigration(t *testing.T) {
+	at := time.Now().Add(time.Hour * 24 * 30)
+
+	var form map[string]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/v1/subscription_schedules") {
+			w.Write([]byte(`{
+				"id": "sub_sched_123456",
+				"phases": [{"start_date": 1000, "end_date": 2000, "items": [{"price": "price_old", "quantity": 2}, {"price": "price_addon", "quantity": 1}]}]
+			}`))
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/v1/subscription_schedules/sub_sched_123456") {
+			r.ParseForm()
+
+			form = make(map[string]string)
+
+			for k := range r.PostForm {
+				form[k] = r.PostForm.Get(k)
+			}
+			w.Write([]byte(`{"id": "sub_sched_123456"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"message": "Not found"}}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", newTestStore())
+	s.endpoint = srv.URL
+
+	sub := &Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_123456"},
+	}
+
+	if _, err := sub.ScheduleMigration(s, "price_new", time.Now().Add(-time.Hour)); err != ErrInvalidMigrateAt {
+		t.Fatalf("unexpected error, expected=%q, got=%q\n", ErrInvalidMigrateAt, err)
+	}
+
+	if _, err := sub.ScheduleMigration(s, "price_new", at); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"end_behavior":                  "release",
+		"phases[0][items][0][price]":    "price_old",
+		"phases[0][items][0][quantity]": "2",
+		"phases[0][start_date]":         "1000",
+		"phases[0][end_date]":           strconv.FormatInt(at.Unix(), 10),
+		"phases[1][items][0][price]":    "price_new",
+		"phases[1][items][0][quantity]": "2",
+		"phases[0][items][1][price]":    "price_addon",
+		"phases[0][items][1][quantity]": "1",
+		"phases[1][items][1][price]":    "price_addon",
+		"phases[1][items][1][quantity]": "1",
+	}
+
+	for k, v := range expected {
+		if form[k] != v {
+			t.Errorf("unexpected form value for %s, expected=%q, got=%q\n", k, v, form[k])
+		}
+	}
+}
+
+func Test_ScheduleMigrationRelease(t *testing.T) {
+	tests := []struct {
+		schedule    string
+		updateCode  int
+		expectedErr error
+	}{
+		{`{"id": "sub_sched_123456", "phases": []}`, http.StatusOK, ErrNoSchedulePhases},
+		{`{"id": "sub_sched_123456", "phases": [{"start_date": 1000, "end_date": 2000, "items": []}]}`, http.StatusOK, ErrNoSubscriptionItems},
+		{`{"id": "sub_sched_123456", "phases": [{"start_date": 1000, "end_date": 2000, "items": [{"price": "price_old", "quantity": 1}]}]}`, http.StatusBadRequest, nil},
+	}
+
+	for i, test := range tests {
+		released := false
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/v1/subscription_schedules"):
+				w.Write([]byte(test.schedule))
+			case strings.HasSuffix(r.URL.Path, "/v1/subscription_schedules/sub_sched_123456/release"):
+				released = true
+				w.Write([]byte(`{"id": "sub_sched_123456", "status": "released"}`))
+			case strings.HasSuffix(r.URL.Path, "/v1/subscription_schedules/sub_sched_123456"):
+				w.WriteHeader(test.updateCode)
+				w.Write([]byte(`{"error": {"message": "Invalid phases"}}`))
+			}
+		}))
+
+		s := New("sk_test_123456", nil)
+		s.endpoint = srv.URL
+
+		sub := &Subscription{
+			Subscription: &stripe.Subscription{ID: "sub_123456"},
+		}
+
+		_, err := sub.ScheduleMigration(s, "price_new", time.Now().Add(time.Hour))
+
+		srv.Close()
+
+		if err == nil {
+			t.Fatalf("tests[%d] - expected error, got nil\n", i)
+		}
+
+		if test.expectedErr != nil && err != test.expectedErr {
+			t.Fatalf("tests[%d] - unexpected error, expected=%q, got=%q\n", i, test.expectedErr, err)
+		}
+
+		if !released {
+			t.Fatalf("tests[%d] - expected subscription schedule to be released\n", i)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package stripeutil
+
+// BillingSummary is a summary of the billing state of a Customer.
+type BillingSummary struct {
+	Subscription    *Subscription  // Subscription is the Customer's Subscription, if any.
+	WithinGrace     bool           // WithinGrace is whether the Subscription was canceled but is in its grace period.
+	PaymentMethod   *PaymentMethod // PaymentMethod is the Customer's default PaymentMethod, if any.
+	Invoices        []*Invoice     // Invoices are the Customer's Invoices, sorted from newest to oldest.
+	UpcomingInvoice *Invoice       // UpcomingInvoice is the Customer's next Invoice, if any.
+}
+
+// BillingSummary returns a summary of the billing state for the given
+// Customer. The Subscription, default PaymentMethod, and Invoices are taken
+// from the underlying store. The upcoming Invoice is retrieved from Stripe,
+// only if the Customer has a Subscription that is valid and has not been
+// canceled.
+func (s *Stripe) BillingSummary(c *Customer) (*BillingSummary, error) {
+	sub, ok, err := s.Subscription(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sum := &BillingSummary{}
+
+	if ok {
+		sum.Subscription = sub
+		sum.WithinGrace = sub.WithinGrace()
+	}
+
+	pm, ok, err := s.DefaultPaymentMethod(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		sum.PaymentMethod = pm
+	}
+
+	sum.Invoices, err = s.Invoices(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sub.Valid() && !sub.EndsAt.Valid {
+		inv, err := RetrieveUpcomingInvoice(s, c)
+
+		if err != nil {
+			if e, ok := err.(*Error); !ok || e.Err.Code != "invoice_upcoming_none" {
+				return nil, err
+			}
+		}
+		sum.UpcomingInvoice = inv
+	}
+	return sum, nil
+}
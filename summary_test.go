@@ -0,0 +1,131 @@
+package stripeutil
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+func Test_BillingSummary(t *testing.T) {
+	upcoming := make([]string, 0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/invoices/upcoming") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+
+		customer := r.URL.Query().Get("customer")
+		upcoming = append(upcoming, customer)
+
+		if customer == "cus_abcdef" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "invoice_upcoming_none", "message": "No upcoming invoices for customer"}}`))
+			return
+		}
+		w.Write([]byte(`{"amount_due": 1000, "customer": "` + customer + `"}`))
+	}))
+	defer srv.Close()
+
+	store := newTestStore()
+
+	s := New("sk_test_123456", store)
+	s.endpoint = srv.URL
+
+	customers := map[string]*Customer{
+		"active":   {Customer: &stripe.Customer{ID: "cus_123456", Email: "active@example.com"}},
+		"grace":    {Customer: &stripe.Customer{ID: "cus_654321", Email: "grace@example.com"}},
+		"none":     {Customer: &stripe.Customer{ID: "cus_abcdef", Email: "none@example.com"}},
+		"inactive": {Customer: &stripe.Customer{ID: "cus_fedcba", Email: "inactive@example.com"}},
+	}
+
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_123456", Customer: customers["active"].Customer, Status: stripe.SubscriptionStatusActive},
+	})
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_654321", Customer: customers["grace"].Customer, Status: stripe.SubscriptionStatusActive},
+		EndsAt:       sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	store.Put(&Subscription{
+		Subscription: &stripe.Subscription{ID: "sub_abcdef", Customer: customers["none"].Customer, Status: stripe.SubscriptionStatusActive},
+	})
+
+	store.Put(&PaymentMethod{
+		PaymentMethod: &stripe.PaymentMethod{ID: "pm_123456", Customer: customers["active"].Customer},
+		Default:       true,
+	})
+
+	store.Put(&Invoice{
+		Invoice: &stripe.Invoice{ID: "in_123456", Number: "0001", Customer: customers["active"].Customer},
+	})
+
+	tests := []struct {
+		customer      string
+		subscription  string
+		withinGrace   bool
+		paymentMethod string
+		invoices      int
+		upcoming      bool
+	}{
+		{"active", "sub_123456", false, "pm_123456", 1, true},
+		{"grace", "sub_654321", true, "", 0, false},
+		{"none", "sub_abcdef", false, "", 0, false},
+		{"inactive", "", false, "", 0, false},
+	}
+
+	for i, test := range tests {
+		sum, err := s.BillingSummary(customers[test.customer])
+
+		if err != nil {
+			t.Fatalf("tests[%d] - %s\n", i, err)
+		}
+
+		if test.subscription == "" {
+			if sum.Subscription != nil {
+				t.Errorf("tests[%d] - expected no subscription, got=%q\n", i, sum.Subscription.ID)
+			}
+		} else if sum.Subscription == nil || sum.Subscription.ID != test.subscription {
+			t.Errorf("tests[%d] - unexpected subscription, expected=%q, got=%v\n", i, test.subscription, sum.Subscription)
+		}
+
+		if sum.WithinGrace != test.withinGrace {
+			t.Errorf("tests[%d] - unexpected within grace, expected=%v, got=%v\n", i, test.withinGrace, sum.WithinGrace)
+		}
+
+		if test.paymentMethod == "" {
+			if sum.PaymentMethod != nil {
+				t.Errorf("tests[%d] - expected no payment method, got=%q\n", i, sum.PaymentMethod.ID)
+			}
+		} else if sum.PaymentMethod == nil || sum.PaymentMethod.ID != test.paymentMethod {
+			t.Errorf("tests[%d] - unexpected payment method, expected=%q, got=%v\n", i, test.paymentMethod, sum.PaymentMethod)
+		}
+
+		if len(sum.Invoices) != test.invoices {
+			t.Errorf("tests[%d] - unexpected invoices, expected=%d, got=%d\n", i, test.invoices, len(sum.Invoices))
+		}
+
+		if (sum.UpcomingInvoice != nil) != test.upcoming {
+			t.Errorf("tests[%d] - unexpected upcoming invoice, expected=%v, got=%v\n", i, test.upcoming, sum.UpcomingInvoice)
+		}
+	}
+
+	// The upcoming Invoice is only requested for Customers with a valid
+	// Subscription that has not been canceled.
+	expected := []string{"cus_123456", "cus_abcdef"}
+
+	if len(upcoming) != len(expected) {
+		t.Fatalf("unexpected upcoming invoice requests, expected=%v, got=%v\n", expected, upcoming)
+	}
+
+	for i, customer := range expected {
+		if upcoming[i] != customer {
+			t.Errorf("upcoming[%d] - unexpected customer, expected=%q, got=%q\n", i, customer, upcoming[i])
+		}
+	}
+}
@@ -1,11 +1,9 @@
 package stripeutil
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"io"
-	"runtime"
 	"strings"
 	"sync"
 
@@ -34,48 +32,6 @@ var (
 	ErrUnknownJurisdiction = errors.New("unknown jurisdiction")
 )
 
-func getr(br *bufio.Reader) (rune, error) {
-	r, _, err := br.ReadRune()
-
-	if err != nil {
-		if err != io.EOF {
-			return -1, err
-		}
-		return -1, nil
-	}
-	return r, nil
-}
-
-func ungetr(br *bufio.Reader) { br.UnreadRune() }
-
-func skipline(br *bufio.Reader) error {
-	r, err := getr(br)
-
-	for r != '\n' {
-		if err != nil {
-			return err
-		}
-		r, err = getr(br)
-	}
-	return nil
-}
-
-func scanline(br *bufio.Reader) (string, error) {
-	buf := make([]rune, 0)
-
-	r, err := getr(br)
-
-	for r != '\n' && r != -1 {
-		if err != nil {
-			return "", err
-		}
-		buf = append(buf, r)
-
-		r, err = getr(br)
-	}
-	return string(buf), nil
-}
-
 // LoadTaxes will load in all of the tax rate IDs from the given io.Reader. It
 // is expected for each tax rate ID to be on its own separate line. Comments
 // (lines prefixed with #) are ignored. The given errh function is used for
@@ -93,42 +49,55 @@ func LoadTaxRates(r io.Reader, s *Stripe, errh func(error)) (*Taxes, error) {
 	return t, nil
 }
 
-func (t *Taxes) loadIds(r io.Reader) ([]string, error) {
-	br := bufio.NewReader(r)
-
-	ids := make([]string, 0)
+// LoadTaxRatesFromStripe will load in all of the active tax rates from Stripe,
+// as opposed to loading them in from a list of IDs. The given errh function is
+// used for handling any errors that arise when decoding an individual tax
+// rate, if not nil. Tax rates that cannot be decoded are skipped.
+func LoadTaxRatesFromStripe(s *Stripe, errh func(error)) (*Taxes, error) {
+	if errh == nil {
+		errh = func(error) {}
+	}
 
-	for {
-	redo:
-		r, err := getr(br)
+	t := &Taxes{
+		mu:    sync.RWMutex{},
+		ids:   make(map[string]struct{}),
+		rates: make(map[string]*TaxRate),
+	}
 
-		if err != nil {
-			return nil, err
+	err := s.list(taxRateEndpoint+"?active=true", func(raw json.RawMessage) error {
+		tr := &TaxRate{
+			TaxRate: &stripe.TaxRate{},
 		}
 
-		if r == -1 {
-			break
+		if err := s.unmarshal(raw, tr); err != nil {
+			errh(err)
+			return nil
 		}
 
-		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
-			goto redo
-		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
 
-		if r == '#' {
-			if err := skipline(br); err != nil {
-				return nil, err
-			}
-			goto redo
-		}
+		t.ids[tr.ID] = struct{}{}
+		t.rates[tr.Jurisdiction] = tr
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
 
-		ungetr(br)
+func (t *Taxes) loadIds(r io.Reader) ([]string, error) {
+	ids := make([]string, 0)
 
-		line, err := scanline(br)
+	err := scanlines(r, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
 
-		if err != nil {
-			return nil, err
-		}
-		ids = append(ids, line)
+	if err != nil {
+		return nil, err
 	}
 	return ids, nil
 }
@@ -145,49 +114,24 @@ func (t *Taxes) Reload(r io.Reader, s *Stripe, errh func(error)) error {
 		return err
 	}
 
-	sems := make(chan struct{}, runtime.GOMAXPROCS(0)+10)
-	errs := make(chan error)
-
-	rates := make([]*TaxRate, 0, len(ids))
-
-	var wg sync.WaitGroup
-	wg.Add(len(ids))
+	rs := make([]Resource, 0, len(ids))
 
 	for _, id := range ids {
-		tr := &TaxRate{
+		rs = append(rs, &TaxRate{
 			TaxRate: &stripe.TaxRate{
 				ID: id,
 			},
-		}
-
-		rates = append(rates, tr)
-
-		go func(tr *TaxRate, id string) {
-			sems <- struct{}{}
-			defer func() {
-				<-sems
-				wg.Done()
-			}()
-
-			if err := tr.Load(s); err != nil {
-				errs <- err
-			}
-		}(tr, id)
+		})
 	}
 
-	go func() {
-		wg.Wait()
-		close(errs)
-	}()
-
-	for e := range errs {
-		errh(e)
-	}
+	rs = loadResources(s, rs, errh)
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	for _, tr := range rates {
+	for _, r := range rs {
+		tr := r.(*TaxRate)
+
 		if _, ok := t.ids[tr.ID]; !ok {
 			t.ids[tr.ID] = struct{}{}
 			t.rates[tr.Jurisdiction] = tr
@@ -210,6 +154,9 @@ func (t *Taxes) Get(jurisdiction string) (*TaxRate, error) {
 	return tr, nil
 }
 
+// Kind implements the Resource interface.
+func (tr *TaxRate) Kind() string { return "tax_rate" }
+
 // Endpoint implements the Resource interface.
 func (tr *TaxRate) Endpoint(uris ...string) string {
 	endpoint := taxRateEndpoint
@@ -237,5 +184,5 @@ func (tr *TaxRate) Load(s *Stripe) error {
 	if !respCode2xx(resp.StatusCode) {
 		return s.Error(resp)
 	}
-	return json.NewDecoder(resp.Body).Decode(tr)
+	return s.decode(resp.Body, tr)
 }
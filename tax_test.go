@@ -3,7 +3,10 @@ package stripeutil
 import (
 	"bytes"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	stripelib "github.com/stripe/stripe-go/v72"
@@ -76,3 +79,88 @@ func Test_TaxRate(t *testing.T) {
 		t.Fatal(stripe.Error(resp1))
 	}
 }
+
+func Test_loadIds(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"txr_123456\ntxr_654321\n",
+			[]string{"txr_123456", "txr_654321"},
+		},
+		{
+			"# Comment\ntxr_123456  # UK VAT\n\ttxr_654321\t\n",
+			[]string{"txr_123456", "txr_654321"},
+		},
+		{
+			"txr_123456 # No trailing newline",
+			[]string{"txr_123456"},
+		},
+		{
+			"txr_123456\ntxr_654321",
+			[]string{"txr_123456", "txr_654321"},
+		},
+		{
+			"txr_123456\ntxr_654321\n\n\n",
+			[]string{"txr_123456", "txr_654321"},
+		},
+	}
+
+	for i, test := range tests {
+		var taxes Taxes
+
+		ids, err := taxes.loadIds(bytes.NewBufferString(test.input))
+
+		if err != nil {
+			t.Fatalf("tests[%d] - unexpected error: %s\n", i, err)
+		}
+
+		if len(ids) != len(test.expected) {
+			t.Fatalf("tests[%d] - unexpected ids, expected=%q, got=%q\n", i, test.expected, ids)
+		}
+
+		for j, id := range ids {
+			if id != test.expected[j] {
+				t.Errorf("tests[%d] - unexpected id, expected=%q, got=%q\n", i, test.expected[j], id)
+			}
+		}
+	}
+}
+
+func Test_LoadTaxRatesFromStripe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/v1/tax_rates") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+			return
+		}
+		w.Write([]byte(`{"has_more": false, "data": [{"id": "txr_123456", "jurisdiction": "GB", "percentage": 20}, {"id": "txr_654321", "jurisdiction": "DE", "percentage": "19"}]}`))
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	errs := 0
+
+	for _, errh := range []func(error){nil, func(error) { errs++ }} {
+		taxes, err := LoadTaxRatesFromStripe(s, errh)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := taxes.Get("GB"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := taxes.Get("DE"); err != ErrUnknownJurisdiction {
+			t.Fatalf("unexpected error, expected=%v, got=%v\n", ErrUnknownJurisdiction, err)
+		}
+	}
+
+	if errs != 1 {
+		t.Fatalf("unexpected errors, expected=%d, got=%d\n", 1, errs)
+	}
+}
@@ -0,0 +1,69 @@
+package stripeutil
+
+import (
+	"strings"
+	"time"
+)
+
+// TestClock is the TestClock resource from Stripe. Test clocks allow for
+// advancing time in test mode, so the lifecycle of a Subscription can be
+// tested without having to wait. A Customer can be created against a test
+// clock by passing its ID in the Params given to CustomerWithParams,
+//
+//     c, err := stripe.CustomerWithParams("me@example.com", stripeutil.Params{
+//         "test_clock": clock.ID,
+//     })
+type TestClock struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	FrozenTime int64  `json:"frozen_time"`
+	Livemode   bool   `json:"livemode"`
+}
+
+var (
+	_ Resource = (*TestClock)(nil)
+
+	testClockEndpoint = "/v1/test_helpers/test_clocks"
+)
+
+// CreateTestClock will create a new TestClock in Stripe that is frozen at the
+// given time.
+func CreateTestClock(s *Stripe, frozen time.Time) (*TestClock, error) {
+	tc := &TestClock{}
+
+	err := s.post(testClockEndpoint, Params{"frozen_time": frozen.Unix()}, tc)
+	return tc, err
+}
+
+// AdvanceTestClock will advance the TestClock of the given ID to the given
+// time. Advancing a TestClock happens asynchronously in Stripe, so the status
+// of the returned TestClock will be "advancing" until it is "ready".
+func AdvanceTestClock(s *Stripe, id string, to time.Time) (*TestClock, error) {
+	tc := &TestClock{
+		ID: id,
+	}
+
+	err := s.post(tc.Endpoint("advance"), Params{"frozen_time": to.Unix()}, tc)
+	return tc, err
+}
+
+// Kind implements the Resource interface.
+func (tc *TestClock) Kind() string { return "test_clock" }
+
+// Endpoint implements the Resource interface.
+func (tc *TestClock) Endpoint(uris ...string) string {
+	endpoint := testClockEndpoint
+
+	if tc.ID != "" {
+		endpoint += "/" + tc.ID
+	}
+
+	if len(uris) > 0 {
+		endpoint += "/"
+	}
+	return endpoint + strings.Join(uris, "/")
+}
+
+// Load implements the Resource interface.
+func (tc *TestClock) Load(s *Stripe) error { return s.get(tc.Endpoint(), tc) }
@@ -0,0 +1,77 @@
+package stripeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TestClock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		frozen := r.PostForm.Get("frozen_time")
+
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/v1/test_helpers/test_clocks"):
+			w.Write([]byte(`{"id": "clock_123456", "status": "ready", "frozen_time": ` + frozen + `}`))
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/v1/test_helpers/test_clocks/clock_123456/advance"):
+			w.Write([]byte(`{"id": "clock_123456", "status": "advancing", "frozen_time": ` + frozen + `}`))
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/v1/test_helpers/test_clocks/clock_123456"):
+			w.Write([]byte(`{"id": "clock_123456", "status": "ready", "frozen_time": 1609459200}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "Not found"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := New("sk_test_123456", nil)
+	s.endpoint = srv.URL
+
+	frozen := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tc, err := CreateTestClock(s, frozen)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tc.ID != "clock_123456" || tc.FrozenTime != frozen.Unix() {
+		t.Fatalf("unexpected test clock, expected=%q at %d, got=%q at %d\n", "clock_123456", frozen.Unix(), tc.ID, tc.FrozenTime)
+	}
+
+	to := frozen.AddDate(1, 0, 0)
+
+	tc, err = AdvanceTestClock(s, tc.ID, to)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tc.Status != "advancing" {
+		t.Fatalf("unexpected status, expected=%q, got=%q\n", "advancing", tc.Status)
+	}
+
+	if tc.FrozenTime != to.Unix() {
+		t.Fatalf("unexpected frozen time, expected=%d, got=%d\n", to.Unix(), tc.FrozenTime)
+	}
+
+	if err := tc.Load(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if tc.Status != "ready" {
+		t.Fatalf("unexpected status, expected=%q, got=%q\n", "ready", tc.Status)
+	}
+
+	if tc.FrozenTime != to.Unix() {
+		t.Fatalf("unexpected frozen time, expected=%d, got=%d\n", to.Unix(), tc.FrozenTime)
+	}
+
+	if _, err := AdvanceTestClock(s, "clock_654321", to); err == nil {
+		t.Fatal("expected error advancing unknown test clock")
+	}
+}